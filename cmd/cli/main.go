@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"gitlab-mr-reviewer/pkg/cfg"
+	"gitlab-mr-reviewer/pkg/cli"
 	"gitlab-mr-reviewer/pkg/logging"
 	"log"
 	"os"
@@ -13,26 +14,34 @@ func main() {
 	command := cfg.NewCommand()
 	config, err := cfg.NewCliConfig(command)
 	if err != nil {
-		handleError(command, err)
+		handleError(command, err, cli.ExitCodeConfigError)
 	}
 	logger, err := logging.NewZaprLogger(config.IsReleaseMode, config.LogLevel)
 	if err != nil {
-		handleError(command, err)
+		handleError(command, err, cli.ExitCodeConfigError)
 	}
 
 	injector, err := cfg.NewCliDependenciesInjector(config, logger)
 	if err != nil {
-		handleError(command, err)
+		handleError(command, err, cli.ExitCodeConfigError)
 	}
 
-	if err := injector.MergeRequestCommand.Run(); err != nil {
-		handleError(command, err)
+	switch config.Command {
+	case cfg.CommandServe:
+		err = injector.ServerCommand.Run()
+	default:
+		err = injector.MergeRequestCommand.Run()
+	}
+	if err != nil {
+		handleError(command, err, cli.ExitCode(err))
 	}
 }
 
-// handleError log error message to std and exit
-func handleError(command *cobra.Command, err error) {
+// handleError log error message to std and exit with the exit code, the usage is only printed for the config errors
+func handleError(command *cobra.Command, err error, exitCode int) {
 	log.Output(2, fmt.Sprintf("[ERROR] %s", err.Error()))
-	command.Help()
-	os.Exit(0)
+	if exitCode == cli.ExitCodeConfigError {
+		command.Usage()
+	}
+	os.Exit(exitCode)
 }
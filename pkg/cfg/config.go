@@ -6,39 +6,154 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gitlab-mr-reviewer/pkg/internal/domain"
 	"log"
 	"os"
 	"strings"
+	"time"
 )
 
 const (
 	name                 = "gitlab-mr-reviewer"
 	defaultConfigFileDir = "config/config.yaml"
+
+	CommandReview = name
+	CommandServe  = "serve"
+	CommandLocal  = "local"
+
+	CodeHostGitlab = "gitlab"
+	CodeHostGithub = "github"
+	CodeHostGitea  = "gitea"
+
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
 )
 
+// deprecatedKeys are the keys moved from the OpenAI section to the LLM section, which are still read as fallbacks
+var deprecatedKeys = map[string]string{
+	"OpenAI.SystemMessage":  "LLM.SystemMessage",
+	"OpenAI.Model":          "LLM.Model",
+	"OpenAI.MaxInputToken":  "LLM.MaxInputToken",
+	"OpenAI.MaxOutputToken": "LLM.MaxOutputToken",
+}
+
 type Config struct {
+	// Command is the name of the executed command
+	Command       string `mapstructure:"-"`
 	LogLevel      string `validate:"required,oneof=debug info warn error"`
 	IsReleaseMode bool
-	Gitlab        struct {
-		Url            string `validate:"required"`
-		Token          string `validate:"required"`
-		ProjectId      int32  `validate:"required_with_all=Gitlab.ProjectId Gitlab.MergeRequestId,omitempty,gte=1"`
-		MergeRequestId int32  `validate:"required_with_all=Gitlab.ProjectId Gitlab.MergeRequestId,omitempty,gte=1"`
-		PathFilters    []string
+	// FailOn is parsed by domain.ParseSeverity, which also accepts the former severity names
+	FailOn string `validate:"omitempty,oneof=critical high medium low info major minor"`
+	// Timeout is the timeout of the review by the review and the local commands, there is no timeout if it is 0
+	Timeout time.Duration `validate:"gte=0"`
+	// DryRun writes the review to the Output instead of posting it to Gitlab
+	DryRun struct {
+		Enabled bool
+		Format  string `validate:"oneof=markdown json"`
+		Output  string
 	}
-	OpenAI struct {
-		Token          string `validate:"required"`
+	Server struct {
+		Address       string `validate:"required"`
+		SecretToken   string
+		ReviewTimeout time.Duration `validate:"gt=0"`
+	}
+	// Metrics are served on /metrics by the webhook server, and pushed to the Pushgateway after a review by the CLI
+	Metrics struct {
+		// PushgatewayUrl is the Pushgateway-compatible endpoint, the metrics aren't pushed if it is empty
+		PushgatewayUrl string `validate:"omitempty,url"`
+		// Job is the job which the pushed metrics are grouped by
+		Job string `validate:"required"`
+	}
+	// CodeHost is where the merge requests are reviewed, gitlab, github or gitea
+	CodeHost string `validate:"required,oneof=gitlab github gitea"`
+	// Local is the diff of the working copy which the local command reviews
+	Local struct {
+		WorkDir string
+		Base    string
+		Head    string
+	}
+	Gitlab struct {
+		Url               string
+		Token             string
+		ProjectId         int32 `validate:"required_with_all=Gitlab.ProjectId Gitlab.MergeRequestId,omitempty,gte=1"`
+		MergeRequestId    int32 `validate:"required_with_all=Gitlab.ProjectId Gitlab.MergeRequestId,omitempty,gte=1"`
+		PerPage           int   `validate:"omitempty,gte=1,lte=100"`
+		PathFilters       []string
+		InlineComment     bool
+		IncrementalReview bool
+		// ConventionalCommits lists the commits which don't follow Conventional Commits in the summary note
+		ConventionalCommits bool
+		// ContextLines are the lines of the file around each hunk which are added to the prompt, 0 disables fetching the files
+		ContextLines int32 `validate:"gte=0"`
+	}
+	Github struct {
+		// Url is the base url of the GitHub API, e.g. https://github.example.com/api/v3 of GitHub Enterprise Server
+		Url     string `validate:"omitempty,url"`
+		Token   string
+		PerPage int `validate:"omitempty,gte=1,lte=100"`
+	}
+	// Gitea is a Gitea or Forgejo server
+	Gitea struct {
+		Url   string `validate:"omitempty,url"`
+		Token string
+	}
+	Retry struct {
+		MaxAttempts     int `validate:"gte=0"`
+		InitialInterval time.Duration
+		MaxInterval     time.Duration
+	}
+	LLM struct {
+		Provider       string `validate:"required,oneof=openai anthropic"`
 		SystemMessage  string `validate:"required"`
 		Model          string `validate:"required"`
 		MaxInputToken  int64  `validate:"required"`
 		MaxOutputToken int64  `validate:"required"`
+		// PromptDir is the directory of the `<name>.tmpl` files which override the default prompt templates
+		PromptDir string
+		// Stream receives the completions as they are generated, a completion is canceled if nothing is received within the IdleTimeout
+		Stream struct {
+			Enabled     bool
+			IdleTimeout time.Duration `validate:"required_if=Enabled true,gte=0"`
+			// FirstTokenTimeout replaces the IdleTimeout until the first token is received, it is the IdleTimeout if it is 0
+			FirstTokenTimeout time.Duration `validate:"gte=0"`
+		}
+		// Concurrency is the number of the batches of a merge request reviewed at the same time
+		Concurrency int `validate:"gte=1"`
+		// PerFile reviews every changed file in its own batch, so that a merge request fitting in a single batch is reviewed concurrently too
+		PerFile bool
+		// RateLimit is the limits of the provider, the requests wait until they are within the limits, 0 is unlimited
+		RateLimit struct {
+			RequestsPerMinute int64 `validate:"gte=0"`
+			TokensPerMinute   int64 `validate:"gte=0"`
+		}
+		// Prices are the prices of the models in USD per million tokens, which the token usage is priced with
+		Prices []domain.ModelPrice `validate:"dive"`
+		// UsageReport is the file which the token usage of every review is appended to as JSON Lines
+		UsageReport string
+		// Cache keeps the responses, so that the same request doesn't pay for the completion again
+		Cache struct {
+			Enabled bool
+			// Dir is the directory of the cached responses, it defaults to gitlab-mr-reviewer in the user cache directory, or the temp directory without it
+			Dir string
+			// TTL is how long the responses are kept, they are kept forever if it is 0
+			TTL time.Duration `validate:"gte=0"`
+		}
+	}
+	OpenAI struct {
+		// Url is the base url of the OpenAI-compatible API, e.g. http://localhost:11434/v1 of Ollama
+		Url   string `validate:"omitempty,url"`
+		Token string
+	}
+	Anthropic struct {
+		Url   string
+		Token string
 	}
 }
 
 func NewCommand() *cobra.Command {
 	var rootCmd = &cobra.Command{
-		Short: fmt.Sprintf("%s is able to generate merge-request review summaries using OpenAI", name),
-		Long:  fmt.Sprintf("%s is able to generate merge-request review summaries using OpenAI", name),
+		Short: fmt.Sprintf("%s is able to generate merge-request review summaries of Gitlab, GitHub or Gitea using OpenAI or Anthropic", name),
+		Long:  fmt.Sprintf("%s is able to generate merge-request review summaries of Gitlab, GitHub or Gitea using OpenAI or Anthropic", name),
 		Use:   name,
 		Run: func(cmd *cobra.Command, args []string) {
 			// Do Stuff Here
@@ -47,6 +162,20 @@ func NewCommand() *cobra.Command {
 			}
 		},
 	}
+	rootCmd.AddCommand(&cobra.Command{
+		Short: "Run a webhook server which reviews the merge requests on Gitlab merge request events",
+		Long:  "Run a webhook server which reviews the merge requests on Gitlab merge request events",
+		Use:   CommandServe,
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	})
+	rootCmd.AddCommand(&cobra.Command{
+		Short: "Review the diff between two revisions of a local git working copy, and print the review to the terminal",
+		Long:  "Review `git diff <base>...<head>` of a local git working copy without any Gitlab server, and print the review to the terminal",
+		Use:   CommandLocal,
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	})
 	helpFunc := rootCmd.HelpFunc()
 	rootCmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
 		helpFunc(cmd, args)
@@ -64,14 +193,49 @@ func NewCliConfig(rootCmd *cobra.Command) (*Config, error) {
 	rootCmd.PersistentFlags().Int32("merge-request", 0, "Gitlab MergeRequest ID, or use GITLAB_MERGEREQUESTID environment variable.")
 	rootCmd.PersistentFlags().String("gitlab-url", "", "Gitlab URL, or use GITLAB_URL environment variable.")
 	rootCmd.PersistentFlags().String("gitlab-token", "", "Gitlab authorization token, or use GITLAB_TOKEN environment variable.")
+	rootCmd.PersistentFlags().String("code-host", "", "Code host of the merge requests, gitlab, github or gitea, or use CODEHOST environment variable.")
+	rootCmd.PersistentFlags().String("github-url", "", "GitHub API URL, or use GITHUB_URL environment variable.")
+	rootCmd.PersistentFlags().String("github-token", "", "GitHub authorization token, or use GITHUB_TOKEN environment variable.")
+	rootCmd.PersistentFlags().String("gitea-url", "", "Gitea or Forgejo URL, or use GITEA_URL environment variable.")
+	rootCmd.PersistentFlags().String("gitea-token", "", "Gitea or Forgejo authorization token, or use GITEA_TOKEN environment variable.")
 	rootCmd.PersistentFlags().String("openai-token", "", "OpenAI authorization token, or use OPENAI_TOKEN environment variable.")
+	rootCmd.PersistentFlags().String("openai-url", "", "Base URL of the OpenAI-compatible API, or use OPENAI_URL environment variable.")
+	rootCmd.PersistentFlags().String("anthropic-token", "", "Anthropic API key, or use ANTHROPIC_TOKEN environment variable.")
+	rootCmd.PersistentFlags().String("llm-provider", "", "LLM provider, openai or anthropic, or use LLM_PROVIDER environment variable.")
+	rootCmd.PersistentFlags().String("prompt-dir", "", "Directory of the prompt templates which override the default ones, or use LLM_PROMPTDIR environment variable.")
+	rootCmd.PersistentFlags().String("usage-report", "", "File which the token usage of every review is appended to as JSON Lines, or use LLM_USAGEREPORT environment variable.")
+	rootCmd.PersistentFlags().Bool("no-cache", false, "Bypass the cache of the LLM responses.")
 	rootCmd.PersistentFlags().String("log", "info", "Log level, or use LOGLEVEL environment variable.")
 
-	err := rootCmd.Execute()
+	addReviewFlags(rootCmd)
+
+	serveCmd, _, err := rootCmd.Find([]string{CommandServe})
+	if err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to find serve cmd")
+	}
+	serveCmd.Flags().String("address", "", "Address the webhook server listens on, or use SERVER_ADDRESS environment variable.")
+	serveCmd.Flags().String("webhook-secret", "", "Secret token of the Gitlab webhook, or use SERVER_SECRETTOKEN environment variable.")
+
+	localCmd, _, err := rootCmd.Find([]string{CommandLocal})
+	if err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to find local cmd")
+	}
+	addReviewFlags(localCmd)
+	localCmd.Flags().String("repo", ".", "Directory of the git working copy, or use LOCAL_WORKDIR environment variable.")
+	localCmd.Flags().String("base", "main", "Revision which the changes are based on, or use LOCAL_BASE environment variable.")
+	localCmd.Flags().String("head", "HEAD", "Revision of the changes, or use LOCAL_HEAD environment variable.")
+
+	executedCmd, err := rootCmd.ExecuteC()
 	if err != nil {
 		return nil, errors.Wrap(err, "[NewCliConfig]failed to execute cmd")
 	}
 
+	// the review flags are bound to the executed command, since the root and the local commands both define them
+	reviewCmd := rootCmd
+	if executedCmd.Name() == CommandLocal {
+		reviewCmd = localCmd
+	}
+
 	v.SetConfigFile(configFilePath)
 	v.AutomaticEnv()
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
@@ -88,26 +252,148 @@ func NewCliConfig(rootCmd *cobra.Command) (*Config, error) {
 	if err := v.BindPFlag("Gitlab.Token", rootCmd.PersistentFlags().Lookup("gitlab-token")); err != nil {
 		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
 	}
+	if err := v.BindPFlag("CodeHost", rootCmd.PersistentFlags().Lookup("code-host")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Github.Url", rootCmd.PersistentFlags().Lookup("github-url")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Github.Token", rootCmd.PersistentFlags().Lookup("github-token")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Gitea.Url", rootCmd.PersistentFlags().Lookup("gitea-url")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Gitea.Token", rootCmd.PersistentFlags().Lookup("gitea-token")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
 	if err := v.BindPFlag("OpenAI.Token", rootCmd.PersistentFlags().Lookup("openai-token")); err != nil {
 		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
 	}
+	if err := v.BindPFlag("OpenAI.Url", rootCmd.PersistentFlags().Lookup("openai-url")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Anthropic.Token", rootCmd.PersistentFlags().Lookup("anthropic-token")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("LLM.Provider", rootCmd.PersistentFlags().Lookup("llm-provider")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("LLM.PromptDir", rootCmd.PersistentFlags().Lookup("prompt-dir")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("LLM.UsageReport", rootCmd.PersistentFlags().Lookup("usage-report")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
 	if err := v.BindPFlag("LogLevel", rootCmd.PersistentFlags().Lookup("log")); err != nil {
 		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
 	}
+	if err := v.BindPFlag("DryRun.Enabled", reviewCmd.Flags().Lookup("dry-run")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("DryRun.Format", reviewCmd.Flags().Lookup("dry-run-format")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("DryRun.Output", reviewCmd.Flags().Lookup("dry-run-output")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("FailOn", reviewCmd.Flags().Lookup("fail-on")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Timeout", reviewCmd.Flags().Lookup("timeout")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Local.WorkDir", localCmd.Flags().Lookup("repo")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Local.Base", localCmd.Flags().Lookup("base")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Local.Head", localCmd.Flags().Lookup("head")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Server.Address", serveCmd.Flags().Lookup("address")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
+	if err := v.BindPFlag("Server.SecretToken", serveCmd.Flags().Lookup("webhook-secret")); err != nil {
+		return nil, errors.Wrap(err, "[NewCliConfig]failed to bind flag")
+	}
 
 	if err := v.ReadInConfig(); err != nil {
 		return nil, errors.Wrap(err, "[NewCliConfig]failed to read config")
 	}
+	applyDeprecatedKeys(v)
 
 	var config Config
 	if err := v.Unmarshal(&config); err != nil {
 		return nil, errors.Wrap(err, "[NewCliConfig]failed to unmarshal config")
 	}
+	config.Command = executedCmd.Name()
+	// --no-cache only disables the cache, which is enabled by the config
+	if noCache, err := rootCmd.PersistentFlags().GetBool("no-cache"); err == nil && noCache {
+		config.LLM.Cache.Enabled = false
+	}
 
 	validate := validator.New(validator.WithRequiredStructEnabled())
 	if err := validate.Struct(config); err != nil {
 		return nil, errors.Wrap(err, "[NewCliConfig]failed to validate config")
 	}
+	if config.Command == CommandServe && len(config.Server.SecretToken) == 0 {
+		return nil, errors.New("[NewCliConfig]Server.SecretToken is required to serve the webhook")
+	}
+	// the webhook server only understands the merge request events of Gitlab
+	if config.Command == CommandServe && config.CodeHost != CodeHostGitlab {
+		return nil, errors.New("[NewCliConfig]serve only supports the gitlab code host")
+	}
+	// the local command reads the diff from git instead of the code host
+	if config.Command != CommandLocal && config.CodeHost == CodeHostGitlab && (len(config.Gitlab.Url) == 0 || len(config.Gitlab.Token) == 0) {
+		return nil, errors.New("[NewCliConfig]Gitlab.Url and Gitlab.Token are required to review the merge requests on Gitlab")
+	}
+	if config.Command != CommandLocal && config.CodeHost == CodeHostGithub && len(config.Github.Token) == 0 {
+		return nil, errors.New("[NewCliConfig]Github.Token is required to review the pull requests on GitHub")
+	}
+	if config.Command != CommandLocal && config.CodeHost == CodeHostGitea && (len(config.Gitea.Url) == 0 || len(config.Gitea.Token) == 0) {
+		return nil, errors.New("[NewCliConfig]Gitea.Url and Gitea.Token are required to review the pull requests on Gitea")
+	}
+	// the local OpenAI-compatible servers usually don't require authorization
+	if config.LLM.Provider == ProviderOpenAI && len(config.OpenAI.Url) == 0 && len(config.OpenAI.Token) == 0 {
+		return nil, errors.New("[NewCliConfig]OpenAI.Token is required by the openai provider without OpenAI.Url")
+	}
+	if config.LLM.Provider == ProviderAnthropic && len(config.Anthropic.Token) == 0 {
+		return nil, errors.New("[NewCliConfig]Anthropic.Token is required by the anthropic provider")
+	}
 
 	return &config, nil
 }
+
+// applyDeprecatedKeys fall back to the deprecated keys and their environment variables with a warning,
+// unless the new key is set by the same or a higher priority source
+func applyDeprecatedKeys(v *viper.Viper) {
+	for deprecatedKey, key := range deprecatedKeys {
+		_, deprecatedEnvSet := os.LookupEnv(envName(deprecatedKey))
+		_, envSet := os.LookupEnv(envName(key))
+		switch {
+		case deprecatedEnvSet && !envSet:
+			log.Printf("[WARN] %s is deprecated, use %s instead", envName(deprecatedKey), envName(key))
+		case v.InConfig(deprecatedKey) && !envSet && !v.InConfig(key):
+			log.Printf("[WARN] %s is deprecated, use %s instead", deprecatedKey, key)
+		default:
+			continue
+		}
+		v.Set(key, v.Get(deprecatedKey))
+	}
+}
+
+// envName return the environment variable of the key, the same as viper.AutomaticEnv looks up
+func envName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// addReviewFlags add the flags of a single review to the command
+func addReviewFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("dry-run", false, "Print the review instead of posting it to Gitlab, or use DRYRUN_ENABLED environment variable.")
+	cmd.Flags().String("dry-run-format", "markdown", "Format of the dry run output, markdown|json, or use DRYRUN_FORMAT environment variable.")
+	cmd.Flags().String("dry-run-output", "", "File which the dry run output is written to instead of stdout, or use DRYRUN_OUTPUT environment variable.")
+	cmd.Flags().Duration("timeout", 0, "Timeout of the review, 0 is no timeout, or use TIMEOUT environment variable.")
+	cmd.Flags().String("fail-on", "", "Exit with a non-zero code if any finding is at least the severity, critical|high|medium|low|info, or use FAILON environment variable.")
+}
@@ -1,36 +1,142 @@
 package cfg
 
 import (
+	"github.com/prometheus/client_golang/prometheus"
 	"gitlab-mr-reviewer/pkg/cli"
+	"gitlab-mr-reviewer/pkg/internal/domain"
 	"gitlab-mr-reviewer/pkg/internal/handler"
 	"gitlab-mr-reviewer/pkg/internal/repository"
 	"gitlab-mr-reviewer/pkg/internal/usecase"
 	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/metrics"
+	"gitlab-mr-reviewer/pkg/ratelimit"
+	"gitlab-mr-reviewer/pkg/retry"
+	"net/http"
+	"os"
+	"path/filepath"
 )
 
 type CliDependenciesInjector struct {
 	MergeRequestCommand cli.Command
+	ServerCommand       cli.Command
 }
 
 func NewCliDependenciesInjector(cfg *Config, logger *logging.ZaprLogger) (*CliDependenciesInjector, error) {
-	gitlabRepository := repository.NewGitlabRepository(logger, cfg.Gitlab.Url, cfg.Gitlab.Token)
-	openaiRepository := repository.NewOpenaiRepository(logger, cfg.OpenAI.Token)
+	codeHostRetryPolicy := retry.Policy{
+		MaxAttempts:     cfg.Retry.MaxAttempts,
+		InitialInterval: cfg.Retry.InitialInterval,
+		MaxInterval:     cfg.Retry.MaxInterval,
+	}
+	// a duplicated completion only costs tokens, unlike a duplicated note
+	llmRetryPolicy := codeHostRetryPolicy
+	llmRetryPolicy.RetryNonIdempotent = true
+	reviewerMetrics := metrics.NewReviewerMetrics()
+	codeHostHttpClient := newHttpClient(codeHostRetryPolicy, logger, reviewerMetrics.RequestDuration, metrics.ClientCodeHost)
+	llmHttpClient := newHttpClient(llmRetryPolicy, logger, reviewerMetrics.RequestDuration, metrics.ClientLLM)
+
+	var codeHostRepository repository.CodeHostRepository
+	projectId, mergeRequestId := cfg.Gitlab.ProjectId, cfg.Gitlab.MergeRequestId
+	switch {
+	case cfg.Command == CommandLocal:
+		codeHostRepository = repository.NewLocalGitRepository(logger, cfg.Local.WorkDir, cfg.Local.Base, cfg.Local.Head, os.Stdout)
+		projectId, mergeRequestId = repository.LocalProjectId, repository.LocalMergeRequestId
+	case cfg.CodeHost == CodeHostGithub:
+		codeHostRepository = repository.NewGithubRepository(logger, codeHostHttpClient, cfg.Github.Url, cfg.Github.Token, cfg.Github.PerPage)
+	case cfg.CodeHost == CodeHostGitea:
+		codeHostRepository = repository.NewGiteaRepository(logger, codeHostHttpClient, cfg.Gitea.Url, cfg.Gitea.Token)
+	default:
+		codeHostRepository = repository.NewGitlabRepository(logger, codeHostHttpClient, cfg.Gitlab.Url, cfg.Gitlab.Token, cfg.Gitlab.PerPage)
+	}
+	streamOption := repository.StreamOption{
+		Enabled:           cfg.LLM.Stream.Enabled,
+		IdleTimeout:       cfg.LLM.Stream.IdleTimeout,
+		FirstTokenTimeout: cfg.LLM.Stream.FirstTokenTimeout,
+	}
+	// the progress is only for the CI logs, stdout is kept for the dry run and the local review
+	if cfg.Command != CommandServe {
+		streamOption.Progress = os.Stderr
+	}
+	var llmRepository repository.LLMRepository
+	switch cfg.LLM.Provider {
+	case ProviderAnthropic:
+		llmRepository = repository.NewAnthropicRepository(logger, llmHttpClient, cfg.Anthropic.Url, cfg.Anthropic.Token, streamOption)
+	default:
+		llmRepository = repository.NewOpenaiRepository(logger, llmHttpClient, cfg.OpenAI.Url, cfg.OpenAI.Token, streamOption)
+	}
+
+	llmRepository = repository.NewMetricsLLMRepository(llmRepository, reviewerMetrics.Tokens)
 
-	mergeRequestReviewer, err := usecase.NewGitlabMergeRequestReviewer(logger, cfg.OpenAI.SystemMessage, cfg.Gitlab.PathFilters, gitlabRepository, openaiRepository)
+	// the cached responses don't count for the rate limit
+	if cfg.LLM.RateLimit.RequestsPerMinute > 0 || cfg.LLM.RateLimit.TokensPerMinute > 0 {
+		llmRepository = repository.NewRateLimitedLLMRepository(llmRepository, ratelimit.NewLimiter(cfg.LLM.RateLimit.RequestsPerMinute, cfg.LLM.RateLimit.TokensPerMinute))
+	}
+	if cfg.LLM.Cache.Enabled {
+		cacheDir := cfg.LLM.Cache.Dir
+		if len(cacheDir) == 0 {
+			// the containers of the CI jobs often have no HOME
+			userCacheDir, err := os.UserCacheDir()
+			if err != nil {
+				userCacheDir = os.TempDir()
+			}
+			cacheDir = filepath.Join(userCacheDir, name)
+		}
+		llmRepository = repository.NewCachedLLMRepository(llmRepository, repository.NewFileLLMCache(cacheDir, cfg.LLM.Cache.TTL), logger)
+	}
+
+	// the webhook server always posts, the dry run only applies to a single review
+	var dryRunRecord *repository.DryRunRecord
+	if cfg.DryRun.Enabled && cfg.Command != CommandServe {
+		dryRunRecord = &repository.DryRunRecord{}
+		codeHostRepository = repository.NewDryRunCodeHostRepository(codeHostRepository, dryRunRecord)
+		llmRepository = repository.NewDryRunLLMRepository(llmRepository, dryRunRecord)
+	}
+
+	promptTemplates, err := usecase.NewPromptTemplates(cfg.LLM.PromptDir)
+	if err != nil {
+		return nil, err
+	}
+	mergeRequestReviewer, err := usecase.NewMergeRequestReviewer(logger, cfg.LLM.SystemMessage, promptTemplates, cfg.Gitlab.PathFilters, cfg.Gitlab.InlineComment, cfg.Gitlab.IncrementalReview, cfg.Gitlab.ConventionalCommits, cfg.Gitlab.ContextLines, cfg.LLM.Prices, cfg.LLM.Concurrency, cfg.LLM.PerFile, codeHostRepository, llmRepository, repository.NewFileUsageReportRepository(cfg.LLM.UsageReport))
 	if err != nil {
 		return nil, err
 	}
 
-	mergeRequestHandler := handler.NewMergeRequestHandler(logger, mergeRequestReviewer)
+	mergeRequestHandler := handler.NewMergeRequestHandler(logger, mergeRequestReviewer, reviewerMetrics.Reviews)
 
 	mergeRequestCommand := cli.NewMergeRequestCommand(
-		cfg.Gitlab.ProjectId, cfg.Gitlab.MergeRequestId,
-		cfg.OpenAI.Model, cfg.OpenAI.MaxInputToken, cfg.OpenAI.MaxOutputToken,
+		projectId, mergeRequestId,
+		cfg.LLM.Model, cfg.LLM.MaxInputToken, cfg.LLM.MaxOutputToken,
+		domain.ParseSeverity(cfg.FailOn),
+		cfg.Timeout,
+		logger,
+		mergeRequestHandler,
+	)
+	if dryRunRecord != nil {
+		mergeRequestCommand = cli.NewDryRunCommand(mergeRequestCommand, dryRunRecord, cfg.DryRun.Format, cfg.DryRun.Output, logger)
+	}
+	if len(cfg.Metrics.PushgatewayUrl) > 0 {
+		// the push isn't retried, since it is only for monitoring and the next run replaces the metrics anyway
+		mergeRequestCommand = cli.NewPushMetricsCommand(mergeRequestCommand, reviewerMetrics, &http.Client{}, cfg.Metrics.PushgatewayUrl, cfg.Metrics.Job, logger)
+	}
+
+	mergeRequestWebhookHandler := handler.NewMergeRequestWebhookHandler(
 		logger,
+		cfg.Server.SecretToken,
+		cfg.LLM.Model, cfg.LLM.MaxInputToken, cfg.LLM.MaxOutputToken,
+		cfg.Server.ReviewTimeout,
 		mergeRequestHandler,
 	)
 
+	serverCommand := cli.NewServerCommand(cfg.Server.Address, logger, mergeRequestWebhookHandler, reviewerMetrics.Handler())
+
 	return &CliDependenciesInjector{
 		MergeRequestCommand: mergeRequestCommand,
+		ServerCommand:       serverCommand,
 	}, nil
 }
+
+// newHttpClient return a http.Client which retries with the policy, and observes the duration of every attempt
+func newHttpClient(policy retry.Policy, logger *logging.ZaprLogger, requestDuration *prometheus.HistogramVec, client string) *http.Client {
+	return &http.Client{
+		Transport: retry.NewTransport(metrics.NewTransport(http.DefaultTransport, requestDuration, client), policy, logger),
+	}
+}
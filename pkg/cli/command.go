@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"gitlab-mr-reviewer/pkg/internal/domain"
 	"gitlab-mr-reviewer/pkg/internal/handler"
 	"gitlab-mr-reviewer/pkg/internal/usecase"
 	"gitlab-mr-reviewer/pkg/logging"
@@ -18,6 +19,8 @@ type MergeRequestCommand struct {
 	model               string
 	maxInputToken       int64
 	maxOutputToken      int64
+	failOn              domain.Severity
+	timeout             time.Duration
 	logger              *logging.ZaprLogger
 	mergeRequestHandler handler.MergeRequestHandler
 }
@@ -28,6 +31,8 @@ func NewMergeRequestCommand(
 	model string,
 	maxInputToken int64,
 	maxOutputToken int64,
+	failOn domain.Severity,
+	timeout time.Duration,
 	logger *logging.ZaprLogger,
 	mergeRequestHandler handler.MergeRequestHandler) Command {
 	return &MergeRequestCommand{
@@ -36,6 +41,8 @@ func NewMergeRequestCommand(
 		model:          model,
 		maxInputToken:  maxInputToken,
 		maxOutputToken: maxOutputToken,
+		failOn:         failOn,
+		timeout:        timeout,
 
 		logger:              logger,
 		mergeRequestHandler: mergeRequestHandler,
@@ -43,7 +50,11 @@ func NewMergeRequestCommand(
 }
 
 func (c *MergeRequestCommand) Run() error {
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 1*time.Minute)
+	// the merge requests of many batches take minutes, so the review only times out if the timeout is given
+	ctx, cancelFunc := context.Background(), context.CancelFunc(func() {})
+	if c.timeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, c.timeout)
+	}
 	defer cancelFunc()
 	err := c.mergeRequestHandler.Review(ctx, &usecase.MergeRequestReviewInput{
 		ProjectId:      c.projectId,
@@ -51,6 +62,7 @@ func (c *MergeRequestCommand) Run() error {
 		Model:          c.model,
 		MaxInputToken:  c.maxInputToken,
 		MaxOutputToken: c.maxOutputToken,
+		FailOn:         c.failOn,
 	})
 	if err != nil {
 		return err
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/internal/repository"
+	"gitlab-mr-reviewer/pkg/logging"
+	"io"
+	"os"
+)
+
+const (
+	DryRunFormatMarkdown = "markdown"
+	DryRunFormatJSON     = "json"
+)
+
+type DryRunCommand struct {
+	command Command
+	record  *repository.DryRunRecord
+	format  string
+	output  string
+	logger  *logging.ZaprLogger
+}
+
+// NewDryRunCommand run the command whose repositories record instead of posting, and write the record to the output file, or stdout if it is empty
+func NewDryRunCommand(
+	command Command,
+	record *repository.DryRunRecord,
+	format string,
+	output string,
+	logger *logging.ZaprLogger) Command {
+	return &DryRunCommand{
+		command: command,
+		record:  record,
+		format:  format,
+		output:  output,
+		logger:  logger,
+	}
+}
+
+func (c *DryRunCommand) Run() error {
+	// the record is written even if the review fails, since the prompts help to find out why
+	runErr := c.command.Run()
+
+	var content []byte
+	switch c.format {
+	case DryRunFormatJSON:
+		var err error
+		if content, err = c.record.JSON(); err != nil {
+			return errors.Wrap(err, "Failed to render dry run record")
+		}
+	default:
+		content = []byte(c.record.Markdown())
+	}
+
+	var writer io.Writer = os.Stdout
+	if len(c.output) > 0 {
+		file, err := os.Create(c.output)
+		if err != nil {
+			return errors.Wrap(err, "Failed to create dry run output")
+		}
+		defer file.Close()
+		writer = file
+	}
+	if _, err := fmt.Fprintln(writer, string(content)); err != nil {
+		return errors.Wrap(err, "Failed to write dry run record")
+	}
+	if len(c.output) > 0 {
+		c.logger.Info(fmt.Sprintf("Wrote the dry run record to %s", c.output))
+	}
+
+	return runErr
+}
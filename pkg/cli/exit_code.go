@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/internal/handler"
+	"gitlab-mr-reviewer/pkg/internal/usecase"
+	"gitlab-mr-reviewer/pkg/retry"
+	"net"
+)
+
+// The exit codes tell the failures apart, so that the CI job can be allowed to fail on some of them only
+const (
+	ExitCodeSuccess = 0
+	// ExitCodeError is the unexpected failure
+	ExitCodeError = 1
+	// ExitCodeFindingsFound means the review succeeds, and finds the issues at the fail-on severity
+	ExitCodeFindingsFound = 2
+	// ExitCodeConfigError is the invalid config, project config, flags, or the token rejected by Gitlab
+	ExitCodeConfigError = 3
+	// ExitCodeNetworkError is the failure to reach Gitlab, or Gitlab is unavailable
+	ExitCodeNetworkError = 4
+	// ExitCodeLLMError is the failure of the LLM provider
+	ExitCodeLLMError = 5
+	// ExitCodePartialReview means the review is posted, but some of the batches failed to be reviewed
+	ExitCodePartialReview = 6
+)
+
+// ExitCode return the exit code of the error returned by Command.Run
+func ExitCode(err error) int {
+	var llmError *usecase.LLMError
+	var netError net.Error
+	switch {
+	case err == nil:
+		return ExitCodeSuccess
+	// the findings come first, since the partial review fails with them too if they are at the fail-on severity
+	case errors.Is(err, handler.ErrorFindingsFound):
+		return ExitCodeFindingsFound
+	case errors.Is(err, usecase.ErrorPartialReview):
+		return ExitCodePartialReview
+	case errors.Is(err, handler.ErrorInvalidInput), errors.Is(err, usecase.ErrorInvalidProjectConfig):
+		return ExitCodeConfigError
+	case errors.As(err, &llmError):
+		return ExitCodeLLMError
+	case errors.Is(err, retry.ErrorUnauthorized), errors.Is(err, retry.ErrorForbidden), errors.Is(err, retry.ErrorNotFound):
+		return ExitCodeConfigError
+	case errors.Is(err, retry.ErrorRateLimited), errors.Is(err, retry.ErrorServer),
+		errors.Is(err, context.DeadlineExceeded), errors.As(err, &netError):
+		return ExitCodeNetworkError
+	default:
+		return ExitCodeError
+	}
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/metrics"
+	"net/http"
+	"time"
+)
+
+type PushMetricsCommand struct {
+	command         Command
+	reviewerMetrics *metrics.ReviewerMetrics
+	httpClient      *http.Client
+	pushgatewayUrl  string
+	job             string
+	logger          *logging.ZaprLogger
+}
+
+// NewPushMetricsCommand run the command, and push the metrics of the run to the Pushgateway, since a CLI run doesn't live long enough to be scraped
+func NewPushMetricsCommand(
+	command Command,
+	reviewerMetrics *metrics.ReviewerMetrics,
+	httpClient *http.Client,
+	pushgatewayUrl string,
+	job string,
+	logger *logging.ZaprLogger) Command {
+	return &PushMetricsCommand{
+		command:         command,
+		reviewerMetrics: reviewerMetrics,
+		httpClient:      httpClient,
+		pushgatewayUrl:  pushgatewayUrl,
+		job:             job,
+		logger:          logger,
+	}
+}
+
+func (c *PushMetricsCommand) Run() error {
+	// the metrics are pushed even if the review fails, so that the failures are counted
+	runErr := c.command.Run()
+
+	// the metrics are only for monitoring, so a failed push doesn't fail the review
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+	if err := c.reviewerMetrics.Push(ctx, c.httpClient, c.pushgatewayUrl, c.job); err != nil {
+		c.logger.WithError(err).Warn(fmt.Sprintf("Failed to push metrics to %s", c.pushgatewayUrl))
+	}
+
+	return runErr
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/internal/handler"
+	"gitlab-mr-reviewer/pkg/logging"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type ServerCommand struct {
+	address        string
+	logger         *logging.ZaprLogger
+	webhookHandler handler.WebhookHandler
+	metricsHandler http.Handler
+}
+
+func NewServerCommand(
+	address string,
+	logger *logging.ZaprLogger,
+	webhookHandler handler.WebhookHandler,
+	metricsHandler http.Handler) Command {
+	return &ServerCommand{
+		address:        address,
+		logger:         logger,
+		webhookHandler: webhookHandler,
+		metricsHandler: metricsHandler,
+	}
+}
+
+func (c *ServerCommand) Run() error {
+	serveMux := http.NewServeMux()
+	serveMux.Handle("POST /webhook", c.webhookHandler)
+	serveMux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serveMux.Handle("GET /metrics", c.metricsHandler)
+
+	// the webhook is answered before the review, so that none of the requests takes long
+	server := &http.Server{
+		Addr:              c.address,
+		Handler:           serveMux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		c.logger.Info(fmt.Sprintf("Listening on %s", c.address))
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return errors.Wrap(err, "Failed to serve")
+	case <-ctx.Done():
+	}
+
+	c.logger.Info("Shutting down.")
+	shutdownCtx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return errors.Wrap(err, "Failed to shutdown server")
+	}
+	// the reviews are bounded by the review timeout
+	c.logger.Info("Waiting for the reviews in progress.")
+	c.webhookHandler.Wait()
+	c.logger.Info("Finished.")
+
+	return nil
+}
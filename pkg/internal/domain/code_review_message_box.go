@@ -3,7 +3,6 @@ package domain
 import (
 	"github.com/pkg/errors"
 	"gitlab-mr-reviewer/pkg/utils"
-	"slices"
 )
 
 const (
@@ -15,8 +14,6 @@ const (
 	ModelGPT4OMini = "gpt-4o-mini"
 )
 
-var models = []string{ModelGPT4O, ModelGPT4OMini}
-
 type CodeReviewMessagebox struct {
 	Message        []Message
 	MaxInputToken  int64
@@ -36,8 +33,9 @@ func NewCodeReviewMessageBox(systemMessage string, model string, maxInputToken i
 	if maxOutputToken <= 0 {
 		maxOutputToken = 10000
 	}
-	if !slices.Contains(models, model) {
-		return nil, errors.Errorf("Not allow model %s, only accept models %s", model, models)
+	// any model served by the provider is accepted, e.g. the local models of Ollama
+	if len(model) == 0 {
+		return nil, errors.New("Model is required")
 	}
 
 	return &CodeReviewMessagebox{
@@ -73,9 +71,14 @@ func (c *CodeReviewMessagebox) AppendMessage(messages []Message) {
 	c.Message = append(c.Message, messages...)
 }
 
+// CountTokens count the tokens of the content with the encoding of the Model
+func (c *CodeReviewMessagebox) CountTokens(content string) (int64, error) {
+	return utils.CountTokens(c.Model, content)
+}
+
 func (c *CodeReviewMessagebox) AddUserMessage(content string) error {
 	m := NewUserMessage(content)
-	countTokens, err := utils.CountTokens(c.Model, m.Content)
+	countTokens, err := c.CountTokens(m.Content)
 	if err != nil {
 		return errors.Wrap(err, "Unable to count tokens")
 	}
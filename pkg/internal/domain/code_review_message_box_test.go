@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("CodeReviewMessagebox", func() {
+	ginkgo.It("should accept the models unknown to tiktoken", func() {
+		box, err := NewCodeReviewMessageBox("You are a reviewer.", "qwen2.5-coder:32b", 100, 100)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		count, err := box.CountTokens("func main() {}")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(count).To(gomega.BeNumerically(">", 0))
+		gomega.Expect(box.AddUserMessage("Summarize the changes.")).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should reject empty model", func() {
+		_, err := NewCodeReviewMessageBox("You are a reviewer.", "", 100, 100)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+})
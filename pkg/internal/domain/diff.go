@@ -0,0 +1,210 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	LineContext LineType = iota
+	LineAdded
+	LineRemoved
+)
+
+// hunkHeaderPrefix starts the header of every hunk
+const hunkHeaderPrefix = "@@ "
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@(.*)$`)
+
+type LineType int
+
+// Hunk is a block of the unified diff, see https://www.gnu.org/software/diffutils/manual/html_node/Detailed-Unified.html
+type Hunk struct {
+	OldStart int32
+	OldLines int32
+	NewStart int32
+	NewLines int32
+	Section  string
+	Lines    []DiffLine
+}
+
+type DiffLine struct {
+	Type    LineType
+	Content string
+	// OldLine is the line number in the old file, 0 if the line is added
+	OldLine int32
+	// NewLine is the line number in the new file, 0 if the line is removed
+	NewLine int32
+}
+
+// ParseDiff parse the unified diff of a single file into hunks
+func ParseDiff(diff string) ([]Hunk, error) {
+	var hunks []Hunk
+	var oldLine, newLine int32
+	for i, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, hunkHeaderPrefix) {
+			hunk, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid hunk header at line %d", i+1)
+			}
+			hunks = append(hunks, hunk)
+			oldLine, newLine = hunk.OldStart, hunk.NewStart
+			continue
+		}
+		if len(hunks) == 0 {
+			// skip the file header such as `--- a/file` and `+++ b/file`
+			continue
+		}
+
+		hunk := &hunks[len(hunks)-1]
+		switch {
+		case strings.HasPrefix(line, "+"):
+			hunk.Lines = append(hunk.Lines, DiffLine{Type: LineAdded, Content: line[1:], NewLine: newLine})
+			newLine++
+		case strings.HasPrefix(line, "-"):
+			hunk.Lines = append(hunk.Lines, DiffLine{Type: LineRemoved, Content: line[1:], OldLine: oldLine})
+			oldLine++
+		case strings.HasPrefix(line, " "):
+			hunk.Lines = append(hunk.Lines, DiffLine{Type: LineContext, Content: line[1:], OldLine: oldLine, NewLine: newLine})
+			oldLine++
+			newLine++
+		case strings.HasPrefix(line, "\\"):
+			// `\ No newline at end of file`
+		case len(line) == 0:
+			// the trailing line break of the diff, or an empty context line trimmed by the server
+			if oldLine < hunk.OldStart+hunk.OldLines && newLine < hunk.NewStart+hunk.NewLines {
+				hunk.Lines = append(hunk.Lines, DiffLine{Type: LineContext, OldLine: oldLine, NewLine: newLine})
+				oldLine++
+				newLine++
+			}
+		default:
+			return nil, errors.Errorf("Invalid diff line %d: %q", i+1, line)
+		}
+	}
+	return hunks, nil
+}
+
+func parseHunkHeader(line string) (Hunk, error) {
+	matches := hunkHeaderRegexp.FindStringSubmatch(line)
+	if matches == nil {
+		return Hunk{}, errors.Errorf("%q doesn't match %s", line, hunkHeaderRegexp)
+	}
+
+	var numbers [4]int32
+	for i, match := range matches[1:5] {
+		if len(match) == 0 {
+			// the line count is omitted when it is 1
+			numbers[i] = 1
+			continue
+		}
+		number, err := strconv.ParseInt(match, 10, 32)
+		if err != nil {
+			return Hunk{}, err
+		}
+		numbers[i] = int32(number)
+	}
+
+	return Hunk{
+		OldStart: numbers[0],
+		OldLines: numbers[1],
+		NewStart: numbers[2],
+		NewLines: numbers[3],
+		Section:  strings.TrimSpace(matches[5]),
+	}, nil
+}
+
+// Header render the hunk header, e.g. `@@ -1,3 +1,4 @@ func main() {`
+func (h Hunk) Header() string {
+	header := fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+	if len(h.Section) > 0 {
+		header += " " + h.Section
+	}
+	return header
+}
+
+// String render the hunk in the unified diff format
+func (h Hunk) String() string {
+	builder := strings.Builder{}
+	builder.WriteString(h.Header())
+	builder.WriteString("\n")
+	for _, line := range h.Lines {
+		builder.WriteString(line.Marker())
+		builder.WriteString(line.Content)
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// AnnotatedString render the hunk with the line number of the new file at the beginning of each line
+func (h Hunk) AnnotatedString() string {
+	builder := strings.Builder{}
+	builder.WriteString(h.Header())
+	builder.WriteString("\n")
+	for _, line := range h.Lines {
+		if line.Type == LineRemoved {
+			builder.WriteString(fmt.Sprintf("%5s %s%s\n", "", line.Marker(), line.Content))
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("%5d %s%s\n", line.NewLine, line.Marker(), line.Content))
+	}
+	return builder.String()
+}
+
+// FindNewLine return the line which is at the given line number of the new file
+func (h Hunk) FindNewLine(newLine int32) (DiffLine, bool) {
+	for _, line := range h.Lines {
+		if line.Type != LineRemoved && line.NewLine == newLine {
+			return line, true
+		}
+	}
+	return DiffLine{}, false
+}
+
+func (l DiffLine) Marker() string {
+	switch l.Type {
+	case LineAdded:
+		return "+"
+	case LineRemoved:
+		return "-"
+	default:
+		return " "
+	}
+}
+
+// Hunks parse the Diff of the change into hunks
+func (c RelativeChange) Hunks() ([]Hunk, error) {
+	return ParseDiff(c.Diff)
+}
+
+// AnnotateLineNumbers return a copy of the change which Diff is annotated with the line numbers of the new file,
+// the Diff is kept as is if it can't be parsed
+func (c RelativeChange) AnnotateLineNumbers() RelativeChange {
+	hunks, err := c.Hunks()
+	if err != nil || len(hunks) == 0 {
+		return c
+	}
+
+	builder := strings.Builder{}
+	for _, hunk := range hunks {
+		builder.WriteString(hunk.AnnotatedString())
+	}
+	c.Diff = builder.String()
+	return c
+}
+
+// FindNewLine return the line of the diff which is at the given line number of the new file
+func (c RelativeChange) FindNewLine(newLine int32) (DiffLine, bool) {
+	hunks, err := c.Hunks()
+	if err != nil {
+		return DiffLine{}, false
+	}
+	for _, hunk := range hunks {
+		if line, ok := hunk.FindNewLine(newLine); ok {
+			return line, true
+		}
+	}
+	return DiffLine{}, false
+}
@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Diff", func() {
+	newFileDiff := "@@ -0,0 +1,9 @@\n+package mutation\n+\n+import \"github.com/pkg/errors\"\n+\n+var (\n+\tErrorFailedCreateConfigmap = errors.New(\"Failed to create configmap\")\n+\tErrorConfigmapExists       = errors.New(\"Configmap already exists\")\n+\tErrorConfigmapNotFound     = errors.New(\"Configmap not found\")\n+)\n"
+	modifiedFileDiff := "--- a/main.go\n+++ b/main.go\n@@ -1,4 +1,4 @@\n package main\n-var a = 1\n+var a = 2\n \n@@ -10,2 +10,3 @@ func main() {\n \tprintln(a)\n+\tprintln(a)\n }\n\\ No newline at end of file\n"
+
+	ginkgo.It("should parse the diff of a new file", func() {
+		hunks, err := ParseDiff(newFileDiff)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(hunks).To(gomega.HaveLen(1))
+
+		hunk := hunks[0]
+		gomega.Expect(hunk.OldStart).To(gomega.Equal(int32(0)))
+		gomega.Expect(hunk.OldLines).To(gomega.Equal(int32(0)))
+		gomega.Expect(hunk.NewStart).To(gomega.Equal(int32(1)))
+		gomega.Expect(hunk.NewLines).To(gomega.Equal(int32(9)))
+		gomega.Expect(hunk.Lines).To(gomega.HaveLen(9))
+		for i, line := range hunk.Lines {
+			gomega.Expect(line.Type).To(gomega.Equal(LineAdded))
+			gomega.Expect(line.NewLine).To(gomega.Equal(int32(i + 1)))
+			gomega.Expect(line.OldLine).To(gomega.Equal(int32(0)))
+		}
+		gomega.Expect(hunk.Lines[0].Content).To(gomega.Equal("package mutation"))
+		gomega.Expect(hunk.String()).To(gomega.Equal(newFileDiff))
+	})
+
+	ginkgo.It("should parse the diff of a modified file", func() {
+		hunks, err := ParseDiff(modifiedFileDiff)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(hunks).To(gomega.HaveLen(2))
+
+		ginkgo.By("the first hunk")
+		gomega.Expect(hunks[0].Lines).To(gomega.Equal([]DiffLine{
+			{Type: LineContext, Content: "package main", OldLine: 1, NewLine: 1},
+			{Type: LineRemoved, Content: "var a = 1", OldLine: 2},
+			{Type: LineAdded, Content: "var a = 2", NewLine: 2},
+			{Type: LineContext, Content: "", OldLine: 3, NewLine: 3},
+		}))
+
+		ginkgo.By("the second hunk")
+		gomega.Expect(hunks[1].Section).To(gomega.Equal("func main() {"))
+		gomega.Expect(hunks[1].Lines).To(gomega.Equal([]DiffLine{
+			{Type: LineContext, Content: "\tprintln(a)", OldLine: 10, NewLine: 10},
+			{Type: LineAdded, Content: "\tprintln(a)", NewLine: 11},
+			{Type: LineContext, Content: "}", OldLine: 11, NewLine: 12},
+		}))
+
+		ginkgo.By("find the line of the new file")
+		line, ok := RelativeChange{Diff: modifiedFileDiff}.FindNewLine(11)
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(line.Type).To(gomega.Equal(LineAdded))
+		_, ok = RelativeChange{Diff: modifiedFileDiff}.FindNewLine(5)
+		gomega.Expect(ok).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should reject an invalid diff", func() {
+		_, err := ParseDiff("@@ -a,b +c,d @@\n+package main\n")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+
+		_, err = ParseDiff("@@ -1 +1 @@\n*package main\n")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should annotate the line numbers of the new file", func() {
+		change := RelativeChange{Diff: "@@ -1,2 +1,2 @@\n package main\n-var a = 1\n+var a = 2\n", NewPath: "main.go"}
+		gomega.Expect(change.AnnotateLineNumbers().Diff).To(gomega.Equal(
+			"@@ -1,2 +1,2 @@\n" +
+				"    1  package main\n" +
+				"      -var a = 1\n" +
+				"    2 +var a = 2\n"))
+
+		ginkgo.By("keep the diff which can't be parsed")
+		change.Diff = "Binary files differ"
+		gomega.Expect(change.AnnotateLineNumbers().Diff).To(gomega.Equal("Binary files differ"))
+	})
+
+	ginkgo.It("should only accept review comments on lines of the diff", func() {
+		mergeRequest := NewMergeRequest(1, 1, "", "", &DifferentReference{}, []RelativeChange{
+			{Diff: modifiedFileDiff, NewPath: "main.go", OldPath: "main.go"},
+		})
+
+		gomega.Expect(mergeRequest.AddReviewComment(ReviewComment{NewPath: "main.go", NewLine: 2, Body: "added"})).To(gomega.BeTrue())
+		gomega.Expect(mergeRequest.AddReviewComment(ReviewComment{NewPath: "main.go", NewLine: 10, Body: "unchanged"})).To(gomega.BeTrue())
+		gomega.Expect(mergeRequest.AddReviewComment(ReviewComment{NewPath: "main.go", NewLine: 5, Body: "outside"})).To(gomega.BeFalse())
+		gomega.Expect(mergeRequest.AddReviewComment(ReviewComment{NewPath: "other.go", NewLine: 2, Body: "other"})).To(gomega.BeFalse())
+
+		gomega.Expect(mergeRequest.ReviewComments).To(gomega.HaveLen(2))
+		gomega.Expect(mergeRequest.ReviewComments[0].OldLine).To(gomega.Equal(int32(0)))
+		gomega.Expect(mergeRequest.ReviewComments[1].OldLine).To(gomega.Equal(int32(10)))
+	})
+})
@@ -0,0 +1,71 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+const fileContextGap = "..."
+
+// lineRange is the range of the line numbers from Start to End, both inclusive
+type lineRange struct {
+	Start, End int32
+}
+
+// NewFileContext render the lines of the file around every hunk, prefixed with their line numbers.
+// The range of a hunk is extended by contextLines on both sides, and up to its enclosing function
+// if the section of the hunk header is found above it.
+// The content is the file at the head commit, or at the base commit if the file is deleted.
+func NewFileContext(content string, hunks []Hunk, contextLines int32, deleted bool) string {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	lineCount := int32(len(lines))
+
+	var ranges []lineRange
+	for _, hunk := range hunks {
+		start, count := hunk.NewStart, hunk.NewLines
+		if deleted {
+			start, count = hunk.OldStart, hunk.OldLines
+		}
+		if count == 0 {
+			// the hunk only removes lines after the start line
+			count = 1
+		}
+
+		r := lineRange{Start: start - contextLines, End: start + count - 1 + contextLines}
+		if section := strings.TrimSpace(hunk.Section); len(section) > 0 {
+			for i := min(start-1, lineCount) - 1; i >= 0; i-- {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), section) {
+					r.Start = min(r.Start, int32(i)+1)
+					break
+				}
+			}
+		}
+		r.Start, r.End = max(r.Start, 1), min(r.End, lineCount)
+		if r.Start > r.End {
+			continue
+		}
+
+		// the hunks are ordered, so only the last range may overlap
+		if len(ranges) > 0 && ranges[len(ranges)-1].End+1 >= r.Start {
+			ranges[len(ranges)-1].End = max(ranges[len(ranges)-1].End, r.End)
+			continue
+		}
+		ranges = append(ranges, r)
+	}
+
+	builder := strings.Builder{}
+	for i, r := range ranges {
+		if i > 0 || r.Start > 1 {
+			builder.WriteString(fileContextGap)
+			builder.WriteString("\n")
+		}
+		for line := r.Start; line <= r.End; line++ {
+			builder.WriteString(fmt.Sprintf("%5d %s\n", line, lines[line-1]))
+		}
+	}
+	if len(ranges) > 0 && ranges[len(ranges)-1].End < lineCount {
+		builder.WriteString(fileContextGap)
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
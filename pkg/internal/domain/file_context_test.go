@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"strings"
+)
+
+var _ = ginkgo.Describe("FileContext", func() {
+	// the file has 30 lines, and main starts at line 5
+	var fileLines []string
+	for i := 1; i <= 30; i++ {
+		fileLines = append(fileLines, fmt.Sprintf("line%d", i))
+	}
+	fileLines[4] = "func main() {"
+	content := strings.Join(fileLines, "\n") + "\n"
+
+	ginkgo.It("should render the lines around the hunks", func() {
+		hunks, err := ParseDiff("@@ -12,1 +12,1 @@\n-old12\n+line12\n")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		fileContext := NewFileContext(content, hunks, 2, false)
+		gomega.Expect(fileContext).To(gomega.Equal("...\n   10 line10\n   11 line11\n   12 line12\n   13 line13\n   14 line14\n...\n"))
+	})
+
+	ginkgo.It("should extend the range up to the enclosing function", func() {
+		hunks, err := ParseDiff("@@ -12,1 +12,1 @@ func main() {\n-old12\n+line12\n")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		fileContext := NewFileContext(content, hunks, 1, false)
+		gomega.Expect(fileContext).To(gomega.HavePrefix("...\n    5 func main() {\n    6 line6\n"))
+		gomega.Expect(fileContext).To(gomega.HaveSuffix("   13 line13\n...\n"))
+	})
+
+	ginkgo.It("should merge the overlapping ranges, and clamp them to the file", func() {
+		hunks, err := ParseDiff("@@ -1,1 +1,1 @@\n-old1\n+line1\n@@ -4,1 +4,1 @@\n-old4\n+line4\n@@ -30,1 +30,1 @@\n-old30\n+line30\n")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		fileContext := NewFileContext(content, hunks, 1, false)
+		gomega.Expect(fileContext).To(gomega.Equal("    1 line1\n    2 line2\n    3 line3\n    4 line4\n    5 func main() {\n...\n   29 line29\n   30 line30\n"))
+	})
+
+	ginkgo.It("should use the old line numbers of a deleted file", func() {
+		hunks, err := ParseDiff("@@ -1,3 +0,0 @@\n-line1\n-line2\n-line3\n")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		fileContext := NewFileContext(content, hunks, 0, true)
+		gomega.Expect(fileContext).To(gomega.Equal("    1 line1\n    2 line2\n    3 line3\n...\n"))
+	})
+})
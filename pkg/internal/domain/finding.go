@@ -0,0 +1,193 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SeverityCritical Severity = "critical"
+	SeverityHigh     Severity = "high"
+	SeverityMedium   Severity = "medium"
+	SeverityLow      Severity = "low"
+	SeverityInfo     Severity = "info"
+
+	// Deprecated: SeverityMajor is renamed to SeverityHigh
+	SeverityMajor = SeverityHigh
+	// Deprecated: SeverityMinor is renamed to SeverityMedium
+	SeverityMinor = SeverityMedium
+)
+
+// Severities are ordered from the most to the least severe
+var Severities = []Severity{SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow, SeverityInfo}
+
+// severityAliases are the former names of the severities, which are still accepted
+var severityAliases = map[Severity]Severity{
+	"major": SeverityHigh,
+	"minor": SeverityMedium,
+}
+
+type Category string
+
+const (
+	CategoryLogic           Category = "logic"
+	CategorySecurity        Category = "security"
+	CategoryPerformance     Category = "performance"
+	CategoryConcurrency     Category = "concurrency"
+	CategoryErrorHandling   Category = "error_handling"
+	CategoryMaintainability Category = "maintainability"
+	CategoryOther           Category = "other"
+)
+
+var Categories = []Category{CategoryLogic, CategorySecurity, CategoryPerformance, CategoryConcurrency, CategoryErrorHandling, CategoryMaintainability, CategoryOther}
+
+var severityIcons = map[Severity]string{
+	SeverityCritical: ":red_circle:",
+	SeverityHigh:     ":orange_circle:",
+	SeverityMedium:   ":yellow_circle:",
+	SeverityLow:      ":large_blue_circle:",
+	SeverityInfo:     ":white_circle:",
+}
+
+// Finding is an issue of the code review, which spans the lines of the new file
+type Finding struct {
+	Path      string   `json:"path"`
+	StartLine int32    `json:"start_line"`
+	EndLine   int32    `json:"end_line"`
+	Severity  Severity `json:"severity"`
+	Category  Category `json:"category"`
+	Message   string   `json:"message"`
+	// SuggestedFix is the replacement of the lines, it is empty if there is no concrete fix
+	SuggestedFix string `json:"suggested_fix,omitempty"`
+}
+
+// ParseSeverity return the severity of the case-insensitive name or its former name, which is not validated
+func ParseSeverity(name string) Severity {
+	severity := Severity(strings.ToLower(strings.TrimSpace(name)))
+	if alias, ok := severityAliases[severity]; ok {
+		return alias
+	}
+	return severity
+}
+
+// IsValid return whether the severity is one of the Severities
+func (s Severity) IsValid() bool {
+	return slices.Contains(Severities, s)
+}
+
+// AtLeast return whether the severity is as severe as the given one
+func (s Severity) AtLeast(severity Severity) bool {
+	index := slices.Index(Severities, s)
+	return index >= 0 && index <= slices.Index(Severities, severity)
+}
+
+// FindingsAtLeast return the findings which are as severe as the given severity
+func FindingsAtLeast(findings []Finding, severity Severity) []Finding {
+	var result []Finding
+	for _, finding := range findings {
+		if finding.Severity.AtLeast(severity) {
+			result = append(result, finding)
+		}
+	}
+	return result
+}
+
+// Lines return the line range of the finding, e.g. L10 or L10-L12
+func (f Finding) Lines() string {
+	if f.EndLine <= f.StartLine {
+		return fmt.Sprintf("L%d", f.StartLine)
+	}
+	return fmt.Sprintf("L%d-L%d", f.StartLine, f.EndLine)
+}
+
+// Markdown render the finding as the body of an inline comment
+func (f Finding) Markdown() string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("%s **%s** · %s\n\n", severityIcons[f.Severity], f.Severity, f.Category))
+	builder.WriteString(f.Message)
+	builder.WriteString("\n")
+	if len(f.SuggestedFix) > 0 {
+		builder.WriteString("\n**Suggested fix**\n```\n")
+		builder.WriteString(strings.TrimSuffix(f.SuggestedFix, "\n"))
+		builder.WriteString("\n```\n")
+	}
+	return builder.String()
+}
+
+// AddFinding normalize the finding and append it if its file belongs to the RelativeChanges,
+// and return the normalized finding and whether it is accepted
+func (mr *MergeRequest) AddFinding(finding Finding) (Finding, bool) {
+	finding.Severity = ParseSeverity(string(finding.Severity))
+	finding.Category = Category(strings.ToLower(string(finding.Category)))
+	if _, ok := mr.FindRelativeChange(finding.Path); !ok || !finding.Severity.IsValid() || finding.StartLine <= 0 || len(finding.Message) == 0 {
+		return finding, false
+	}
+	if !slices.Contains(Categories, finding.Category) {
+		finding.Category = CategoryOther
+	}
+	if finding.EndLine < finding.StartLine {
+		finding.EndLine = finding.StartLine
+	}
+	mr.Findings = append(mr.Findings, finding)
+	return finding, true
+}
+
+// CommentFinding anchor the finding as a ReviewComment to the first line of its range which is shown in the diff, and return whether it is anchored
+// The lines of the diff are walked instead of the range, which the LLM is able to make as large as it likes
+func (mr *MergeRequest) CommentFinding(finding Finding) bool {
+	change, ok := mr.FindRelativeChange(finding.Path)
+	if !ok {
+		return false
+	}
+	hunks, err := change.Hunks()
+	if err != nil {
+		return false
+	}
+	endLine := max(finding.StartLine, finding.EndLine)
+	for _, hunk := range hunks {
+		for _, line := range hunk.Lines {
+			if line.Type == LineRemoved || line.NewLine < finding.StartLine || line.NewLine > endLine {
+				continue
+			}
+			return mr.AddReviewComment(ReviewComment{NewPath: finding.Path, NewLine: line.NewLine, Body: finding.Markdown()})
+		}
+	}
+	return false
+}
+
+// FindingNote render the Findings as a markdown table ordered by the severity, along with the suggested fixes
+func (mr *MergeRequest) FindingNote() Note {
+	if len(mr.Findings) == 0 {
+		return ""
+	}
+	findings := slices.Clone(mr.Findings)
+	slices.SortStableFunc(findings, func(a, b Finding) int {
+		return slices.Index(Severities, a.Severity) - slices.Index(Severities, b.Severity)
+	})
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("### Findings (%d)\n\n", len(findings)))
+	builder.WriteString("| Severity | Category | Location | Finding |\n")
+	builder.WriteString("|----------|----------|----------|---------|\n")
+	for _, finding := range findings {
+		builder.WriteString(fmt.Sprintf("| %s %s | %s | `%s` %s | %s |\n",
+			severityIcons[finding.Severity], finding.Severity, finding.Category, finding.Path, finding.Lines(), escapeTableCell(finding.Message)))
+	}
+	for _, finding := range findings {
+		if len(finding.SuggestedFix) == 0 {
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("\n<details><summary>Suggested fix for <code>%s</code> %s</summary>\n\n```\n%s\n```\n\n</details>\n",
+			finding.Path, finding.Lines(), strings.TrimSuffix(finding.SuggestedFix, "\n")))
+	}
+	return Note(builder.String())
+}
+
+// escapeTableCell keep the content within a single cell of the markdown table
+func escapeTableCell(content string) string {
+	content = strings.ReplaceAll(content, "|", "\\|")
+	return strings.ReplaceAll(strings.TrimSpace(content), "\n", "<br>")
+}
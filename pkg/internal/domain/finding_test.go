@@ -0,0 +1,66 @@
+package domain
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"math"
+)
+
+var _ = ginkgo.Describe("Finding", func() {
+	newMergeRequest := func() *MergeRequest {
+		return NewMergeRequest(1, 1, "title", "", &DifferentReference{}, []RelativeChange{
+			{Diff: "@@ -1,3 +1,4 @@\n package main\n-var a = 1\n+var a = 2\n+var b = 3\n \n", NewPath: "main.go", OldPath: "main.go"},
+		})
+	}
+
+	ginkgo.It("should compare severities", func() {
+		gomega.Expect(SeverityCritical.AtLeast(SeverityMajor)).To(gomega.BeTrue())
+		gomega.Expect(SeverityMajor.AtLeast(SeverityMajor)).To(gomega.BeTrue())
+		gomega.Expect(SeverityMinor.AtLeast(SeverityMajor)).To(gomega.BeFalse())
+		gomega.Expect(Severity("unknown").AtLeast(SeverityInfo)).To(gomega.BeFalse())
+
+		findings := []Finding{{Severity: SeverityLow}, {Severity: SeverityCritical}, {Severity: SeverityHigh}}
+		gomega.Expect(FindingsAtLeast(findings, SeverityHigh)).To(gomega.Equal([]Finding{{Severity: SeverityCritical}, {Severity: SeverityHigh}}))
+	})
+
+	ginkgo.It("should normalize and validate findings", func() {
+		mr := newMergeRequest()
+
+		finding, ok := mr.AddFinding(Finding{Path: "main.go", StartLine: 3, EndLine: 2, Severity: "MAJOR", Category: "naming", Message: "b is unused"})
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(finding.Severity).To(gomega.Equal(SeverityMajor))
+		_, ok = mr.AddFinding(Finding{Path: "other.go", StartLine: 1, Severity: SeverityMajor, Message: "not changed"})
+		gomega.Expect(ok).To(gomega.BeFalse())
+		_, ok = mr.AddFinding(Finding{Path: "main.go", StartLine: 1, Severity: "blocker", Message: "unknown severity"})
+		gomega.Expect(ok).To(gomega.BeFalse())
+
+		gomega.Expect(mr.Findings).To(gomega.Equal([]Finding{
+			{Path: "main.go", StartLine: 3, EndLine: 3, Severity: SeverityMajor, Category: CategoryOther, Message: "b is unused"},
+		}))
+	})
+
+	ginkgo.It("should anchor finding to the first line in the diff", func() {
+		mr := newMergeRequest()
+
+		gomega.Expect(mr.CommentFinding(Finding{Path: "main.go", StartLine: 2, EndLine: 3, Severity: SeverityMinor, Message: "rename"})).To(gomega.BeTrue())
+		gomega.Expect(mr.CommentFinding(Finding{Path: "main.go", StartLine: 10, EndLine: 12, Severity: SeverityMinor, Message: "outside"})).To(gomega.BeFalse())
+		gomega.Expect(mr.CommentFinding(Finding{Path: "main.go", StartLine: 10, EndLine: math.MaxInt32, Severity: SeverityMinor, Message: "unbounded"})).To(gomega.BeFalse())
+
+		gomega.Expect(mr.ReviewComments).To(gomega.HaveLen(1))
+		gomega.Expect(mr.ReviewComments[0].NewLine).To(gomega.Equal(int32(2)))
+		gomega.Expect(mr.ReviewComments[0].Body).To(gomega.ContainSubstring("**medium**"))
+	})
+
+	ginkgo.It("should render findings ordered by severity", func() {
+		mr := newMergeRequest()
+		gomega.Expect(mr.FindingNote()).To(gomega.BeEmpty())
+
+		mr.AddFinding(Finding{Path: "main.go", StartLine: 2, Severity: SeverityMinor, Category: CategoryMaintainability, Message: "use a | b"})
+		mr.AddFinding(Finding{Path: "main.go", StartLine: 2, EndLine: 3, Severity: SeverityCritical, Category: CategoryLogic, Message: "wrong value", SuggestedFix: "var a = 1\n"})
+
+		note := string(mr.FindingNote())
+		gomega.Expect(note).To(gomega.HavePrefix("### Findings (2)\n"))
+		gomega.Expect(note).To(gomega.ContainSubstring("| :red_circle: critical | logic | `main.go` L2-L3 | wrong value |\n| :yellow_circle: medium | maintainability | `main.go` L2 | use a \\| b |\n"))
+		gomega.Expect(note).To(gomega.ContainSubstring("<details><summary>Suggested fix for <code>main.go</code> L2-L3</summary>\n\n```\nvar a = 1\n```"))
+	})
+})
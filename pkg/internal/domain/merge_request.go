@@ -2,6 +2,7 @@ package domain
 
 import (
 	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -9,16 +10,36 @@ const (
 	ignore = "@codeReview: ignore"
 )
 
+// conventionalCommitRegexp matches the title of Conventional Commits, e.g. `feat(api)!: add the endpoint`, see https://www.conventionalcommits.org
+var conventionalCommitRegexp = regexp.MustCompile(`^[a-zA-Z]+(\([^()\r\n]+\))?!?: \S`)
+
 type MergeRequest struct {
 	ID        int32
 	ProjectID int32
 
-	Title              string
-	Description        string
+	Title       string
+	Description string
+	// Author is the username of the author
+	Author string
+	Labels []string
+	// Commits are the commits of the merge request, they are empty if the code host doesn't list them
+	Commits            []Commit
 	DifferentReference *DifferentReference
 	RelativeChanges    []RelativeChange
 	RelativeChangeNote Note
 	SummaryNote        Note
+	ReviewComments     []ReviewComment
+	Findings           []Finding
+	// ReviewedHeadSha is the head sha of the previous review, it is set when the RelativeChanges only contain the later commits
+	ReviewedHeadSha string
+	// OmittedChanges are the changes which couldn't be included in the review
+	OmittedChanges []OmittedChange
+	// NonConventionalCommits are the commits which don't follow Conventional Commits, they are only checked if it is enabled
+	NonConventionalCommits []Commit
+	// ProjectConfig is the config kept in the project at the head commit, it is nil if the project has none
+	ProjectConfig *ProjectConfig
+	// Usage is the tokens consumed by the completions of the review so far
+	Usage Usage
 }
 
 func NewMergeRequest(
@@ -33,8 +54,26 @@ func NewMergeRequest(
 }
 
 type DifferentReference struct {
-	BaseSha string
-	HeadSha string
+	BaseSha  string
+	StartSha string
+	HeadSha  string
+}
+
+type Commit struct {
+	Sha     string
+	Title   string
+	Message string
+}
+
+// Body return the message without the title
+func (c Commit) Body() string {
+	_, body, _ := strings.Cut(c.Message, "\n")
+	return strings.TrimSpace(body)
+}
+
+// IsConventional return whether the title follows Conventional Commits, the merge and revert commits generated by git are exempted
+func (c Commit) IsConventional() bool {
+	return conventionalCommitRegexp.MatchString(c.Title) || strings.HasPrefix(c.Title, "Merge ") || strings.HasPrefix(c.Title, "Revert \"")
 }
 
 type RelativeChange struct {
@@ -44,10 +83,50 @@ type RelativeChange struct {
 	NewFile     bool
 	RenameFile  bool
 	DeletedFile bool
+	// Context is the lines of the file around the hunks, it is empty if the file isn't fetched, see NewFileContext
+	Context string `json:",omitempty"`
+}
+
+// OmittedChange is a file which couldn't be included in the review, along with the reason
+type OmittedChange struct {
+	Path   string
+	Reason string
 }
 
 type Note string
 
+// IsIncremental return whether only the commits pushed since the previous review are reviewed
+func (mr *MergeRequest) IsIncremental() bool {
+	return len(mr.ReviewedHeadSha) > 0
+}
+
+// ApplyIncrementalChanges replace the RelativeChanges with the changes since the previous review of the reviewedHeadSha
+func (mr *MergeRequest) ApplyIncrementalChanges(reviewedHeadSha string, relativeChanges []RelativeChange, omittedChanges []OmittedChange) {
+	mr.ReviewedHeadSha = reviewedHeadSha
+	mr.RelativeChanges = relativeChanges
+	mr.OmittedChanges = omittedChanges
+}
+
+// OmitChange record the file which couldn't be included in the review
+func (mr *MergeRequest) OmitChange(path string, reason string) {
+	for _, change := range mr.OmittedChanges {
+		if change.Path == path {
+			return
+		}
+	}
+	mr.OmittedChanges = append(mr.OmittedChanges, OmittedChange{Path: path, Reason: reason})
+}
+
+// CheckConventionalCommits collect the commits which don't follow Conventional Commits into NonConventionalCommits
+func (mr *MergeRequest) CheckConventionalCommits() {
+	mr.NonConventionalCommits = nil
+	for _, commit := range mr.Commits {
+		if !commit.IsConventional() {
+			mr.NonConventionalCommits = append(mr.NonConventionalCommits, commit)
+		}
+	}
+}
+
 func (mr *MergeRequest) IgnoreReview() bool {
 	return strings.Contains(mr.Description, ignore) || len(mr.RelativeChanges) <= 0
 }
@@ -71,5 +150,14 @@ func (mr *MergeRequest) FilterIgnorePaths(pathFilters []*regexp.Regexp) int32 {
 		}
 	}
 	mr.RelativeChanges = remainChanges
+
+	// the ignored files aren't worth reporting
+	var remainOmittedChanges []OmittedChange
+	for _, change := range mr.OmittedChanges {
+		if !slices.ContainsFunc(pathFilters, func(filter *regexp.Regexp) bool { return filter.MatchString(change.Path) }) {
+			remainOmittedChanges = append(remainOmittedChanges, change)
+		}
+	}
+	mr.OmittedChanges = remainOmittedChanges
 	return filterCount
 }
@@ -85,6 +85,30 @@ func TestMergeRequest(t *testing.T) {
 				&DifferentReference{}, []RelativeChange{})
 			gomega.Expect(mergeRequest.IgnoreReview()).To(gomega.BeTrue())
 		})
+		ginkgo.It("CheckConventionalCommits", func() {
+			mergeRequest := NewMergeRequest(1, 1, "", "", &DifferentReference{}, []RelativeChange{})
+			mergeRequest.Commits = []Commit{
+				{Sha: "1", Title: "feat(api)!: drop the v1 endpoints"},
+				{Sha: "2", Title: "fix: handle the nil config"},
+				{Sha: "3", Title: "Merge branch 'main' into feature"},
+				{Sha: "4", Title: "Revert \"fix: handle the nil config\""},
+				{Sha: "5", Title: "fix typo"},
+				{Sha: "6", Title: "feat:missing space"},
+				{Sha: "7", Title: "feat(): empty scope"},
+			}
+
+			mergeRequest.CheckConventionalCommits()
+			var shas []string
+			for _, commit := range mergeRequest.NonConventionalCommits {
+				shas = append(shas, commit.Sha)
+			}
+			gomega.Expect(shas).To(gomega.Equal([]string{"5", "6", "7"}))
+		})
+		ginkgo.It("Commit Body", func() {
+			commit := Commit{Title: "feat: add the errors", Message: "feat: add the errors\n\nThe errors are compared with errors.Is.\n"}
+			gomega.Expect(commit.Body()).To(gomega.Equal("The errors are compared with errors.Is."))
+			gomega.Expect(Commit{Title: "fix typo", Message: "fix typo\n"}.Body()).To(gomega.BeEmpty())
+		})
 	})
 
 	ginkgo.RunSpecs(t, "MergeRequest")
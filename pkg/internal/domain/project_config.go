@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Stage is a stage of the review which a project is able to disable
+type Stage string
+
+const (
+	StageReleaseNote   Stage = "release_note"
+	StageFindings      Stage = "findings"
+	StageInlineComment Stage = "inline_comment"
+)
+
+// ProjectConfig is the config kept in the reviewed project, which is merged over the global config
+type ProjectConfig struct {
+	// PathFilters are the regexps of the paths to ignore in addition to the global ones, it is a policy
+	PathFilters []string `validate:"dive,required"`
+	// Instructions are appended to the system message
+	Instructions string
+	// Guidelines are the instructions for the changed files whose path matches
+	Guidelines []Guideline `validate:"dive"`
+	// Language is the language which the review is written in, e.g. Japanese
+	Language string
+	// DisabledStages are the stages to skip, it is a policy
+	DisabledStages []Stage `validate:"dive,oneof=release_note findings inline_comment"`
+}
+
+// Guideline is the instructions for the files whose path matches the Path regexp
+type Guideline struct {
+	Path         string `validate:"required"`
+	Instructions string `validate:"required"`
+}
+
+// IsDisabled return whether the stage is disabled, nothing is disabled without the project config
+func (c *ProjectConfig) IsDisabled(stage Stage) bool {
+	return c != nil && slices.Contains(c.DisabledStages, stage)
+}
+
+// WithPolicy return the config whose policy, which is the path filters and the disabled stages, is taken from the given config.
+// The policy is read from the target branch, so that a merge request isn't able to skip its own review
+func (c *ProjectConfig) WithPolicy(policy *ProjectConfig) *ProjectConfig {
+	if c == nil && policy == nil {
+		return nil
+	}
+
+	merged := ProjectConfig{}
+	if c != nil {
+		merged = *c
+	}
+	merged.PathFilters, merged.DisabledStages = nil, nil
+	if policy != nil {
+		merged.PathFilters, merged.DisabledStages = policy.PathFilters, policy.DisabledStages
+	}
+	return &merged
+}
+
+// Enable remove the stage from the disabled stages
+func (c *ProjectConfig) Enable(stage Stage) {
+	if c == nil {
+		return
+	}
+	c.DisabledStages = slices.DeleteFunc(slices.Clone(c.DisabledStages), func(disabled Stage) bool { return disabled == stage })
+}
+
+// SystemMessage append the instructions, the guidelines of the changed paths and the language to the given system message
+func (c *ProjectConfig) SystemMessage(systemMessage string, changes []RelativeChange) string {
+	if c == nil {
+		return systemMessage
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(systemMessage)
+	if len(c.Instructions) > 0 {
+		builder.WriteString("\n\n")
+		builder.WriteString(strings.TrimSpace(c.Instructions))
+	}
+	for _, guideline := range c.Guidelines {
+		pathRegexp, err := regexp.Compile(guideline.Path)
+		if err != nil {
+			continue
+		}
+		if !slices.ContainsFunc(changes, func(change RelativeChange) bool { return pathRegexp.MatchString(change.NewPath) }) {
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("\n\nFor the files matching `%s`:\n%s", guideline.Path, strings.TrimSpace(guideline.Instructions)))
+	}
+	if len(c.Language) > 0 {
+		builder.WriteString(fmt.Sprintf("\n\nWrite the review in %s, but keep the JSON keys, the severities and the categories in English.", c.Language))
+	}
+	return builder.String()
+}
+
+// SystemMessage merge the project config of the merge request into the given system message
+func (mr *MergeRequest) SystemMessage(systemMessage string) string {
+	return mr.ProjectConfig.SystemMessage(systemMessage, mr.RelativeChanges)
+}
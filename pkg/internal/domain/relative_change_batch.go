@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"encoding/json"
+)
+
+// TokenCounter count the tokens of the given content
+type TokenCounter func(content string) (int64, error)
+
+// SplitRelativeChanges split the changes into batches which token count is within maxToken.
+// A change is kept as a whole whenever possible, otherwise without its Context, otherwise it is split per hunk without the Context.
+// A single hunk exceeding maxToken can't be reviewed, and is returned as omitted.
+func SplitRelativeChanges(changes []RelativeChange, maxToken int64, countTokens TokenCounter) ([][]RelativeChange, []RelativeChange, error) {
+	var batches [][]RelativeChange
+	var omitted []RelativeChange
+	var batch []RelativeChange
+	var batchToken int64
+
+	appendChange := func(change RelativeChange, token int64) {
+		if batchToken+token > maxToken && len(batch) > 0 {
+			batches = append(batches, batch)
+			batch, batchToken = nil, 0
+		}
+		batch = append(batch, change)
+		batchToken += token
+	}
+
+	for _, change := range changes {
+		token, err := countRelativeChangeTokens(change, countTokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		if token <= maxToken {
+			appendChange(change, token)
+			continue
+		}
+
+		// the context only helps the review, so it is the first to give up
+		if len(change.Context) > 0 {
+			change.Context = ""
+			token, err = countRelativeChangeTokens(change, countTokens)
+			if err != nil {
+				return nil, nil, err
+			}
+			if token <= maxToken {
+				appendChange(change, token)
+				continue
+			}
+		}
+
+		hunks, err := change.Hunks()
+		if err != nil || len(hunks) == 0 {
+			omitted = append(omitted, change)
+			continue
+		}
+		for _, hunk := range hunks {
+			partial := change
+			partial.Diff = hunk.String()
+			token, err := countRelativeChangeTokens(partial, countTokens)
+			if err != nil {
+				return nil, nil, err
+			}
+			if token > maxToken {
+				omitted = append(omitted, partial)
+				continue
+			}
+			appendChange(partial, token)
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches, omitted, nil
+}
+
+// countRelativeChangeTokens count the tokens of the change as it is presented in the prompt
+func countRelativeChangeTokens(change RelativeChange, countTokens TokenCounter) (int64, error) {
+	marshalChange, err := json.Marshal(change.AnnotateLineNumbers())
+	if err != nil {
+		return 0, err
+	}
+	return countTokens(string(marshalChange))
+}
+
+// SplitPerFile split the batches so that every batch has the changes of a single file, the order of the changes is kept
+func SplitPerFile(batches [][]RelativeChange) [][]RelativeChange {
+	var split [][]RelativeChange
+	for _, batch := range batches {
+		for i, change := range batch {
+			if i > 0 && change.NewPath == batch[i-1].NewPath {
+				split[len(split)-1] = append(split[len(split)-1], change)
+				continue
+			}
+			split = append(split, []RelativeChange{change})
+		}
+	}
+	return split
+}
@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"strings"
+)
+
+var _ = ginkgo.Describe("SplitRelativeChanges", func() {
+	// countWords is a deterministic TokenCounter which treats every word as a token
+	countWords := func(content string) (int64, error) {
+		return int64(len(strings.Fields(content))), nil
+	}
+	smallDiff := "@@ -0,0 +1,2 @@\n+package main\n+\n"
+	largeDiff := "@@ -1,3 +1,3 @@\n package main\n-var a = 1\n+var a = 2\n" +
+		"@@ -10,3 +10,3 @@\n func main() {\n-\tprintln(a, a)\n+\tprintln(a)\n"
+
+	ginkgo.It("should keep changes within maxToken together", func() {
+		changes := []RelativeChange{
+			{Diff: smallDiff, NewPath: "a.go", OldPath: "a.go"},
+			{Diff: smallDiff, NewPath: "b.go", OldPath: "b.go"},
+		}
+
+		batches, omitted, err := SplitRelativeChanges(changes, 100, countWords)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(omitted).To(gomega.BeEmpty())
+		gomega.Expect(batches).To(gomega.HaveLen(1))
+		gomega.Expect(batches[0]).To(gomega.HaveLen(2))
+	})
+
+	ginkgo.It("should split changes per file", func() {
+		changes := []RelativeChange{
+			{Diff: smallDiff, NewPath: "a.go", OldPath: "a.go"},
+			{Diff: smallDiff, NewPath: "b.go", OldPath: "b.go"},
+		}
+		token, err := countRelativeChangeTokens(changes[0], countWords)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		batches, omitted, err := SplitRelativeChanges(changes, token, countWords)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(omitted).To(gomega.BeEmpty())
+		gomega.Expect(batches).To(gomega.HaveLen(2))
+		gomega.Expect(batches[0][0].NewPath).To(gomega.Equal("a.go"))
+		gomega.Expect(batches[1][0].NewPath).To(gomega.Equal("b.go"))
+	})
+
+	ginkgo.It("should split a large change per hunk", func() {
+		changes := []RelativeChange{
+			{Diff: largeDiff, NewPath: "main.go", OldPath: "main.go"},
+		}
+		token, err := countRelativeChangeTokens(changes[0], countWords)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		batches, omitted, err := SplitRelativeChanges(changes, token-1, countWords)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(omitted).To(gomega.BeEmpty())
+		gomega.Expect(batches).To(gomega.HaveLen(2))
+		gomega.Expect(batches[0][0].Diff).To(gomega.HavePrefix("@@ -1,3 +1,3 @@"))
+		gomega.Expect(batches[1][0].Diff).To(gomega.HavePrefix("@@ -10,3 +10,3 @@"))
+		gomega.Expect(batches[1][0].NewPath).To(gomega.Equal("main.go"))
+	})
+
+	ginkgo.It("should drop the context before splitting the change", func() {
+		change := RelativeChange{Diff: largeDiff, NewPath: "main.go", OldPath: "main.go"}
+		token, err := countRelativeChangeTokens(change, countWords)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		change.Context = "    1 package main\n    2 \n    3 var a = 2\n"
+
+		batches, omitted, err := SplitRelativeChanges([]RelativeChange{change}, token, countWords)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(omitted).To(gomega.BeEmpty())
+		gomega.Expect(batches).To(gomega.HaveLen(1))
+		gomega.Expect(batches[0][0].Diff).To(gomega.Equal(largeDiff))
+		gomega.Expect(batches[0][0].Context).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should omit a hunk exceeding maxToken", func() {
+		changes := []RelativeChange{
+			{Diff: smallDiff, NewPath: "a.go", OldPath: "a.go"},
+			{Diff: largeDiff, NewPath: "main.go", OldPath: "main.go"},
+		}
+		token, err := countRelativeChangeTokens(changes[0], countWords)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		batches, omitted, err := SplitRelativeChanges(changes, token, countWords)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(batches).To(gomega.HaveLen(1))
+		gomega.Expect(omitted).To(gomega.HaveLen(2))
+		gomega.Expect(omitted[0].NewPath).To(gomega.Equal("main.go"))
+	})
+
+	ginkgo.It("should split the batches per file", func() {
+		batches := [][]RelativeChange{
+			{{Diff: smallDiff, NewPath: "a.go"}, {Diff: smallDiff, NewPath: "b.go"}, {Diff: largeDiff, NewPath: "b.go"}},
+			{{Diff: smallDiff, NewPath: "c.go"}},
+		}
+
+		split := SplitPerFile(batches)
+		gomega.Expect(split).To(gomega.HaveLen(3))
+		gomega.Expect(split[0]).To(gomega.HaveLen(1))
+		gomega.Expect(split[1]).To(gomega.HaveLen(2))
+		gomega.Expect(split[1][1].Diff).To(gomega.Equal(largeDiff))
+		gomega.Expect(split[2][0].NewPath).To(gomega.Equal("c.go"))
+	})
+})
@@ -0,0 +1,37 @@
+package domain
+
+// ReviewComment is a finding of the code review which is anchored to a specific line of the new file
+type ReviewComment struct {
+	NewPath string
+	OldPath string
+	NewLine int32
+	// OldLine is set when the comment is anchored to an unchanged line
+	OldLine int32
+	Body    string
+}
+
+// FindRelativeChange return the RelativeChange which the given path belongs to
+func (mr *MergeRequest) FindRelativeChange(newPath string) (RelativeChange, bool) {
+	for _, change := range mr.RelativeChanges {
+		if change.NewPath == newPath {
+			return change, true
+		}
+	}
+	return RelativeChange{}, false
+}
+
+// AddReviewComment append the comment if it is anchored to a line shown in the diff of the RelativeChanges, and return whether it is accepted
+func (mr *MergeRequest) AddReviewComment(comment ReviewComment) bool {
+	change, ok := mr.FindRelativeChange(comment.NewPath)
+	if !ok || change.DeletedFile || comment.NewLine <= 0 || len(comment.Body) == 0 {
+		return false
+	}
+	line, ok := change.FindNewLine(comment.NewLine)
+	if !ok {
+		return false
+	}
+	comment.OldPath = change.OldPath
+	comment.OldLine = line.OldLine
+	mr.ReviewComments = append(mr.ReviewComments, comment)
+	return true
+}
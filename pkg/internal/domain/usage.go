@@ -0,0 +1,107 @@
+package domain
+
+import (
+	"slices"
+	"strings"
+)
+
+// ModelPrice is the price of a model in USD per million tokens
+type ModelPrice struct {
+	Model  string  `validate:"required"`
+	Input  float64 `validate:"gte=0"`
+	Output float64 `validate:"gte=0"`
+}
+
+// PriceTable is the prices of the models, a model is priced by the longest Model which it starts with,
+// so that `gpt-4o` also prices the snapshots such as `gpt-4o-2024-08-06`
+type PriceTable []ModelPrice
+
+// Lookup return the price of the model, it is false if the model isn't in the table
+func (t PriceTable) Lookup(model string) (ModelPrice, bool) {
+	var found ModelPrice
+	for _, price := range t {
+		if strings.HasPrefix(model, price.Model) && len(price.Model) > len(found.Model) {
+			found = price
+		}
+	}
+	return found, len(found.Model) > 0
+}
+
+// ModelUsage is the tokens consumed by the completions of a model, and their cost
+type ModelUsage struct {
+	Model       string `json:"model"`
+	Completions int    `json:"completions"`
+	// CacheHits are the completions answered by the cache, which consume no tokens
+	CacheHits    int     `json:"cache_hits"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	Cost         float64 `json:"cost_usd"`
+	// Priced is false if the model isn't in the price table, the Cost is 0 then
+	Priced bool `json:"priced"`
+}
+
+// Usage is the tokens consumed by every completion of a review, and their cost in USD
+type Usage struct {
+	Models       []ModelUsage `json:"models"`
+	CacheHits    int          `json:"cache_hits"`
+	InputTokens  int64        `json:"input_tokens"`
+	OutputTokens int64        `json:"output_tokens"`
+	Cost         float64      `json:"cost_usd"`
+}
+
+// Add add the tokens of a completion to the usage of the model, priced with the price table
+func (u *Usage) Add(model string, inputTokens, outputTokens int64, cacheHit bool, prices PriceTable) {
+	i := slices.IndexFunc(u.Models, func(usage ModelUsage) bool { return usage.Model == model })
+	if i < 0 {
+		_, priced := prices.Lookup(model)
+		u.Models = append(u.Models, ModelUsage{Model: model, Priced: priced})
+		i = len(u.Models) - 1
+	}
+
+	var cost float64
+	if price, ok := prices.Lookup(model); ok {
+		cost = (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1_000_000
+	}
+	u.Models[i].Completions++
+	if cacheHit {
+		u.Models[i].CacheHits++
+		u.CacheHits++
+	}
+	u.Models[i].InputTokens += inputTokens
+	u.Models[i].OutputTokens += outputTokens
+	u.Models[i].Cost += cost
+	u.InputTokens += inputTokens
+	u.OutputTokens += outputTokens
+	u.Cost += cost
+}
+
+// UnpricedModels return the models which aren't in the price table, the cost of the usage doesn't include them
+func (u *Usage) UnpricedModels() []string {
+	var models []string
+	for _, usage := range u.Models {
+		if !usage.Priced {
+			models = append(models, usage.Model)
+		}
+	}
+	return models
+}
+
+// Merge add up the usage of another review, e.g. the usage of a batch reviewed concurrently
+func (u *Usage) Merge(other Usage) {
+	for _, usage := range other.Models {
+		i := slices.IndexFunc(u.Models, func(model ModelUsage) bool { return model.Model == usage.Model })
+		if i < 0 {
+			u.Models = append(u.Models, usage)
+			continue
+		}
+		u.Models[i].Completions += usage.Completions
+		u.Models[i].CacheHits += usage.CacheHits
+		u.Models[i].InputTokens += usage.InputTokens
+		u.Models[i].OutputTokens += usage.OutputTokens
+		u.Models[i].Cost += usage.Cost
+	}
+	u.CacheHits += other.CacheHits
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.Cost += other.Cost
+}
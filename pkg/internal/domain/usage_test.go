@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Usage", func() {
+	prices := PriceTable{
+		{Model: "gpt-4o", Input: 2.5, Output: 10},
+		{Model: "gpt-4o-mini", Input: 0.15, Output: 0.6},
+	}
+
+	ginkgo.It("should price the model by the longest prefix", func() {
+		price, ok := prices.Lookup("gpt-4o-mini-2024-07-18")
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(price.Model).To(gomega.Equal("gpt-4o-mini"))
+
+		price, ok = prices.Lookup("gpt-4o-2024-08-06")
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(price.Model).To(gomega.Equal("gpt-4o"))
+
+		_, ok = prices.Lookup("claude-3-5-sonnet-latest")
+		gomega.Expect(ok).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should add up the completions per model", func() {
+		var usage Usage
+		usage.Add("gpt-4o-mini", 1_000_000, 100_000, false, prices)
+		usage.Add("gpt-4o-mini", 1_000_000, 100_000, false, prices)
+		usage.Add("llama3", 500, 50, false, prices)
+
+		gomega.Expect(usage.Models).To(gomega.HaveLen(2))
+		gomega.Expect(usage.Models[0].Completions).To(gomega.Equal(2))
+		gomega.Expect(usage.Models[0].InputTokens).To(gomega.Equal(int64(2_000_000)))
+		gomega.Expect(usage.Models[0].Cost).To(gomega.BeNumerically("~", 0.42, 1e-9))
+		gomega.Expect(usage.Models[1].Priced).To(gomega.BeFalse())
+		gomega.Expect(usage.Models[1].Cost).To(gomega.BeZero())
+
+		gomega.Expect(usage.InputTokens).To(gomega.Equal(int64(2_000_500)))
+		gomega.Expect(usage.OutputTokens).To(gomega.Equal(int64(200_050)))
+		gomega.Expect(usage.Cost).To(gomega.BeNumerically("~", 0.42, 1e-9))
+		gomega.Expect(usage.UnpricedModels()).To(gomega.Equal([]string{"llama3"}))
+	})
+
+	ginkgo.It("should count the cached responses without their tokens", func() {
+		var usage Usage
+		usage.Add("gpt-4o-mini", 1000, 100, false, prices)
+		usage.Add("gpt-4o-mini", 0, 0, true, prices)
+
+		gomega.Expect(usage.Models[0].Completions).To(gomega.Equal(2))
+		gomega.Expect(usage.Models[0].CacheHits).To(gomega.Equal(1))
+		gomega.Expect(usage.CacheHits).To(gomega.Equal(1))
+		gomega.Expect(usage.InputTokens).To(gomega.Equal(int64(1000)))
+	})
+})
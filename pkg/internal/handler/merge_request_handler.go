@@ -5,8 +5,17 @@ import (
 	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab-mr-reviewer/pkg/internal/domain"
 	"gitlab-mr-reviewer/pkg/internal/usecase"
 	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/metrics"
+	"gitlab-mr-reviewer/pkg/retry"
+)
+
+var (
+	ErrorInvalidInput  = errors.New("Invalid input.")
+	ErrorFindingsFound = errors.New("Findings at the fail-on severity are found.")
 )
 
 type MergeRequestHandler interface {
@@ -16,14 +25,18 @@ type MergeRequestHandler interface {
 type mergeRequestHandler struct {
 	logger               *logging.ZaprLogger
 	mergeRequestReviewer usecase.MergeRequestReviewer
+	reviews              *prometheus.CounterVec
 }
 
+// NewMergeRequestHandler create the handler of the reviews, which are counted by the outcome in the reviews
 func NewMergeRequestHandler(logger *logging.ZaprLogger,
 	mergeRequestReviewer usecase.MergeRequestReviewer,
+	reviews *prometheus.CounterVec,
 ) MergeRequestHandler {
 	return &mergeRequestHandler{
 		logger:               logger,
 		mergeRequestReviewer: mergeRequestReviewer,
+		reviews:              reviews,
 	}
 }
 
@@ -32,19 +45,52 @@ func (h *mergeRequestHandler) Review(ctx context.Context, input *usecase.MergeRe
 	err := validate.Struct(input)
 	if err != nil {
 		h.logger.Error(err, fmt.Sprintf("Failed to validate input: %#v", input))
-		return errors.Wrap(err, "Failed to validate input.")
+		h.reviews.WithLabelValues(metrics.OutcomeFailed).Inc()
+		return errors.Wrapf(ErrorInvalidInput, "Failed to validate input: %s", err)
 	}
 
-	_, err = h.mergeRequestReviewer.Apply(ctx, input)
+	output, err := h.mergeRequestReviewer.Apply(ctx, input)
 	if err != nil {
 		if errors.Is(err, usecase.ErrorIgnoreCodeReview) {
 			h.logger.Info("There is nothing to review")
+			h.reviews.WithLabelValues(metrics.OutcomeIgnored).Inc()
 			return nil
 		}
+		h.reviews.WithLabelValues(metrics.OutcomeFailed).Inc()
 
-		h.logger.Error(err, "Failed to apply input")
+		switch {
+		case errors.Is(err, usecase.ErrorPartialReview):
+			h.logger.Error(err, "Posted the review without the failed batches")
+			// the findings of the reviewed batches still fail the review, since they block the merge whatever the rest is
+			if output != nil {
+				if failOnErr := h.failOn(input, output); failOnErr != nil {
+					return errors.Wrap(failOnErr, err.Error())
+				}
+			}
+		case errors.Is(err, retry.ErrorUnauthorized), errors.Is(err, retry.ErrorForbidden):
+			h.logger.Error(err, "Failed to apply input, the access token is rejected")
+		case errors.Is(err, retry.ErrorNotFound):
+			h.logger.Error(err, fmt.Sprintf("Failed to apply input, project %d or merge request %d is not found", input.ProjectId, input.MergeRequestId))
+		case errors.Is(err, retry.ErrorRateLimited), errors.Is(err, retry.ErrorServer):
+			h.logger.Error(err, "Failed to apply input after retries, try again later")
+		default:
+			h.logger.Error(err, "Failed to apply input")
+		}
 		return errors.Wrap(err, "Failed to apply input")
 	}
+	h.reviews.WithLabelValues(metrics.OutcomePosted).Inc()
 
+	return h.failOn(input, output)
+}
+
+// failOn return ErrorFindingsFound if any of the findings is at least the fail-on severity
+func (h *mergeRequestHandler) failOn(input *usecase.MergeRequestReviewInput, output *usecase.MergeRequestReviewOutput) error {
+	if len(input.FailOn) == 0 {
+		return nil
+	}
+	if findings := domain.FindingsAtLeast(output.Findings, input.FailOn); len(findings) > 0 {
+		h.logger.Info(fmt.Sprintf("Found %d findings at least %s", len(findings), input.FailOn))
+		return errors.Wrapf(ErrorFindingsFound, "%d findings are at least %s", len(findings), input.FailOn)
+	}
 	return nil
 }
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"gitlab-mr-reviewer/pkg/internal/usecase"
+	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/metrics"
+)
+
+type mockMergeRequestReviewer struct {
+	findings []domain.Finding
+	err      error
+}
+
+func (m *mockMergeRequestReviewer) Apply(ctx context.Context, input *usecase.MergeRequestReviewInput) (*usecase.MergeRequestReviewOutput, error) {
+	// the partial review is posted, so its output is returned along with the error like the usecase does
+	if m.err != nil && !errors.Is(m.err, usecase.ErrorPartialReview) {
+		return nil, m.err
+	}
+	return &usecase.MergeRequestReviewOutput{Findings: m.findings}, m.err
+}
+
+var _ = ginkgo.Describe("MergeRequestHandler", func() {
+	var mergeRequestHandler MergeRequestHandler
+	var reviewerMetrics *metrics.ReviewerMetrics
+
+	newInput := func(failOn domain.Severity) *usecase.MergeRequestReviewInput {
+		return &usecase.MergeRequestReviewInput{
+			ProjectId:      1,
+			MergeRequestId: 2,
+			Model:          "gpt-4o-mini",
+			MaxInputToken:  10000,
+			MaxOutputToken: 10000,
+			FailOn:         failOn,
+		}
+	}
+
+	ginkgo.BeforeEach(func() {
+		logger, err := logging.NewZaprLogger(false, "info")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		reviewerMetrics = metrics.NewReviewerMetrics()
+		mergeRequestHandler = NewMergeRequestHandler(logger, &mockMergeRequestReviewer{
+			findings: []domain.Finding{
+				{Path: "main.go", StartLine: 1, EndLine: 1, Severity: domain.SeverityMedium, Message: "unused variable"},
+			},
+		}, reviewerMetrics.Reviews)
+	})
+
+	ginkgo.It("Should not fail without fail-on", func() {
+		gomega.Expect(mergeRequestHandler.Review(context.Background(), newInput(""))).To(gomega.Succeed())
+	})
+
+	ginkgo.It("Should not fail with findings below fail-on", func() {
+		gomega.Expect(mergeRequestHandler.Review(context.Background(), newInput(domain.SeverityHigh))).To(gomega.Succeed())
+	})
+
+	ginkgo.It("Should fail with findings at least fail-on", func() {
+		err := mergeRequestHandler.Review(context.Background(), newInput(domain.SeverityLow))
+		gomega.Expect(errors.Is(err, ErrorFindingsFound)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should fail with findings at least fail-on even if the review is partial", func() {
+		logger, err := logging.NewZaprLogger(false, "info")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		partialHandler := NewMergeRequestHandler(logger, &mockMergeRequestReviewer{
+			findings: []domain.Finding{
+				{Path: "main.go", StartLine: 1, EndLine: 1, Severity: domain.SeverityCritical, Message: "SQL injection"},
+			},
+			err: errors.Wrap(usecase.ErrorPartialReview, "Failed to review 1 of 2 batches"),
+		}, reviewerMetrics.Reviews)
+
+		err = partialHandler.Review(context.Background(), newInput(domain.SeverityHigh))
+		gomega.Expect(errors.Is(err, ErrorFindingsFound)).To(gomega.BeTrue())
+		gomega.Expect(err.Error()).To(gomega.ContainSubstring("Failed to review 1 of 2 batches"))
+
+		ginkgo.By("the partial review without the blocking findings should still fail as partial")
+		err = partialHandler.Review(context.Background(), newInput(""))
+		gomega.Expect(errors.Is(err, usecase.ErrorPartialReview)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should reject invalid fail-on", func() {
+		err := mergeRequestHandler.Review(context.Background(), newInput("blocker"))
+		gomega.Expect(errors.Is(err, ErrorInvalidInput)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should count the reviews by the outcome", func() {
+		logger, err := logging.NewZaprLogger(false, "info")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		gomega.Expect(mergeRequestHandler.Review(context.Background(), newInput(""))).To(gomega.Succeed())
+		ignoredHandler := NewMergeRequestHandler(logger, &mockMergeRequestReviewer{err: usecase.ErrorIgnoreCodeReview}, reviewerMetrics.Reviews)
+		gomega.Expect(ignoredHandler.Review(context.Background(), newInput(""))).To(gomega.Succeed())
+		failedHandler := NewMergeRequestHandler(logger, &mockMergeRequestReviewer{err: errors.New("Failed to create chat completion")}, reviewerMetrics.Reviews)
+		gomega.Expect(failedHandler.Review(context.Background(), newInput(""))).ToNot(gomega.Succeed())
+		partialHandler := NewMergeRequestHandler(logger, &mockMergeRequestReviewer{err: errors.Wrap(usecase.ErrorPartialReview, "Failed to review 1 of 2 batches")}, reviewerMetrics.Reviews)
+		gomega.Expect(errors.Is(partialHandler.Review(context.Background(), newInput("")), usecase.ErrorPartialReview)).To(gomega.BeTrue())
+
+		gomega.Expect(testutil.ToFloat64(reviewerMetrics.Reviews.WithLabelValues(metrics.OutcomeFailed))).To(gomega.Equal(2.0))
+		gomega.Expect(testutil.ToFloat64(reviewerMetrics.Reviews.WithLabelValues(metrics.OutcomeIgnored))).To(gomega.Equal(1.0))
+		gomega.Expect(testutil.ToFloat64(reviewerMetrics.Reviews.WithLabelValues(metrics.OutcomePosted))).To(gomega.Equal(1.0))
+	})
+})
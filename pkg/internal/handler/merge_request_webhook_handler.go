@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/internal/usecase"
+	"gitlab-mr-reviewer/pkg/logging"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	headerGitlabToken = "X-Gitlab-Token"
+	headerGitlabEvent = "X-Gitlab-Event"
+
+	mergeRequestHookEvent = "Merge Request Hook"
+
+	// maxPayloadBytes is far larger than the merge request events, which don't have the diffs
+	maxPayloadBytes = 1 << 20
+
+	actionOpen   = "open"
+	actionReopen = "reopen"
+	actionUpdate = "update"
+)
+
+var reviewActions = []string{actionOpen, actionReopen, actionUpdate}
+
+// mergeRequestEventDto is the payload of the Gitlab merge request event, see https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html#merge-request-events
+type mergeRequestEventDto struct {
+	ObjectKind string `json:"object_kind"`
+	Project    struct {
+		Id int32 `json:"id"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		Iid    int32  `json:"iid"`
+		Action string `json:"action"`
+		// Oldrev is only present when the update action pushes new commits
+		Oldrev string `json:"oldrev"`
+	} `json:"object_attributes"`
+}
+
+// WebhookHandler is the http.Handler of the webhook, which reviews the merge requests in the background
+type WebhookHandler interface {
+	http.Handler
+	// Wait block until the reviews in the background are finished
+	Wait()
+}
+
+type mergeRequestWebhookHandler struct {
+	logger              *logging.ZaprLogger
+	secretToken         string
+	model               string
+	maxInputToken       int64
+	maxOutputToken      int64
+	reviewTimeout       time.Duration
+	mergeRequestHandler MergeRequestHandler
+
+	// mutex guards the reviewing
+	mutex sync.Mutex
+	// reviewing holds the merge requests under review, the value is whether an event arrived during the review,
+	// which reruns the review once the current one finishes
+	reviewing map[string]bool
+	// reviews are the reviews in the background, which the server waits for before it exits
+	reviews sync.WaitGroup
+}
+
+func NewMergeRequestWebhookHandler(
+	logger *logging.ZaprLogger,
+	secretToken string,
+	model string,
+	maxInputToken int64,
+	maxOutputToken int64,
+	reviewTimeout time.Duration,
+	mergeRequestHandler MergeRequestHandler) WebhookHandler {
+	return &mergeRequestWebhookHandler{
+		logger:              logger,
+		secretToken:         secretToken,
+		model:               model,
+		maxInputToken:       maxInputToken,
+		maxOutputToken:      maxOutputToken,
+		reviewTimeout:       reviewTimeout,
+		mergeRequestHandler: mergeRequestHandler,
+		reviewing:           map[string]bool{},
+	}
+}
+
+func (h *mergeRequestWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(headerGitlabToken)), []byte(h.secretToken)) != 1 {
+		h.logger.Info("Reject the webhook with invalid token")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if event := r.Header.Get(headerGitlabEvent); event != mergeRequestHookEvent {
+		h.logger.Info(fmt.Sprintf("Ignore the webhook event: %s", event))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var event mergeRequestEventDto
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPayloadBytes)).Decode(&event); err != nil {
+		h.logger.Error(err, "Failed to decode the webhook payload")
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	logger := h.logger.
+		WithValues("project", event.Project.Id).
+		WithValues("mergeRequest", event.ObjectAttributes.Iid).
+		WithValues("action", event.ObjectAttributes.Action)
+	if !shouldReview(event) {
+		logger.Info("Ignore the merge request event")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	key := fmt.Sprintf("%d/%d", event.Project.Id, event.ObjectAttributes.Iid)
+	if !h.startReview(key) {
+		logger.Info("Rerun the review of the merge request after the current one")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// Gitlab expects the webhook to respond quickly, the review is done in the background
+	h.reviews.Add(1)
+	go func() {
+		defer h.reviews.Done()
+		for {
+			h.review(logger, event)
+			if !h.finishReview(key) {
+				return
+			}
+			logger.Info("Rerun the review for the events during the review")
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *mergeRequestWebhookHandler) Wait() {
+	h.reviews.Wait()
+}
+
+func (h *mergeRequestWebhookHandler) review(logger logr.Logger, event mergeRequestEventDto) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), h.reviewTimeout)
+	defer cancelFunc()
+	err := h.mergeRequestHandler.Review(ctx, &usecase.MergeRequestReviewInput{
+		ProjectId:      event.Project.Id,
+		MergeRequestId: event.ObjectAttributes.Iid,
+		Model:          h.model,
+		MaxInputToken:  h.maxInputToken,
+		MaxOutputToken: h.maxOutputToken,
+	})
+	if err != nil {
+		logger.Error(err, "Failed to review the merge request")
+		return
+	}
+	logger.Info("Reviewed the merge request")
+}
+
+// startReview mark the merge request as under review, or mark the rerun as pending if it is already under review
+func (h *mergeRequestWebhookHandler) startReview(key string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.reviewing[key]; ok {
+		h.reviewing[key] = true
+		return false
+	}
+	h.reviewing[key] = false
+	return true
+}
+
+// finishReview return whether the review has to rerun, otherwise the merge request is no longer under review
+func (h *mergeRequestWebhookHandler) finishReview(key string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.reviewing[key] {
+		h.reviewing[key] = false
+		return true
+	}
+	delete(h.reviewing, key)
+	return false
+}
+
+// shouldReview accept the opened, reopened merge requests, and the updates which push new commits
+func shouldReview(event mergeRequestEventDto) bool {
+	if event.ObjectKind != "merge_request" || !slices.Contains(reviewActions, event.ObjectAttributes.Action) {
+		return false
+	}
+	if event.ObjectAttributes.Action == actionUpdate && len(event.ObjectAttributes.Oldrev) == 0 {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"context"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"gitlab-mr-reviewer/pkg/internal/usecase"
+	"gitlab-mr-reviewer/pkg/logging"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockMergeRequestHandler struct {
+	inputs chan *usecase.MergeRequestReviewInput
+	// release blocks every review until it receives, if it is given
+	release chan struct{}
+}
+
+func (m *mockMergeRequestHandler) Review(ctx context.Context, input *usecase.MergeRequestReviewInput) error {
+	m.inputs <- input
+	if m.release != nil {
+		<-m.release
+	}
+	return nil
+}
+
+func TestMergeRequestWebhookHandler(t *testing.T) {
+	gomega.RegisterTestingT(t)
+
+	var _ = ginkgo.Describe("MergeRequestWebhookHandler", ginkgo.Ordered, func() {
+		var mergeRequestHandler *mockMergeRequestHandler
+		var webhookHandler WebhookHandler
+		var logger *logging.ZaprLogger
+		secretToken := "fake-secret"
+
+		sendEvent := func(token string, event string, payload string) int {
+			request := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+			request.Header.Set(headerGitlabToken, token)
+			request.Header.Set(headerGitlabEvent, event)
+			recorder := httptest.NewRecorder()
+			webhookHandler.ServeHTTP(recorder, request)
+			return recorder.Code
+		}
+
+		ginkgo.BeforeAll(func() {
+			var err error
+			logger, err = logging.NewZaprLogger(false, "info")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			mergeRequestHandler = &mockMergeRequestHandler{inputs: make(chan *usecase.MergeRequestReviewInput, 10)}
+			webhookHandler = NewMergeRequestWebhookHandler(logger, secretToken, "gpt-4o-mini", 10000, 10000, time.Minute, mergeRequestHandler)
+		})
+
+		ginkgo.It("Should reject invalid token", func() {
+			code := sendEvent("wrong-secret", mergeRequestHookEvent, `{"object_kind":"merge_request"}`)
+			gomega.Expect(code).To(gomega.Equal(http.StatusUnauthorized))
+			gomega.Consistently(mergeRequestHandler.inputs, 100*time.Millisecond).ShouldNot(gomega.Receive())
+		})
+
+		ginkgo.It("Should reject the payload which is too large", func() {
+			code := sendEvent(secretToken, mergeRequestHookEvent, `{"object_kind":"merge_request","description":"`+strings.Repeat("a", maxPayloadBytes)+`"}`)
+			gomega.Expect(code).To(gomega.Equal(http.StatusRequestEntityTooLarge))
+			gomega.Consistently(mergeRequestHandler.inputs, 100*time.Millisecond).ShouldNot(gomega.Receive())
+		})
+
+		ginkgo.It("Should ignore other events", func() {
+			code := sendEvent(secretToken, "Push Hook", `{"object_kind":"push"}`)
+			gomega.Expect(code).To(gomega.Equal(http.StatusNoContent))
+		})
+
+		ginkgo.It("Should ignore updates without new commits", func() {
+			code := sendEvent(secretToken, mergeRequestHookEvent, `{"object_kind":"merge_request","project":{"id":1},"object_attributes":{"iid":2,"action":"update"}}`)
+			gomega.Expect(code).To(gomega.Equal(http.StatusNoContent))
+
+			code = sendEvent(secretToken, mergeRequestHookEvent, `{"object_kind":"merge_request","project":{"id":1},"object_attributes":{"iid":2,"action":"merge"}}`)
+			gomega.Expect(code).To(gomega.Equal(http.StatusNoContent))
+			gomega.Consistently(mergeRequestHandler.inputs, 100*time.Millisecond).ShouldNot(gomega.Receive())
+		})
+
+		ginkgo.It("Should review opened merge request", func() {
+			code := sendEvent(secretToken, mergeRequestHookEvent, `{"object_kind":"merge_request","project":{"id":1},"object_attributes":{"iid":2,"action":"open"}}`)
+			gomega.Expect(code).To(gomega.Equal(http.StatusAccepted))
+
+			var input *usecase.MergeRequestReviewInput
+			gomega.Eventually(mergeRequestHandler.inputs).Should(gomega.Receive(&input))
+			gomega.Expect(input.ProjectId).To(gomega.Equal(int32(1)))
+			gomega.Expect(input.MergeRequestId).To(gomega.Equal(int32(2)))
+			gomega.Expect(input.Model).To(gomega.Equal("gpt-4o-mini"))
+		})
+
+		ginkgo.It("Should review updates with new commits", func() {
+			code := sendEvent(secretToken, mergeRequestHookEvent, `{"object_kind":"merge_request","project":{"id":1},"object_attributes":{"iid":3,"action":"update","oldrev":"63c97907ceb628e4fbbc125ca9ce5bd2a1f9566f"}}`)
+			gomega.Expect(code).To(gomega.Equal(http.StatusAccepted))
+
+			var input *usecase.MergeRequestReviewInput
+			gomega.Eventually(mergeRequestHandler.inputs).Should(gomega.Receive(&input))
+			gomega.Expect(input.MergeRequestId).To(gomega.Equal(int32(3)))
+		})
+
+		ginkgo.It("Should rerun the review once for the events during the review", func() {
+			mergeRequestHandler := &mockMergeRequestHandler{inputs: make(chan *usecase.MergeRequestReviewInput, 10), release: make(chan struct{})}
+			webhookHandler = NewMergeRequestWebhookHandler(logger, secretToken, "gpt-4o-mini", 10000, 10000, time.Minute, mergeRequestHandler)
+			update := `{"object_kind":"merge_request","project":{"id":1},"object_attributes":{"iid":4,"action":"update","oldrev":"63c97907ceb628e4fbbc125ca9ce5bd2a1f9566f"}}`
+
+			gomega.Expect(sendEvent(secretToken, mergeRequestHookEvent, update)).To(gomega.Equal(http.StatusAccepted))
+			gomega.Eventually(mergeRequestHandler.inputs).Should(gomega.Receive())
+
+			ginkgo.By("the events during the review should be merged into a single rerun")
+			gomega.Expect(sendEvent(secretToken, mergeRequestHookEvent, update)).To(gomega.Equal(http.StatusAccepted))
+			gomega.Expect(sendEvent(secretToken, mergeRequestHookEvent, update)).To(gomega.Equal(http.StatusAccepted))
+			gomega.Consistently(mergeRequestHandler.inputs, 100*time.Millisecond).ShouldNot(gomega.Receive())
+
+			mergeRequestHandler.release <- struct{}{}
+			gomega.Eventually(mergeRequestHandler.inputs).Should(gomega.Receive())
+			mergeRequestHandler.release <- struct{}{}
+			gomega.Consistently(mergeRequestHandler.inputs, 100*time.Millisecond).ShouldNot(gomega.Receive())
+
+			ginkgo.By("the reviews should be finished after waiting")
+			webhookHandler.Wait()
+			gomega.Expect(sendEvent(secretToken, mergeRequestHookEvent, update)).To(gomega.Equal(http.StatusAccepted))
+			gomega.Eventually(mergeRequestHandler.inputs).Should(gomega.Receive())
+			mergeRequestHandler.release <- struct{}{}
+			webhookHandler.Wait()
+		})
+	})
+
+	ginkgo.RunSpecs(t, "MergeRequestWebhookHandler test")
+}
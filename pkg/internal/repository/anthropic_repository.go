@@ -0,0 +1,334 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultAnthropicBaseUrl = "https://api.anthropic.com"
+	anthropicVersion        = "2023-06-01"
+
+	anthropicContentTypeText    = "text"
+	anthropicContentTypeToolUse = "tool_use"
+
+	anthropicEventMessageStart      = "message_start"
+	anthropicEventContentBlockStart = "content_block_start"
+	anthropicEventContentBlockDelta = "content_block_delta"
+	anthropicEventMessageDelta      = "message_delta"
+	anthropicEventPing              = "ping"
+	anthropicEventError             = "error"
+)
+
+// anthropicMessageRequestDto is the request of the Anthropic Messages API, see https://docs.anthropic.com/en/api/messages
+type anthropicMessageRequestDto struct {
+	Model     string                `json:"model"`
+	MaxTokens int64                 `json:"max_tokens"`
+	System    string                `json:"system,omitempty"`
+	Messages  []anthropicMessageDto `json:"messages"`
+	// Tools and ToolChoice force the response to conform to the input schema of the tool
+	Tools      []anthropicToolDto      `json:"tools,omitempty"`
+	ToolChoice *anthropicToolChoiceDto `json:"tool_choice,omitempty"`
+	Stream     bool                    `json:"stream,omitempty"`
+}
+type anthropicToolDto struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+type anthropicToolChoiceDto struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+type anthropicMessageDto struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+type anthropicMessageResponseDto struct {
+	Model      string                `json:"model"`
+	Role       string                `json:"role"`
+	StopReason string                `json:"stop_reason"`
+	Content    []anthropicContentDto `json:"content"`
+	Usage      struct {
+		InputTokens  int64 `json:"input_tokens"`
+		OutputTokens int64 `json:"output_tokens"`
+	} `json:"usage"`
+}
+type anthropicContentDto struct {
+	Type  string          `json:"type"`
+	Text  string          `json:"text"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+// anthropicStreamEventDto is an event of the streamed message, see https://docs.anthropic.com/en/docs/build-with-claude/streaming
+type anthropicStreamEventDto struct {
+	Type         string                      `json:"type"`
+	Index        int                         `json:"index"`
+	Message      anthropicMessageResponseDto `json:"message"`
+	ContentBlock anthropicContentDto         `json:"content_block"`
+	Delta        struct {
+		Text        string `json:"text"`
+		PartialJson string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int64 `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type anthropicRepository struct {
+	logger       *logging.ZaprLogger
+	httpClient   *http.Client
+	baseUrl      string
+	apiKey       string
+	streamOption StreamOption
+}
+
+func NewAnthropicRepository(logger *logging.ZaprLogger, httpClient *http.Client, baseUrl string, apiKey string, streamOption StreamOption) LLMRepository {
+	if len(baseUrl) == 0 {
+		baseUrl = defaultAnthropicBaseUrl
+	}
+	return &anthropicRepository{
+		logger:       logger,
+		httpClient:   httpClient,
+		baseUrl:      strings.TrimSuffix(baseUrl, "/"),
+		apiKey:       apiKey,
+		streamOption: streamOption,
+	}
+}
+
+func (r *anthropicRepository) SummarizeRelativeChanges(ctx context.Context, input SummarizeRelativeChangesInput) (SummarizeRelativeChangesOutput, error) {
+	messages, usage, err := r.createMessage(ctx, stageSummarizeRelativeChanges, input.MessageContext, input.Model, input.MaxOutputToken, nil)
+	if err != nil {
+		return SummarizeRelativeChangesOutput{}, err
+	}
+	return SummarizeRelativeChangesOutput{Messages: messages, Usage: usage}, nil
+}
+
+func (r *anthropicRepository) SummarizeReleaseNote(ctx context.Context, input SummarizeReleaseNoteInput) (SummarizeReleaseNoteOutput, error) {
+	messages, usage, err := r.createMessage(ctx, stageSummarizeReleaseNote, input.MessageContext, input.Model, input.MaxOutputToken, nil)
+	if err != nil {
+		return SummarizeReleaseNoteOutput{}, err
+	}
+	return SummarizeReleaseNoteOutput{Messages: messages, Usage: usage}, nil
+}
+
+func (r *anthropicRepository) ReviewRelativeChanges(ctx context.Context, input ReviewRelativeChangesInput) (ReviewRelativeChangesOutput, error) {
+	messages, usage, err := r.createMessage(ctx, stageReviewRelativeChanges, input.MessageContext, input.Model, input.MaxOutputToken, &input.ResponseSchema)
+	if err != nil {
+		return ReviewRelativeChangesOutput{}, err
+	}
+	return ReviewRelativeChangesOutput{Messages: messages, Usage: usage}, nil
+}
+
+// createMessage create the message, the response is the input of a forced tool call which conforms to the responseSchema if it is given
+func (r *anthropicRepository) createMessage(ctx context.Context, stage string, messageContext []domain.Message, model string, maxOutputToken int64, responseSchema *ResponseSchema) ([]domain.Message, Usage, error) {
+	requestBody, err := toAnthropicMessageRequest(messageContext, model, maxOutputToken)
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	requestBody.Stream = r.streamOption.Enabled
+	if responseSchema != nil {
+		requestBody.Tools = []anthropicToolDto{{
+			Name:        responseSchema.Name,
+			Description: responseSchema.Description,
+			InputSchema: responseSchema.Schema,
+		}}
+		requestBody.ToolChoice = &anthropicToolChoiceDto{Type: "tool", Name: responseSchema.Name}
+	}
+	requestBodyByte, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, Usage{}, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseUrl+"/v1/messages", bytes.NewReader(requestBodyByte))
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	request.Header.Set("x-api-key", r.apiKey)
+	request.Header.Set("anthropic-version", anthropicVersion)
+	request.Header.Set("Content-Type", "application/json")
+
+	var resp anthropicMessageResponseDto
+	if r.streamOption.Enabled {
+		resp, err = r.receiveMessage(ctx, stage, model, request)
+	} else {
+		resp, err = r.sendMessage(ctx, request)
+	}
+	if err != nil {
+		return nil, Usage{}, err
+	}
+
+	var texts []string
+	for _, content := range resp.Content {
+		switch {
+		case content.Type == anthropicContentTypeText && responseSchema == nil:
+			texts = append(texts, content.Text)
+		case content.Type == anthropicContentTypeToolUse && responseSchema != nil && content.Name == responseSchema.Name:
+			texts = append(texts, string(content.Input))
+		}
+	}
+	if len(texts) == 0 {
+		return nil, Usage{}, errors.New("response has no text content")
+	}
+
+	r.logger.Info(fmt.Sprintf("Response Model: %s", resp.Model))
+	r.logger.Info(fmt.Sprintf("Response StopReason: %s", resp.StopReason))
+	r.logger.Info(fmt.Sprintf("Response InputTokens: %d", resp.Usage.InputTokens))
+	r.logger.Info(fmt.Sprintf("Response OutputTokens: %d", resp.Usage.OutputTokens))
+	r.logger.Info(fmt.Sprintf("Received response role: %s", resp.Role))
+	content := strings.Join(texts, "")
+	r.logger.Info(fmt.Sprintf("Received response content: %s", content))
+
+	usage := Usage{InputTokens: resp.Usage.InputTokens, OutputTokens: resp.Usage.OutputTokens}
+	return []domain.Message{domain.NewAssistantMessage(content)}, usage, nil
+}
+
+func (r *anthropicRepository) sendMessage(ctx context.Context, request *http.Request) (anthropicMessageResponseDto, error) {
+	response, err := r.httpClient.Do(request.WithContext(retry.WithAttemptTimeout(ctx, completionAttemptTimeout)))
+	if err != nil {
+		return anthropicMessageResponseDto{}, err
+	}
+	defer response.Body.Close()
+
+	bodyBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return anthropicMessageResponseDto{}, err
+	}
+	if response.StatusCode != http.StatusOK {
+		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
+		return anthropicMessageResponseDto{}, errors.Wrap(retry.NewStatusError(response.StatusCode, string(bodyBytes)), "Failed to create message")
+	}
+
+	var resp anthropicMessageResponseDto
+	if err := json.Unmarshal(bodyBytes, &resp); err != nil {
+		return anthropicMessageResponseDto{}, err
+	}
+	return resp, nil
+}
+
+// receiveMessage assemble the events of the streamed message into the same response as the one which isn't streamed
+func (r *anthropicRepository) receiveMessage(ctx context.Context, stage string, model string, request *http.Request) (anthropicMessageResponseDto, error) {
+	ctx, completionStream := newCompletionStream(ctx, r.streamOption, model, stage)
+	response, err := r.httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return anthropicMessageResponseDto{}, completionStream.done(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		bodyBytes, err := io.ReadAll(response.Body)
+		if err != nil {
+			return anthropicMessageResponseDto{}, completionStream.done(err)
+		}
+		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
+		return anthropicMessageResponseDto{}, completionStream.done(errors.Wrap(retry.NewStatusError(response.StatusCode, string(bodyBytes)), "Failed to create message"))
+	}
+
+	resp, err := readAnthropicStream(response.Body, completionStream)
+	if err := completionStream.done(err); err != nil {
+		return anthropicMessageResponseDto{}, err
+	}
+	return resp, nil
+}
+
+// readAnthropicStream read the server-sent events of the streamed message, the input of a tool call is streamed as the partial JSON
+func readAnthropicStream(body io.Reader, completionStream *completionStream) (anthropicMessageResponseDto, error) {
+	var resp anthropicMessageResponseDto
+	var partialJsons []string
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event anthropicStreamEventDto
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return anthropicMessageResponseDto{}, errors.Wrap(err, "Failed to decode the event of the stream")
+		}
+		// the server keeps sending the pings while the model is stuck, which don't reset the idle timeout
+		if event.Type == anthropicEventPing {
+			continue
+		}
+		completionStream.receive(event.Delta.Text + event.Delta.PartialJson)
+
+		switch event.Type {
+		case anthropicEventMessageStart:
+			resp = event.Message
+		case anthropicEventContentBlockStart:
+			if event.Index != len(resp.Content) {
+				return anthropicMessageResponseDto{}, errors.Errorf("Unexpected content block %d of the stream", event.Index)
+			}
+			resp.Content = append(resp.Content, event.ContentBlock)
+			partialJsons = append(partialJsons, "")
+		case anthropicEventContentBlockDelta:
+			if event.Index >= len(resp.Content) {
+				return anthropicMessageResponseDto{}, errors.Errorf("Unexpected content block %d of the stream", event.Index)
+			}
+			resp.Content[event.Index].Text += event.Delta.Text
+			partialJsons[event.Index] += event.Delta.PartialJson
+		case anthropicEventMessageDelta:
+			resp.StopReason = event.Delta.StopReason
+			resp.Usage.OutputTokens = event.Usage.OutputTokens
+		case anthropicEventError:
+			return anthropicMessageResponseDto{}, errors.Errorf("Failed to receive message: %s %s", event.Error.Type, event.Error.Message)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return anthropicMessageResponseDto{}, err
+	}
+
+	for i, partialJson := range partialJsons {
+		if resp.Content[i].Type == anthropicContentTypeToolUse && len(partialJson) > 0 {
+			resp.Content[i].Input = json.RawMessage(partialJson)
+		}
+	}
+	return resp, nil
+}
+
+// toAnthropicMessageRequest move the system messages to the top-level system prompt, and merge the consecutive messages of the same role,
+// since the Messages API only accepts the user and assistant roles in turn
+func toAnthropicMessageRequest(messageContext []domain.Message, model string, maxOutputToken int64) (anthropicMessageRequestDto, error) {
+	var systemPrompts []string
+	var messages []anthropicMessageDto
+	for _, message := range messageContext {
+		switch message.Role {
+		case domain.RoleSystem:
+			systemPrompts = append(systemPrompts, message.Content)
+		case domain.RoleUser, domain.RoleAssistant:
+			if last := len(messages) - 1; last >= 0 && messages[last].Role == message.Role {
+				messages[last].Content += "\n\n" + message.Content
+				continue
+			}
+			messages = append(messages, anthropicMessageDto{Role: message.Role, Content: message.Content})
+		default:
+			return anthropicMessageRequestDto{}, errors.Errorf("Unsupported role %s", message.Role)
+		}
+	}
+	if len(messages) == 0 || messages[0].Role != domain.RoleUser {
+		return anthropicMessageRequestDto{}, errors.New("The first message must be a user message")
+	}
+
+	return anthropicMessageRequestDto{
+		Model:     model,
+		MaxTokens: maxOutputToken,
+		System:    strings.Join(systemPrompts, "\n\n"),
+		Messages:  messages,
+	}, nil
+}
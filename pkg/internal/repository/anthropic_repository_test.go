@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+const mockAnthropicModel = "claude-sonnet-4-5"
+
+func runMockAnthropicServer(apiKey string, requests chan<- anthropicMessageRequestDto) *httptest.Server {
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("POST /v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != apiKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+			return
+		}
+		if r.Header.Get("anthropic-version") != anthropicVersion {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var request anthropicMessageRequestDto
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- request
+
+		if request.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, event := range []string{
+				`{"type": "message_start", "message": {"id": "msg_01", "type": "message", "role": "assistant", "model": "claude-sonnet-4-5", "content": [], "stop_reason": null, "usage": {"input_tokens": 20, "output_tokens": 1}}}`,
+				`{"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}`,
+				`{"type": "ping"}`,
+				`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Reporting."}}`,
+				`{"type": "content_block_stop", "index": 0}`,
+				`{"type": "content_block_start", "index": 1, "content_block": {"type": "tool_use", "id": "toolu_01", "name": "report_findings", "input": {}}}`,
+				`{"type": "content_block_delta", "index": 1, "delta": {"type": "input_json_delta", "partial_json": "{\"findings\""}}`,
+				`{"type": "content_block_delta", "index": 1, "delta": {"type": "input_json_delta", "partial_json": ": []}"}}`,
+				`{"type": "content_block_stop", "index": 1}`,
+				`{"type": "message_delta", "delta": {"stop_reason": "tool_use"}, "usage": {"output_tokens": 5}}`,
+				`{"type": "message_stop"}`,
+			} {
+				var eventType struct {
+					Type string `json:"type"`
+				}
+				json.Unmarshal([]byte(event), &eventType)
+				w.Write([]byte("event: " + eventType.Type + "\ndata: " + event + "\n\n"))
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if request.ToolChoice != nil {
+			w.Write([]byte(`{
+			"role": "assistant",
+			"model": "claude-sonnet-4-5",
+			"stop_reason": "tool_use",
+			"content": [{"type": "text", "text": "Reporting."}, {"type": "tool_use", "id": "toolu_01", "name": "report_findings", "input": {"findings": []}}],
+			"usage": {"input_tokens": 20, "output_tokens": 5}
+		}`))
+			return
+		}
+		w.Write([]byte(`{
+			"id": "msg_01",
+			"type": "message",
+			"role": "assistant",
+			"model": "claude-sonnet-4-5",
+			"stop_reason": "end_turn",
+			"content": [{"type": "text", "text": "The change "}, {"type": "text", "text": "looks good."}],
+			"usage": {"input_tokens": 20, "output_tokens": 5}
+		}`))
+	})
+	return httptest.NewServer(serveMux)
+}
+
+var _ = ginkgo.Describe("Anthropic Repository", ginkgo.Ordered, func() {
+	var logger *logging.ZaprLogger
+	var testServer *httptest.Server
+	var requests chan anthropicMessageRequestDto
+	apiKey := "fake-api-key"
+
+	ginkgo.BeforeAll(func() {
+		var err error
+		logger, err = logging.NewZaprLogger(false, "info")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		requests = make(chan anthropicMessageRequestDto, 10)
+		testServer = runMockAnthropicServer(apiKey, requests)
+	})
+
+	ginkgo.AfterAll(func() {
+		testServer.Close()
+	})
+
+	ginkgo.It("Should summarize relative changes with the system prompt", func() {
+		r := NewAnthropicRepository(logger, &http.Client{}, testServer.URL, apiKey, StreamOption{})
+		output, err := r.SummarizeRelativeChanges(context.Background(), SummarizeRelativeChangesInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{
+					{Role: domain.RoleSystem, Content: "You are a reviewer."},
+					domain.NewUserMessage("Summarize the changes."),
+					domain.NewUserMessage("diff --git a/main.go b/main.go"),
+				},
+				MaxOutputToken: 1000,
+				Model:          mockAnthropicModel,
+			},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(output.Messages).To(gomega.Equal([]domain.Message{domain.NewAssistantMessage("The change looks good.")}))
+
+		var request anthropicMessageRequestDto
+		gomega.Eventually(requests).Should(gomega.Receive(&request))
+		gomega.Expect(request.Model).To(gomega.Equal(mockAnthropicModel))
+		gomega.Expect(request.MaxTokens).To(gomega.Equal(int64(1000)))
+		gomega.Expect(request.System).To(gomega.Equal("You are a reviewer."))
+		gomega.Expect(request.Messages).To(gomega.Equal([]anthropicMessageDto{
+			{Role: domain.RoleUser, Content: "Summarize the changes.\n\ndiff --git a/main.go b/main.go"},
+		}))
+	})
+
+	ginkgo.It("Should summarize release note after the previous answer", func() {
+		r := NewAnthropicRepository(logger, &http.Client{}, testServer.URL, apiKey, StreamOption{})
+		_, err := r.SummarizeReleaseNote(context.Background(), SummarizeReleaseNoteInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{
+					{Role: domain.RoleSystem, Content: "You are a reviewer."},
+					domain.NewUserMessage("Summarize the changes."),
+					domain.NewAssistantMessage("The change looks good."),
+					domain.NewUserMessage("Write the release note."),
+				},
+				MaxOutputToken: 1000,
+				Model:          mockAnthropicModel,
+			},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		var request anthropicMessageRequestDto
+		gomega.Eventually(requests).Should(gomega.Receive(&request))
+		gomega.Expect(request.Messages).To(gomega.HaveLen(3))
+		gomega.Expect(request.Messages[1].Role).To(gomega.Equal(domain.RoleAssistant))
+	})
+
+	ginkgo.It("Should force the tool call of the response schema", func() {
+		r := NewAnthropicRepository(logger, &http.Client{}, testServer.URL, apiKey, StreamOption{})
+		output, err := r.ReviewRelativeChanges(context.Background(), ReviewRelativeChangesInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{domain.NewUserMessage("Review the changes.")},
+				MaxOutputToken: 1000,
+				Model:          mockAnthropicModel,
+			},
+			ResponseSchema: ResponseSchema{Name: "report_findings", Schema: map[string]any{"type": "object"}},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(output.Messages).To(gomega.Equal([]domain.Message{domain.NewAssistantMessage(`{"findings": []}`)}))
+
+		var request anthropicMessageRequestDto
+		gomega.Eventually(requests).Should(gomega.Receive(&request))
+		gomega.Expect(request.Tools).To(gomega.HaveLen(1))
+		gomega.Expect(request.Tools[0].InputSchema).To(gomega.Equal(map[string]any{"type": "object"}))
+		gomega.Expect(*request.ToolChoice).To(gomega.Equal(anthropicToolChoiceDto{Type: "tool", Name: "report_findings"}))
+	})
+
+	ginkgo.It("Should return typed error with invalid api key", func() {
+		r := NewAnthropicRepository(logger, &http.Client{}, testServer.URL, "wrong-api-key", StreamOption{})
+		_, err := r.SummarizeRelativeChanges(context.Background(), SummarizeRelativeChangesInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{domain.NewUserMessage("Summarize the changes.")},
+				MaxOutputToken: 1000,
+				Model:          mockAnthropicModel,
+			},
+		})
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(errors.Is(err, retry.ErrorUnauthorized)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should assemble the streamed tool call", func() {
+		progress := &bytes.Buffer{}
+		r := NewAnthropicRepository(logger, &http.Client{}, testServer.URL, apiKey, StreamOption{Enabled: true, IdleTimeout: time.Second, Progress: progress})
+		output, err := r.ReviewRelativeChanges(context.Background(), ReviewRelativeChangesInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{domain.NewUserMessage("Review the changes.")},
+				MaxOutputToken: 1000,
+				Model:          mockAnthropicModel,
+			},
+			ResponseSchema: ResponseSchema{Name: "report_findings", Schema: map[string]any{"type": "object"}},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(output.Messages).To(gomega.Equal([]domain.Message{domain.NewAssistantMessage(`{"findings": []}`)}))
+		gomega.Expect(output.Usage).To(gomega.Equal(Usage{InputTokens: 20, OutputTokens: 5}))
+		gomega.Expect(progress.String()).To(gomega.ContainSubstring(mockAnthropicModel + " review_relative_changes: done"))
+
+		var request anthropicMessageRequestDto
+		gomega.Eventually(requests).Should(gomega.Receive(&request))
+		gomega.Expect(request.Stream).To(gomega.BeTrue())
+	})
+})
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/logging"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// CodeHostRepository reads the merge requests from the code host, and posts the review to them
+type CodeHostRepository interface {
+	ListDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error)
+	ListRawDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error)
+	GetMergeRequest(ctx context.Context, projectId, mergeRequestId int32) (*MergeRequestDto, error)
+	// ListMergeRequestCommits list the commits of the merge request, the oldest first
+	ListMergeRequestCommits(ctx context.Context, projectId, mergeRequestId int32) ([]CommitDto, error)
+	CreateMergeRequestSummary(context.Context, CreateMergeRequestSummaryInput) error
+	CreateMergeRequestDiscussion(context.Context, CreateMergeRequestDiscussionInput) error
+	// CreateMergeRequestDiscussions post the discussions of a review, a rejected discussion doesn't fail the others
+	CreateMergeRequestDiscussions(context.Context, []CreateMergeRequestDiscussionInput) error
+	ListMergeRequestNotes(ctx context.Context, projectId, mergeRequestId int32) ([]NoteDto, error)
+	UpdateMergeRequestNote(ctx context.Context, projectId, mergeRequestId int32, noteId int64, body string) error
+	GetReviewedHeadSha(ctx context.Context, projectId, mergeRequestId int32) (string, error)
+	CompareCommits(ctx context.Context, projectId int32, from, to string) (*CompareDto, error)
+	// ListComparedCommits list the commits of CompareCommits without the diffs, which are costly on some code hosts
+	ListComparedCommits(ctx context.Context, projectId int32, from, to string) ([]CommitDto, error)
+	// GetFile return the raw content of the file at the ref, the error wraps retry.ErrorNotFound if the file doesn't exist
+	GetFile(ctx context.Context, projectId int32, path, ref string) ([]byte, error)
+}
+
+// summaryNoteRepository is the code host which posts the summary note as the user of its token
+type summaryNoteRepository interface {
+	CodeHostRepository
+	// currentUsername return the username of the token, which is the author of the summary note
+	currentUsername(ctx context.Context) (string, error)
+}
+
+// currentUser caches the username of the token, which doesn't change while the process runs
+type currentUser struct {
+	mutex    sync.Mutex
+	username string
+}
+
+// get return the cached username, or fetch it on the first call
+func (u *currentUser) get(fetch func() (string, error)) (string, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if len(u.username) == 0 {
+		username, err := fetch()
+		if err != nil {
+			return "", errors.Wrap(err, "Failed to get the current user")
+		}
+		u.username = username
+	}
+	return u.username, nil
+}
+
+// createMergeRequestDiscussions post the discussions one by one, a rejected discussion is logged and the rest are still posted
+func createMergeRequestDiscussions(ctx context.Context, r CodeHostRepository, logger *logging.ZaprLogger, inputs []CreateMergeRequestDiscussionInput) error {
+	failed := 0
+	for _, input := range inputs {
+		if err := r.CreateMergeRequestDiscussion(ctx, input); err != nil {
+			logger.Error(err, fmt.Sprintf("Failed to create discussion on %s:%d", input.Position.NewPath, input.Position.NewLine))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("Failed to create %d of %d discussions", failed, len(inputs))
+	}
+	return nil
+}
+
+// getReviewedHeadSha return the head sha recorded in the summary note, or empty if the merge request hasn't been reviewed
+func getReviewedHeadSha(ctx context.Context, r summaryNoteRepository, projectId, mergeRequestId int32) (string, error) {
+	note, err := findSummaryNote(ctx, r, projectId, mergeRequestId)
+	if err != nil || note == nil {
+		return "", err
+	}
+	matches := headShaMarkerRegexp.FindStringSubmatch(note.Body)
+	if matches == nil {
+		return "", nil
+	}
+	return matches[1], nil
+}
+
+// findSummaryNote return the summary note posted by the bot, or nil if there is none,
+// the notes of the other users are skipped even if they copy the marker, so that they can't forge the reviewed head sha
+func findSummaryNote(ctx context.Context, r summaryNoteRepository, projectId, mergeRequestId int32) (*NoteDto, error) {
+	username, err := r.currentUsername(ctx)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := r.ListMergeRequestNotes(ctx, projectId, mergeRequestId)
+	if err != nil {
+		return nil, err
+	}
+	for _, note := range notes {
+		if !note.System && note.Author.Username == username && strings.HasPrefix(note.Body, summaryNoteMarker) {
+			return &note, nil
+		}
+	}
+	return nil, nil
+}
+
+// escapeFilePath escape every segment of the file path, but keep the slashes between them
+func escapeFilePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
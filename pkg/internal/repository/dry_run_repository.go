@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	stageSummarizeRelativeChanges = "summarize_relative_changes"
+	stageSummarizeReleaseNote     = "summarize_release_note"
+	stageReviewRelativeChanges    = "review_relative_changes"
+)
+
+// DryRunRecord collects what the review would post to Gitlab, along with the prompts sent to the LLM
+type DryRunRecord struct {
+	mutex sync.Mutex
+
+	ProjectId      int32                               `json:"project_id"`
+	MergeRequestId int32                               `json:"merge_request_id"`
+	SummaryNote    string                              `json:"summary_note"`
+	Discussions    []CreateMergeRequestDiscussionInput `json:"discussions"`
+	Completions    []DryRunCompletion                  `json:"completions"`
+	Usage          Usage                               `json:"usage"`
+}
+
+// DryRunCompletion is a completion of the LLM, along with the messages it is prompted with
+type DryRunCompletion struct {
+	Stage    string           `json:"stage"`
+	Model    string           `json:"model"`
+	Messages []domain.Message `json:"messages"`
+	Response []domain.Message `json:"response"`
+	Usage    Usage            `json:"usage"`
+}
+
+func (r *DryRunRecord) addCompletion(completion DryRunCompletion) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Completions = append(r.Completions, completion)
+	r.Usage.InputTokens += completion.Usage.InputTokens
+	r.Usage.OutputTokens += completion.Usage.OutputTokens
+}
+
+// Markdown render the record as a markdown document
+func (r *DryRunRecord) Markdown() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("# Dry run of merge request !%d of project %d\n\n", r.MergeRequestId, r.ProjectId))
+
+	builder.WriteString("## Summary note\n\n")
+	if len(r.SummaryNote) == 0 {
+		builder.WriteString("No summary note would be posted.\n\n")
+	} else {
+		builder.WriteString(r.SummaryNote)
+		builder.WriteString("\n\n")
+	}
+
+	builder.WriteString(fmt.Sprintf("## Inline comments (%d)\n\n", len(r.Discussions)))
+	for _, discussion := range r.Discussions {
+		builder.WriteString(fmt.Sprintf("### `%s` L%d\n\n%s\n\n", discussion.Position.NewPath, discussion.Position.NewLine, discussion.Body))
+	}
+
+	builder.WriteString(fmt.Sprintf("## Prompts (%d)\n\n", len(r.Completions)))
+	for i, completion := range r.Completions {
+		builder.WriteString(fmt.Sprintf("### %d. %s\n\n", i+1, completion.Stage))
+		for _, message := range completion.Messages {
+			builder.WriteString(fmt.Sprintf("#### %s\n\n%s\n\n", message.Role, fenceCodeBlock(message.Content)))
+		}
+		for _, message := range completion.Response {
+			builder.WriteString(fmt.Sprintf("#### response\n\n%s\n\n", fenceCodeBlock(message.Content)))
+		}
+	}
+
+	builder.WriteString("## Token usage\n\n")
+	builder.WriteString("| Stage | Model | Input tokens | Output tokens |\n")
+	builder.WriteString("|-------|-------|--------------|---------------|\n")
+	for i, completion := range r.Completions {
+		builder.WriteString(fmt.Sprintf("| %d. %s | %s | %d | %d |\n", i+1, completion.Stage, completion.Model, completion.Usage.InputTokens, completion.Usage.OutputTokens))
+	}
+	builder.WriteString(fmt.Sprintf("| Total | | %d | %d |\n", r.Usage.InputTokens, r.Usage.OutputTokens))
+
+	return builder.String()
+}
+
+// JSON render the record as an indented json document
+func (r *DryRunRecord) JSON() ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// fenceCodeBlock wrap the content with a fence longer than any backtick run inside it, since the prompts contain code blocks
+func fenceCodeBlock(content string) string {
+	longest, run := 0, 0
+	for _, c := range content {
+		if c == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", max(3, longest+1))
+	return fmt.Sprintf("%s\n%s\n%s", fence, strings.TrimSuffix(content, "\n"), fence)
+}
+
+type dryRunCodeHostRepository struct {
+	CodeHostRepository
+	record *DryRunRecord
+}
+
+// NewDryRunCodeHostRepository read from the given CodeHostRepository, and record the notes and discussions instead of posting them
+func NewDryRunCodeHostRepository(codeHostRepository CodeHostRepository, record *DryRunRecord) CodeHostRepository {
+	return &dryRunCodeHostRepository{
+		CodeHostRepository: codeHostRepository,
+		record:             record,
+	}
+}
+
+func (r *dryRunCodeHostRepository) CreateMergeRequestSummary(ctx context.Context, input CreateMergeRequestSummaryInput) error {
+	r.record.mutex.Lock()
+	defer r.record.mutex.Unlock()
+	r.record.ProjectId = input.ProjectId
+	r.record.MergeRequestId = input.MergeRequestId
+	r.record.SummaryNote = renderMergeRequestSummary(input)
+	return nil
+}
+
+func (r *dryRunCodeHostRepository) CreateMergeRequestDiscussion(ctx context.Context, input CreateMergeRequestDiscussionInput) error {
+	r.record.mutex.Lock()
+	defer r.record.mutex.Unlock()
+	r.record.Discussions = append(r.record.Discussions, input)
+	return nil
+}
+
+func (r *dryRunCodeHostRepository) CreateMergeRequestDiscussions(ctx context.Context, inputs []CreateMergeRequestDiscussionInput) error {
+	r.record.mutex.Lock()
+	defer r.record.mutex.Unlock()
+	r.record.Discussions = append(r.record.Discussions, inputs...)
+	return nil
+}
+
+func (r *dryRunCodeHostRepository) UpdateMergeRequestNote(ctx context.Context, projectId, mergeRequestId int32, noteId int64, body string) error {
+	return nil
+}
+
+type dryRunLLMRepository struct {
+	llmRepository LLMRepository
+	record        *DryRunRecord
+}
+
+// NewDryRunLLMRepository call the given LLMRepository, and record the prompts along with the responses and the token usage
+func NewDryRunLLMRepository(llmRepository LLMRepository, record *DryRunRecord) LLMRepository {
+	return &dryRunLLMRepository{
+		llmRepository: llmRepository,
+		record:        record,
+	}
+}
+
+func (r *dryRunLLMRepository) SummarizeRelativeChanges(ctx context.Context, input SummarizeRelativeChangesInput) (SummarizeRelativeChangesOutput, error) {
+	output, err := r.llmRepository.SummarizeRelativeChanges(ctx, input)
+	if err != nil {
+		return output, err
+	}
+	r.record.addCompletion(DryRunCompletion{Stage: stageSummarizeRelativeChanges, Model: input.Model, Messages: slices.Clone(input.MessageContext), Response: output.Messages, Usage: output.Usage})
+	return output, nil
+}
+
+func (r *dryRunLLMRepository) SummarizeReleaseNote(ctx context.Context, input SummarizeReleaseNoteInput) (SummarizeReleaseNoteOutput, error) {
+	output, err := r.llmRepository.SummarizeReleaseNote(ctx, input)
+	if err != nil {
+		return output, err
+	}
+	r.record.addCompletion(DryRunCompletion{Stage: stageSummarizeReleaseNote, Model: input.Model, Messages: slices.Clone(input.MessageContext), Response: output.Messages, Usage: output.Usage})
+	return output, nil
+}
+
+func (r *dryRunLLMRepository) ReviewRelativeChanges(ctx context.Context, input ReviewRelativeChangesInput) (ReviewRelativeChangesOutput, error) {
+	output, err := r.llmRepository.ReviewRelativeChanges(ctx, input)
+	if err != nil {
+		return output, err
+	}
+	r.record.addCompletion(DryRunCompletion{Stage: stageReviewRelativeChanges, Model: input.Model, Messages: slices.Clone(input.MessageContext), Response: output.Messages, Usage: output.Usage})
+	return output, nil
+}
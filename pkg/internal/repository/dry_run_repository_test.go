@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"gitlab-mr-reviewer/pkg/logging"
+	"net/http"
+	"net/http/httptest"
+)
+
+// mockLLMRepository answers every completion with the same content
+type mockLLMRepository struct {
+	content string
+}
+
+func (r *mockLLMRepository) SummarizeRelativeChanges(ctx context.Context, input SummarizeRelativeChangesInput) (SummarizeRelativeChangesOutput, error) {
+	return SummarizeRelativeChangesOutput{Messages: []domain.Message{domain.NewAssistantMessage(r.content)}, Usage: Usage{InputTokens: 10, OutputTokens: 2}}, nil
+}
+
+func (r *mockLLMRepository) SummarizeReleaseNote(ctx context.Context, input SummarizeReleaseNoteInput) (SummarizeReleaseNoteOutput, error) {
+	return SummarizeReleaseNoteOutput{Messages: []domain.Message{domain.NewAssistantMessage(r.content)}, Usage: Usage{InputTokens: 20, OutputTokens: 3}}, nil
+}
+
+func (r *mockLLMRepository) ReviewRelativeChanges(ctx context.Context, input ReviewRelativeChangesInput) (ReviewRelativeChangesOutput, error) {
+	return ReviewRelativeChangesOutput{Messages: []domain.Message{domain.NewAssistantMessage(r.content)}, Usage: Usage{InputTokens: 30, OutputTokens: 4}}, nil
+}
+
+var _ = ginkgo.Describe("DryRun Repository", ginkgo.Ordered, func() {
+	var testServer *httptest.Server
+	var store *mockGitlabStore
+	var record *DryRunRecord
+	var gitlabRepository CodeHostRepository
+	var llmRepository LLMRepository
+	authorization := "fake-token"
+
+	ginkgo.BeforeAll(func() {
+		logger, err := logging.NewZaprLogger(false, "info")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		store = &mockGitlabStore{}
+		testServer = runMockGitlabServer(logger, authorization, store)
+		record = &DryRunRecord{}
+		gitlabRepository = NewDryRunCodeHostRepository(NewGitlabRepository(logger, &http.Client{}, testServer.URL, authorization, 2), record)
+		llmRepository = NewDryRunLLMRepository(&mockLLMRepository{content: "```go\nfmt.Println()\n```"}, record)
+	})
+
+	ginkgo.AfterAll(func() {
+		testServer.Close()
+	})
+
+	ginkgo.It("Should still read from Gitlab", func() {
+		mergeRequest, err := gitlabRepository.GetMergeRequest(context.Background(), 1, 1)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(mergeRequest.Id).To(gomega.Equal(int32(1)))
+	})
+
+	ginkgo.It("Should record the summary and the discussions instead of posting them", func() {
+		ctx := context.Background()
+		err := gitlabRepository.CreateMergeRequestSummary(ctx, CreateMergeRequestSummaryInput{
+			ProjectId:          1,
+			MergeRequestId:     2,
+			RelativeChangeNote: "relative change note",
+			SummaryNote:        "summary note",
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		err = gitlabRepository.CreateMergeRequestDiscussion(ctx, CreateMergeRequestDiscussionInput{
+			ProjectId:      1,
+			MergeRequestId: 2,
+			Body:           "comment",
+			Position:       PositionDto{NewPath: "main.go", NewLine: 3},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(gitlabRepository.UpdateMergeRequestNote(ctx, 1, 2, 1, "note")).To(gomega.Succeed())
+
+		gomega.Expect(store.listNotes()).To(gomega.BeEmpty())
+		gomega.Expect(store.updateCount).To(gomega.BeZero())
+		gomega.Expect(record.MergeRequestId).To(gomega.Equal(int32(2)))
+		gomega.Expect(record.SummaryNote).To(gomega.ContainSubstring("summary note"))
+		gomega.Expect(record.SummaryNote).To(gomega.ContainSubstring("relative change note"))
+		gomega.Expect(record.Discussions).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("Should record the prompts and sum up the token usage", func() {
+		ctx := context.Background()
+		messageContext := []domain.Message{domain.NewUserMessage("Summarize the changes.")}
+		_, err := llmRepository.SummarizeRelativeChanges(ctx, SummarizeRelativeChangesInput{CompletionInput: CompletionInput{MessageContext: messageContext, Model: "gpt-4o"}})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		_, err = llmRepository.ReviewRelativeChanges(ctx, ReviewRelativeChangesInput{CompletionInput: CompletionInput{MessageContext: messageContext, Model: "gpt-4o"}})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		gomega.Expect(record.Completions).To(gomega.HaveLen(2))
+		gomega.Expect(record.Completions[0].Stage).To(gomega.Equal(stageSummarizeRelativeChanges))
+		gomega.Expect(record.Completions[1].Stage).To(gomega.Equal(stageReviewRelativeChanges))
+		gomega.Expect(record.Completions[1].Messages).To(gomega.Equal(messageContext))
+		gomega.Expect(record.Usage).To(gomega.Equal(Usage{InputTokens: 40, OutputTokens: 6}))
+	})
+
+	ginkgo.It("Should render the record as markdown", func() {
+		markdown := record.Markdown()
+		gomega.Expect(markdown).To(gomega.ContainSubstring("# Dry run of merge request !2 of project 1"))
+		gomega.Expect(markdown).To(gomega.ContainSubstring("### `main.go` L3\n\ncomment"))
+		gomega.Expect(markdown).To(gomega.ContainSubstring("````\n```go\nfmt.Println()\n```\n````"))
+		gomega.Expect(markdown).To(gomega.ContainSubstring("| Total | | 40 | 6 |"))
+	})
+
+	ginkgo.It("Should render the record as json", func() {
+		content, err := record.JSON()
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		var decoded DryRunRecord
+		gomega.Expect(json.Unmarshal(content, &decoded)).To(gomega.Succeed())
+		gomega.Expect(decoded.SummaryNote).To(gomega.Equal(record.SummaryNote))
+		gomega.Expect(decoded.Completions).To(gomega.HaveLen(2))
+		gomega.Expect(decoded.Usage).To(gomega.Equal(record.Usage))
+	})
+})
@@ -0,0 +1,609 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	giteaReviewEventComment = "COMMENT"
+	giteaCommitsPerPage     = 50
+	// giteaDiffContextLines are the unchanged lines around the changes in the diffs of the compared files, the same as git diff
+	giteaDiffContextLines = 3
+	// giteaMaxDiffCells caps the table of the longest common subsequence of the changed lines, which are replaced as a whole beyond it
+	giteaMaxDiffCells = 4 << 20
+)
+
+var ErrorCompareNotSupported = errors.New("Gitea before 1.22 doesn't support comparing the commits")
+
+// giteaRepositoryDto is the repository of the Gitea API, see https://gitea.com/api/swagger#/repository/repoGetByID
+type giteaRepositoryDto struct {
+	FullName string `json:"full_name"`
+}
+type giteaPullRequestDto struct {
+	Number    int32           `json:"number"`
+	Title     string          `json:"title"`
+	Body      string          `json:"body"`
+	User      giteaUserDto    `json:"user"`
+	Labels    []giteaLabelDto `json:"labels"`
+	Base      giteaBranchDto  `json:"base"`
+	Head      giteaBranchDto  `json:"head"`
+	MergeBase string          `json:"merge_base"`
+}
+type giteaBranchDto struct {
+	Sha string `json:"sha"`
+}
+type giteaUserDto struct {
+	Login string `json:"login"`
+}
+type giteaLabelDto struct {
+	Name string `json:"name"`
+}
+type giteaCommitDto struct {
+	Sha    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+	} `json:"commit"`
+	// Files are only listed by the compare endpoint
+	Files []giteaCommitFileDto `json:"files"`
+}
+type giteaCommitFileDto struct {
+	Filename string `json:"filename"`
+}
+
+// giteaCompareDto lists the commits and their files, but not their patches, see https://gitea.com/api/swagger#/repository/repoCompareDiff
+type giteaCompareDto struct {
+	Commits []giteaCommitDto `json:"commits"`
+}
+type giteaCommentDto struct {
+	Id   int64        `json:"id"`
+	Body string       `json:"body"`
+	User giteaUserDto `json:"user"`
+}
+
+// giteaReviewDto is a review with the comments of the diff, see https://gitea.com/api/swagger#/repository/repoCreatePullReview
+type giteaReviewDto struct {
+	Body     string                  `json:"body"`
+	CommitId string                  `json:"commit_id"`
+	Event    string                  `json:"event"`
+	Comments []giteaReviewCommentDto `json:"comments"`
+}
+
+// giteaReviewCommentDto anchors the comment to the line of the new file, or the line of the old file if NewPosition is 0,
+// the path is the one of the new file for both
+type giteaReviewCommentDto struct {
+	Path        string `json:"path"`
+	Body        string `json:"body"`
+	NewPosition int32  `json:"new_position"`
+	OldPosition int32  `json:"old_position"`
+}
+
+type giteaRepository struct {
+	logger      *logging.ZaprLogger
+	httpClient  *http.Client
+	baseUrl     string
+	token       string
+	currentUser currentUser
+
+	mutex sync.Mutex
+	// fullNames caches the owner/name of the repositories by id, since the pull request API only addresses the repository by its full name
+	fullNames map[int32]string
+}
+
+// NewGiteaRepository review the pull requests of Gitea or Forgejo, the projectId is the id of the repository
+// and the mergeRequestId is the index of the pull request
+func NewGiteaRepository(logger *logging.ZaprLogger, httpClient *http.Client, baseUrl string, token string) CodeHostRepository {
+	return &giteaRepository{
+		logger:     logger,
+		httpClient: httpClient,
+		baseUrl:    strings.TrimSuffix(baseUrl, "/"),
+		token:      token,
+		fullNames:  map[int32]string{},
+	}
+}
+
+func (r *giteaRepository) GetMergeRequest(ctx context.Context, projectId, mergeRequestId int32) (*MergeRequestDto, error) {
+	pullRequestUrl, err := r.pullRequestUrl(ctx, projectId, mergeRequestId)
+	if err != nil {
+		return nil, err
+	}
+	var pullRequest giteaPullRequestDto
+	if _, err := r.send(ctx, http.MethodGet, pullRequestUrl, nil, http.StatusOK, &pullRequest); err != nil {
+		return nil, err
+	}
+
+	baseSha := pullRequest.MergeBase
+	if len(baseSha) == 0 {
+		baseSha = pullRequest.Base.Sha
+	}
+	return &MergeRequestDto{
+		ProjectId:   projectId,
+		Id:          pullRequest.Number,
+		Title:       pullRequest.Title,
+		Description: pullRequest.Body,
+		Author:      AuthorDto{Username: pullRequest.User.Login},
+		Labels:      toGiteaLabelNames(pullRequest.Labels),
+		DiffRefs: DiffRefsDto{
+			BaseSha:  baseSha,
+			StartSha: baseSha,
+			HeadSha:  pullRequest.Head.Sha,
+		},
+	}, nil
+}
+
+// ListDiffByMergeRequestId list the diffs parsed from the unified diff of the pull request, since the files of Gitea have no patch
+func (r *giteaRepository) ListDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error) {
+	pullRequestUrl, err := r.pullRequestUrl(ctx, projectId, mergeRequestId)
+	if err != nil {
+		return nil, err
+	}
+	bodyBytes, err := r.send(ctx, http.MethodGet, pullRequestUrl+".diff", nil, http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseRawDiff(string(bodyBytes)), nil
+}
+
+// ListMergeRequestCommits list the commits of every page, until a page isn't full
+func (r *giteaRepository) ListMergeRequestCommits(ctx context.Context, projectId, mergeRequestId int32) ([]CommitDto, error) {
+	pullRequestUrl, err := r.pullRequestUrl(ctx, projectId, mergeRequestId)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []CommitDto
+	for page := 1; ; page++ {
+		var pageCommits []giteaCommitDto
+		pageUrl := fmt.Sprintf("%s/commits?page=%d&limit=%d&stat=false&files=false", pullRequestUrl, page, giteaCommitsPerPage)
+		if _, err := r.send(ctx, http.MethodGet, pageUrl, nil, http.StatusOK, &pageCommits); err != nil {
+			return nil, err
+		}
+		for _, commit := range pageCommits {
+			title, _, _ := strings.Cut(commit.Commit.Message, "\n")
+			commits = append(commits, CommitDto{Id: commit.Sha, ShortId: shortSha(commit.Sha), Title: title, Message: commit.Commit.Message})
+		}
+		if len(pageCommits) < giteaCommitsPerPage {
+			return commits, nil
+		}
+	}
+}
+
+func (r *giteaRepository) ListRawDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error) {
+	return r.ListDiffByMergeRequestId(ctx, projectId, mergeRequestId)
+}
+
+// CompareCommits list the commits between the merge base of from and to, and to, and diff the files they change between from and to,
+// since Gitea doesn't return the patches, so from is expected to be an ancestor of to such as the merge base or the reviewed head sha.
+// Gitea before 1.22 responds 404 without the compare endpoint, and the incremental review falls back to the whole pull request
+func (r *giteaRepository) CompareCommits(ctx context.Context, projectId int32, from, to string) (*CompareDto, error) {
+	compare, err := r.compare(ctx, projectId, from, to, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, commit := range compare.Commits {
+		for _, file := range commit.Files {
+			if !slices.Contains(paths, file.Filename) {
+				paths = append(paths, file.Filename)
+			}
+		}
+	}
+	var diffs []DiffDto
+	for _, path := range paths {
+		diff, ok, err := r.diffFile(ctx, projectId, path, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			diffs = append(diffs, diff)
+		}
+	}
+	return &CompareDto{Commits: toGiteaCommitDtos(compare.Commits), Diffs: diffs, CompareSameRef: from == to}, nil
+}
+
+// ListComparedCommits list the commits of CompareCommits without the files, so that none of them is fetched to be diffed
+func (r *giteaRepository) ListComparedCommits(ctx context.Context, projectId int32, from, to string) ([]CommitDto, error) {
+	compare, err := r.compare(ctx, projectId, from, to, false)
+	if err != nil {
+		return nil, err
+	}
+	return toGiteaCommitDtos(compare.Commits), nil
+}
+
+// compare the commits, along with the files they change if files is true, see https://gitea.com/api/swagger#/repository/repoCompareDiff
+func (r *giteaRepository) compare(ctx context.Context, projectId int32, from, to string, files bool) (*giteaCompareDto, error) {
+	repositoryUrl, err := r.repositoryUrl(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+	var compare giteaCompareDto
+	compareUrl := fmt.Sprintf("%s/compare/%s...%s?files=%t&verification=false", repositoryUrl, url.PathEscape(from), url.PathEscape(to), files)
+	if _, err := r.send(ctx, http.MethodGet, compareUrl, nil, http.StatusOK, &compare); err != nil {
+		if errors.Is(err, retry.ErrorNotFound) {
+			return nil, errors.Wrap(ErrorCompareNotSupported, err.Error())
+		}
+		return nil, err
+	}
+	return &compare, nil
+}
+
+// diffFile diff the file between the refs, it isn't ok if the file is the same at both refs,
+// the diff of a binary file is empty, the same as the unified diff which git doesn't print the binary files in
+func (r *giteaRepository) diffFile(ctx context.Context, projectId int32, path, from, to string) (DiffDto, bool, error) {
+	oldContent, err := r.GetFile(ctx, projectId, path, from)
+	if err != nil && !errors.Is(err, retry.ErrorNotFound) {
+		return DiffDto{}, false, err
+	}
+	newFile := err != nil
+	newContent, err := r.GetFile(ctx, projectId, path, to)
+	if err != nil && !errors.Is(err, retry.ErrorNotFound) {
+		return DiffDto{}, false, err
+	}
+	deletedFile := err != nil
+	if (newFile && deletedFile) || (!newFile && !deletedFile && bytes.Equal(oldContent, newContent)) {
+		return DiffDto{}, false, nil
+	}
+
+	diff := DiffDto{OldPath: path, NewPath: path, NewFile: newFile, DeletedFile: deletedFile}
+	if bytes.IndexByte(oldContent, 0) < 0 && bytes.IndexByte(newContent, 0) < 0 {
+		diff.Diff = unifiedDiff(string(oldContent), string(newContent), giteaDiffContextLines)
+	}
+	return diff, true, nil
+}
+
+// GetFile get the raw file at the ref, see https://gitea.com/api/swagger#/repository/repoGetRawFile
+func (r *giteaRepository) GetFile(ctx context.Context, projectId int32, path, ref string) ([]byte, error) {
+	repositoryUrl, err := r.repositoryUrl(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+	return r.send(ctx, http.MethodGet, fmt.Sprintf("%s/raw/%s?ref=%s", repositoryUrl, escapeFilePath(path), url.QueryEscape(ref)), nil, http.StatusOK, nil)
+}
+
+// CreateMergeRequestSummary create the summary comment, or update the one posted by the previous review
+func (r *giteaRepository) CreateMergeRequestSummary(ctx context.Context, input CreateMergeRequestSummaryInput) error {
+	note := renderMergeRequestSummary(input)
+	r.logger.Debug(fmt.Sprintf("generated note: %s", note))
+
+	existingNote, err := findSummaryNote(ctx, r, input.ProjectId, input.MergeRequestId)
+	if err != nil {
+		return err
+	}
+	if existingNote == nil {
+		repositoryUrl, err := r.repositoryUrl(ctx, input.ProjectId)
+		if err != nil {
+			return err
+		}
+		commentsUrl := fmt.Sprintf("%s/issues/%d/comments", repositoryUrl, input.MergeRequestId)
+		_, err = r.send(ctx, http.MethodPost, commentsUrl, map[string]string{"body": note}, http.StatusCreated, nil)
+		return errors.Wrap(err, "Failed to create note")
+	}
+	if existingNote.Body == note {
+		r.logger.Info(fmt.Sprintf("Summary note %d is up to date", existingNote.Id))
+		return nil
+	}
+	return r.UpdateMergeRequestNote(ctx, input.ProjectId, input.MergeRequestId, existingNote.Id, note)
+}
+
+// CreateMergeRequestDiscussion create a review of a single comment, since Gitea only creates the comments of the diff within a review
+func (r *giteaRepository) CreateMergeRequestDiscussion(ctx context.Context, input CreateMergeRequestDiscussionInput) error {
+	// the single comments are posted after the review of all the comments is rejected, it isn't worth waiting for many attempts of each
+	return r.createReview(retry.WithMaxAttempts(ctx, 2), []CreateMergeRequestDiscussionInput{input})
+}
+
+// CreateMergeRequestDiscussions create a single review of all the comments, so that the pull request gets one notification,
+// Gitea rejects the whole review if any comment is rejected, then the comments are posted in a review each
+func (r *giteaRepository) CreateMergeRequestDiscussions(ctx context.Context, inputs []CreateMergeRequestDiscussionInput) error {
+	if len(inputs) == 0 {
+		return nil
+	}
+	err := r.createReview(ctx, inputs)
+	if err == nil || len(inputs) == 1 {
+		return err
+	}
+	r.logger.WithError(err).Warn(fmt.Sprintf("Failed to create the review of %d comments, create them one by one", len(inputs)))
+	return createMergeRequestDiscussions(ctx, r, r.logger, inputs)
+}
+
+// createReview create a review of the comments on the lines of the new file, or the removed lines of the old file
+func (r *giteaRepository) createReview(ctx context.Context, inputs []CreateMergeRequestDiscussionInput) error {
+	pullRequestUrl, err := r.pullRequestUrl(ctx, inputs[0].ProjectId, inputs[0].MergeRequestId)
+	if err != nil {
+		return err
+	}
+	review := giteaReviewDto{
+		CommitId: inputs[0].Position.HeadSha,
+		Event:    giteaReviewEventComment,
+	}
+	for _, input := range inputs {
+		comment := giteaReviewCommentDto{
+			Path:        input.Position.NewPath,
+			Body:        input.Body,
+			NewPosition: input.Position.NewLine,
+		}
+		if input.Position.NewLine == 0 {
+			comment.OldPosition = input.Position.OldLine
+		}
+		review.Comments = append(review.Comments, comment)
+	}
+
+	_, err = r.send(ctx, http.MethodPost, pullRequestUrl+"/reviews", review, http.StatusOK, nil)
+	return errors.Wrap(err, "Failed to create discussion")
+}
+
+// ListMergeRequestNotes list the comments of the pull request, which Gitea returns in a single response
+func (r *giteaRepository) ListMergeRequestNotes(ctx context.Context, projectId, mergeRequestId int32) ([]NoteDto, error) {
+	repositoryUrl, err := r.repositoryUrl(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+	var comments []giteaCommentDto
+	if _, err := r.send(ctx, http.MethodGet, fmt.Sprintf("%s/issues/%d/comments", repositoryUrl, mergeRequestId), nil, http.StatusOK, &comments); err != nil {
+		return nil, err
+	}
+	notes := make([]NoteDto, len(comments))
+	for i, comment := range comments {
+		notes[i] = NoteDto{Id: comment.Id, Body: comment.Body, Author: AuthorDto{Username: comment.User.Login}}
+	}
+	return notes, nil
+}
+
+// currentUsername get the user of the token, see https://gitea.com/api/swagger#/user/userGetCurrent
+func (r *giteaRepository) currentUsername(ctx context.Context) (string, error) {
+	return r.currentUser.get(func() (string, error) {
+		var user giteaUserDto
+		if _, err := r.send(ctx, http.MethodGet, r.baseUrl+"/api/v1/user", nil, http.StatusOK, &user); err != nil {
+			return "", err
+		}
+		return user.Login, nil
+	})
+}
+
+func (r *giteaRepository) UpdateMergeRequestNote(ctx context.Context, projectId, mergeRequestId int32, noteId int64, body string) error {
+	repositoryUrl, err := r.repositoryUrl(ctx, projectId)
+	if err != nil {
+		return err
+	}
+	commentUrl := fmt.Sprintf("%s/issues/comments/%d", repositoryUrl, noteId)
+	_, err = r.send(ctx, http.MethodPatch, commentUrl, map[string]string{"body": body}, http.StatusOK, nil)
+	return errors.Wrap(err, "Failed to update note")
+}
+
+func (r *giteaRepository) GetReviewedHeadSha(ctx context.Context, projectId, mergeRequestId int32) (string, error) {
+	return getReviewedHeadSha(ctx, r, projectId, mergeRequestId)
+}
+
+// repositoryUrl look up the full name of the repository by its id, so that the projectId is numeric for every code host
+func (r *giteaRepository) repositoryUrl(ctx context.Context, projectId int32) (string, error) {
+	r.mutex.Lock()
+	fullName, ok := r.fullNames[projectId]
+	r.mutex.Unlock()
+	if !ok {
+		var repository giteaRepositoryDto
+		if _, err := r.send(ctx, http.MethodGet, fmt.Sprintf("%s/api/v1/repositories/%d", r.baseUrl, projectId), nil, http.StatusOK, &repository); err != nil {
+			return "", errors.Wrap(err, "Failed to get repository")
+		}
+		fullName = repository.FullName
+		r.mutex.Lock()
+		r.fullNames[projectId] = fullName
+		r.mutex.Unlock()
+	}
+	return fmt.Sprintf("%s/api/v1/repos/%s", r.baseUrl, fullName), nil
+}
+
+func (r *giteaRepository) pullRequestUrl(ctx context.Context, projectId, mergeRequestId int32) (string, error) {
+	repositoryUrl, err := r.repositoryUrl(ctx, projectId)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/pulls/%d", repositoryUrl, mergeRequestId), nil
+}
+
+// unifiedDiff render the hunks of the line diff from the old content to the new one, with the context lines around the changes
+func unifiedDiff(oldContent, newContent string, contextLines int) string {
+	oldLines, newLines := splitDiffLines(oldContent), splitDiffLines(newContent)
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix && oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	var lines []domain.DiffLine
+	var oldLine, newLine int32 = 1, 1
+	appendLine := func(lineType domain.LineType, content string) {
+		line := domain.DiffLine{Type: lineType, Content: content}
+		if lineType != domain.LineAdded {
+			line.OldLine = oldLine
+			oldLine++
+		}
+		if lineType != domain.LineRemoved {
+			line.NewLine = newLine
+			newLine++
+		}
+		lines = append(lines, line)
+	}
+	for _, line := range oldLines[:prefix] {
+		appendLine(domain.LineContext, line)
+	}
+	removed, added := oldLines[prefix:len(oldLines)-suffix], newLines[prefix:len(newLines)-suffix]
+	if len(removed)*len(added) > giteaMaxDiffCells {
+		for _, line := range removed {
+			appendLine(domain.LineRemoved, line)
+		}
+		for _, line := range added {
+			appendLine(domain.LineAdded, line)
+		}
+	} else {
+		// lengths[i][j] is the length of the longest common subsequence of removed[i:] and added[j:]
+		lengths := make([][]int32, len(removed)+1)
+		for i := range lengths {
+			lengths[i] = make([]int32, len(added)+1)
+		}
+		for i := len(removed) - 1; i >= 0; i-- {
+			for j := len(added) - 1; j >= 0; j-- {
+				if removed[i] == added[j] {
+					lengths[i][j] = lengths[i+1][j+1] + 1
+				} else {
+					lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(removed) || j < len(added) {
+			switch {
+			case i < len(removed) && j < len(added) && removed[i] == added[j]:
+				appendLine(domain.LineContext, removed[i])
+				i, j = i+1, j+1
+			case j == len(added) || (i < len(removed) && lengths[i+1][j] >= lengths[i][j+1]):
+				appendLine(domain.LineRemoved, removed[i])
+				i++
+			default:
+				appendLine(domain.LineAdded, added[j])
+				j++
+			}
+		}
+	}
+	for _, line := range oldLines[len(oldLines)-suffix:] {
+		appendLine(domain.LineContext, line)
+	}
+
+	builder := strings.Builder{}
+	for start := 0; start < len(lines); start++ {
+		if lines[start].Type == domain.LineContext {
+			continue
+		}
+		// the hunk spans the changes which are within twice the context lines from each other
+		end := start
+		for {
+			for end < len(lines) && lines[end].Type != domain.LineContext {
+				end++
+			}
+			next := end
+			for next < len(lines) && lines[next].Type == domain.LineContext {
+				next++
+			}
+			if next == len(lines) || next-end > 2*contextLines {
+				break
+			}
+			end = next
+		}
+		start, end = max(start-contextLines, 0), min(end+contextLines, len(lines))
+		builder.WriteString(newDiffHunk(lines[:start], lines[start:end]).String())
+		start = end - 1
+	}
+	return builder.String()
+}
+
+// newDiffHunk return the hunk of the lines, which starts after the preceding lines
+func newDiffHunk(preceding []domain.DiffLine, lines []domain.DiffLine) domain.Hunk {
+	var hunk domain.Hunk
+	for _, line := range preceding {
+		if line.Type != domain.LineAdded {
+			hunk.OldStart++
+		}
+		if line.Type != domain.LineRemoved {
+			hunk.NewStart++
+		}
+	}
+	for _, line := range lines {
+		if line.Type != domain.LineAdded {
+			hunk.OldLines++
+		}
+		if line.Type != domain.LineRemoved {
+			hunk.NewLines++
+		}
+	}
+	// the start is the line before the hunk if the hunk has no line of the file, e.g. -0,0 of a new file
+	if hunk.OldLines > 0 {
+		hunk.OldStart++
+	}
+	if hunk.NewLines > 0 {
+		hunk.NewStart++
+	}
+	hunk.Lines = lines
+	return hunk
+}
+
+// splitDiffLines split the content into the lines without the line breaks
+func splitDiffLines(content string) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+func toGiteaCommitDtos(commits []giteaCommitDto) []CommitDto {
+	dtos := make([]CommitDto, len(commits))
+	for i, commit := range commits {
+		title, _, _ := strings.Cut(commit.Commit.Message, "\n")
+		dtos[i] = CommitDto{Id: commit.Sha, ShortId: shortSha(commit.Sha), Title: title, Message: commit.Commit.Message}
+	}
+	return dtos
+}
+
+func toGiteaLabelNames(labels []giteaLabelDto) []string {
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = label.Name
+	}
+	return names
+}
+
+// send the request to the Gitea API and return the response body, which is decoded into the response if it is given
+func (r *giteaRepository) send(ctx context.Context, method, url string, requestBody any, expectedStatus int, response any) ([]byte, error) {
+	var body io.Reader
+	if requestBody != nil {
+		requestBodyByte, err := json.Marshal(requestBody)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(requestBodyByte)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Authorization", "token "+r.token)
+	if requestBody != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expectedStatus {
+		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
+		return nil, retry.NewStatusError(resp.StatusCode, string(bodyBytes))
+	}
+
+	if response != nil {
+		if err := json.Unmarshal(bodyBytes, response); err != nil {
+			return nil, err
+		}
+	}
+	return bodyBytes, nil
+}
@@ -0,0 +1,374 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mockGiteaStore keeps the comments and the reviews of the single pull request served by the mock Gitea server
+type mockGiteaStore struct {
+	mutex           sync.Mutex
+	comments        []giteaCommentDto
+	reviews         []giteaReviewDto
+	repositoryCount int
+	updateCount     int
+	rawCount        int
+}
+
+func runMockGiteaServer(token string, store *mockGiteaStore) *httptest.Server {
+	serveMux := http.NewServeMux()
+	var handler http.Handler = serveMux
+	authHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"user does not exist"}`))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	serveMux.HandleFunc("GET /api/v1/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"login": "reviewer-bot"}`))
+	})
+	serveMux.HandleFunc("GET /api/v1/repositories/1", func(w http.ResponseWriter, r *http.Request) {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		store.repositoryCount++
+		w.Write([]byte(`{"id": 1, "full_name": "team/service"}`))
+	})
+	serveMux.HandleFunc("GET /api/v1/repos/team/service/pulls/{index}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("index") {
+		case "2":
+			w.Write([]byte(`{"number": 2, "title": "Add fmt", "body": "Import fmt.", "user": {"login": "alice"}, "labels": [{"name": "enhancement"}], "base": {"sha": "base-sha"}, "head": {"sha": "head-sha"}, "merge_base": "merge-base-sha"}`))
+		case "2.diff":
+			w.Write([]byte("diff --git a/main.go b/main.go\nindex 1..2 100644\n--- a/main.go\n+++ b/main.go\n@@ -1,3 +1,4 @@\n package main\n \n+import \"fmt\"\n func main() {}\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	serveMux.HandleFunc("GET /api/v1/repos/team/service/pulls/2/commits", func(w http.ResponseWriter, r *http.Request) {
+		// the second page is empty, since the first page is not full
+		if r.URL.Query().Get("page") != "1" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`[{"sha": "head-sha-0123456789", "commit": {"message": "Add fmt\n\nImport fmt."}}]`))
+	})
+	serveMux.HandleFunc("GET /api/v1/repos/team/service/compare/{basehead}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("basehead") != "reviewed-sha...head-sha" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"total_commits": 2, "commits": [` +
+			`{"sha": "second-sha-0123456789", "commit": {"message": "Print hello"}, "files": [{"filename": "main.go"}, {"filename": "tmp.txt"}]},` +
+			`{"sha": "head-sha-0123456789", "commit": {"message": "Add docs\n\nAdd README."}, "files": [{"filename": "README.md"}, {"filename": "tmp.txt"}, {"filename": "old.txt"}]}]}`))
+	})
+	serveMux.HandleFunc("GET /api/v1/repos/team/service/raw/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		store.mutex.Lock()
+		store.rawCount++
+		store.mutex.Unlock()
+		files := map[string]string{
+			"docs/.gitlab-mr-reviewer.yml@head-sha": "Language: Japanese\n",
+			"main.go@reviewed-sha":                  "package main\n\nfunc main() {\n}\n",
+			"main.go@head-sha":                      "package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n",
+			"README.md@head-sha":                    "# Service\n",
+			"old.txt@reviewed-sha":                  "old\n",
+		}
+		content, ok := files[r.PathValue("path")+"@"+r.URL.Query().Get("ref")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(content))
+	})
+	serveMux.HandleFunc("GET /api/v1/repos/team/service/issues/2/comments", func(w http.ResponseWriter, r *http.Request) {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		json.NewEncoder(w).Encode(store.comments)
+	})
+	serveMux.HandleFunc("POST /api/v1/repos/team/service/issues/2/comments", func(w http.ResponseWriter, r *http.Request) {
+		var comment giteaCommentDto
+		if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		comment.Id = int64(len(store.comments) + 100)
+		comment.User.Login = "reviewer-bot"
+		store.comments = append(store.comments, comment)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(comment)
+	})
+	serveMux.HandleFunc("PATCH /api/v1/repos/team/service/issues/comments/{commentId}", func(w http.ResponseWriter, r *http.Request) {
+		var comment giteaCommentDto
+		if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		commentId, _ := strconv.ParseInt(r.PathValue("commentId"), 10, 64)
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		for i := range store.comments {
+			if store.comments[i].Id == commentId {
+				store.comments[i].Body = comment.Body
+				store.updateCount++
+				json.NewEncoder(w).Encode(store.comments[i])
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	serveMux.HandleFunc("POST /api/v1/repos/team/service/pulls/2/reviews", func(w http.ResponseWriter, r *http.Request) {
+		var review giteaReviewDto
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Event != giteaReviewEventComment || len(review.Comments) == 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		// the whole review is rejected if any comment is out of the diff
+		for _, comment := range review.Comments {
+			if comment.NewPosition > 100 {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		store.reviews = append(store.reviews, review)
+		json.NewEncoder(w).Encode(map[string]any{"id": len(store.reviews)})
+	})
+
+	return httptest.NewServer(authHandler)
+}
+
+var _ = ginkgo.Describe("GiteaRepository", ginkgo.Ordered, func() {
+	var logger *logging.ZaprLogger
+	var testServer *httptest.Server
+	var store *mockGiteaStore
+	var r CodeHostRepository
+	token := "fake-token"
+
+	ginkgo.BeforeAll(func() {
+		var err error
+		logger, err = logging.NewZaprLogger(false, "info")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		store = &mockGiteaStore{}
+		testServer = runMockGiteaServer(token, store)
+		r = NewGiteaRepository(logger, &http.Client{}, testServer.URL+"/", token)
+	})
+
+	ginkgo.AfterAll(func() {
+		testServer.Close()
+	})
+
+	ginkgo.It("Should get the pull request from the merge base", func() {
+		mergeRequest, err := r.GetMergeRequest(context.Background(), 1, 2)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(*mergeRequest).To(gomega.Equal(MergeRequestDto{
+			ProjectId:   1,
+			Id:          2,
+			Title:       "Add fmt",
+			Description: "Import fmt.",
+			Author:      AuthorDto{Username: "alice"},
+			Labels:      []string{"enhancement"},
+			DiffRefs:    DiffRefsDto{BaseSha: "merge-base-sha", StartSha: "merge-base-sha", HeadSha: "head-sha"},
+		}))
+	})
+
+	ginkgo.It("Should list the diffs of the unified diff", func() {
+		diffs, err := r.ListDiffByMergeRequestId(context.Background(), 1, 2)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(diffs).To(gomega.Equal([]DiffDto{{
+			Diff:    "@@ -1,3 +1,4 @@\n package main\n \n+import \"fmt\"\n func main() {}\n",
+			NewPath: "main.go",
+			OldPath: "main.go",
+		}}))
+	})
+
+	ginkgo.It("Should look up the repository only once", func() {
+		gomega.Expect(store.repositoryCount).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("Should list the commits of the pull request", func() {
+		commits, err := r.ListMergeRequestCommits(context.Background(), 1, 2)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(commits).To(gomega.Equal([]CommitDto{{Id: "head-sha-0123456789", ShortId: "head-sha", Title: "Add fmt", Message: "Add fmt\n\nImport fmt."}}))
+	})
+
+	ginkgo.It("Should compare the commits, and diff the files they change", func() {
+		compare, err := r.CompareCommits(context.Background(), 1, "reviewed-sha", "head-sha")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(compare.Commits).To(gomega.Equal([]CommitDto{
+			{Id: "second-sha-0123456789", ShortId: "second-s", Title: "Print hello", Message: "Print hello"},
+			{Id: "head-sha-0123456789", ShortId: "head-sha", Title: "Add docs", Message: "Add docs\n\nAdd README."},
+		}))
+		gomega.Expect(compare.Diffs).To(gomega.Equal([]DiffDto{
+			{Diff: "@@ -1,4 +1,5 @@\n package main\n \n func main() {\n+\tprintln(\"hello\")\n }\n", OldPath: "main.go", NewPath: "main.go"},
+			{Diff: "@@ -0,0 +1,1 @@\n+# Service\n", OldPath: "README.md", NewPath: "README.md", NewFile: true},
+			{Diff: "@@ -1,1 +0,0 @@\n-old\n", OldPath: "old.txt", NewPath: "old.txt", DeletedFile: true},
+		}))
+	})
+
+	ginkgo.It("Should list the compared commits without fetching the files", func() {
+		store.mutex.Lock()
+		rawCount := store.rawCount
+		store.mutex.Unlock()
+
+		commits, err := r.ListComparedCommits(context.Background(), 1, "reviewed-sha", "head-sha")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(commits).To(gomega.Equal([]CommitDto{
+			{Id: "second-sha-0123456789", ShortId: "second-s", Title: "Print hello", Message: "Print hello"},
+			{Id: "head-sha-0123456789", ShortId: "head-sha", Title: "Add docs", Message: "Add docs\n\nAdd README."},
+		}))
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		gomega.Expect(store.rawCount).To(gomega.Equal(rawCount))
+	})
+
+	ginkgo.It("Should not support comparing the commits without the compare endpoint", func() {
+		_, err := r.CompareCommits(context.Background(), 1, "base-sha", "head-sha")
+		gomega.Expect(errors.Is(err, ErrorCompareNotSupported)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should diff the lines with the context lines around the changes", func() {
+		oldContent := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+		newContent := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"
+		gomega.Expect(unifiedDiff(oldContent, newContent, 1)).To(gomega.Equal(
+			"@@ -2,3 +2,3 @@\n 2\n-3\n+three\n 4\n@@ -12,1 +12,2 @@\n 12\n+13\n"))
+		gomega.Expect(unifiedDiff(oldContent, newContent, 5)).To(gomega.Equal(
+			"@@ -1,12 +1,13 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n 8\n 9\n 10\n 11\n 12\n+13\n"))
+		gomega.Expect(unifiedDiff(oldContent, oldContent, 3)).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("Should bound the hunks at the first and the last lines", func() {
+		oldContent := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+		gomega.Expect(unifiedDiff(oldContent, "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n", 1)).To(gomega.Equal(
+			"@@ -1,2 +1,2 @@\n-1\n+one\n 2\n@@ -9,2 +9,2 @@\n 9\n-10\n+ten\n"))
+		gomega.Expect(unifiedDiff("", "a\nb\n", 3)).To(gomega.Equal("@@ -0,0 +1,2 @@\n+a\n+b\n"))
+		gomega.Expect(unifiedDiff("a\nb\n", "", 3)).To(gomega.Equal("@@ -1,2 +0,0 @@\n-a\n-b\n"))
+	})
+
+	ginkgo.It("Should merge the hunks only if their context lines meet", func() {
+		oldContent := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+		ginkgo.By("the changes 2 context lines apart should be in a single hunk")
+		gomega.Expect(unifiedDiff(oldContent, "1\n2\nthree\n4\n5\nsix\n7\n8\n9\n10\n", 1)).To(gomega.Equal(
+			"@@ -2,6 +2,6 @@\n 2\n-3\n+three\n 4\n 5\n-6\n+six\n 7\n"))
+		ginkgo.By("the changes 3 context lines apart should be in separate hunks")
+		gomega.Expect(unifiedDiff(oldContent, "1\n2\nthree\n4\n5\n6\nseven\n8\n9\n10\n", 1)).To(gomega.Equal(
+			"@@ -2,3 +2,3 @@\n 2\n-3\n+three\n 4\n@@ -6,3 +6,3 @@\n 6\n-7\n+seven\n 8\n"))
+	})
+
+	ginkgo.It("Should replace the changed lines as a whole beyond the cell limit", func() {
+		var oldLines, newLines []string
+		for i := 0; i < 2100; i++ {
+			oldLines = append(oldLines, fmt.Sprintf("old %d", i))
+			newLines = append(newLines, fmt.Sprintf("new %d", i))
+		}
+		oldLines[1000], newLines[1000] = "same", "same"
+		gomega.Expect(len(oldLines) * len(newLines)).To(gomega.BeNumerically(">", giteaMaxDiffCells))
+
+		var expected strings.Builder
+		expected.WriteString("@@ -1,2100 +1,2100 @@\n")
+		for _, line := range oldLines {
+			expected.WriteString("-" + line + "\n")
+		}
+		for _, line := range newLines {
+			expected.WriteString("+" + line + "\n")
+		}
+		gomega.Expect(unifiedDiff(strings.Join(oldLines, "\n")+"\n", strings.Join(newLines, "\n")+"\n", 3)).To(gomega.Equal(expected.String()))
+	})
+
+	ginkgo.It("Should create the summary comment, and update it on the next review", func() {
+		ctx := context.Background()
+		input := CreateMergeRequestSummaryInput{ProjectId: 1, MergeRequestId: 2, RelativeChangeNote: "first", SummaryNote: "summary", HeadSha: "0123abcd"}
+		gomega.Expect(r.CreateMergeRequestSummary(ctx, input)).To(gomega.Succeed())
+		gomega.Expect(store.comments).To(gomega.HaveLen(1))
+
+		reviewedHeadSha, err := r.GetReviewedHeadSha(ctx, 1, 2)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(reviewedHeadSha).To(gomega.Equal("0123abcd"))
+
+		input.RelativeChangeNote = "second"
+		gomega.Expect(r.CreateMergeRequestSummary(ctx, input)).To(gomega.Succeed())
+		gomega.Expect(store.comments).To(gomega.HaveLen(1))
+		gomega.Expect(store.comments[0].Body).To(gomega.ContainSubstring("second"))
+		gomega.Expect(store.updateCount).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("Should create the review comment within a review", func() {
+		err := r.CreateMergeRequestDiscussion(context.Background(), CreateMergeRequestDiscussionInput{
+			ProjectId: 1, MergeRequestId: 2, Body: "added line",
+			Position: PositionDto{HeadSha: "head-sha", NewPath: "main.go", OldPath: "main.go", NewLine: 3},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(store.reviews).To(gomega.Equal([]giteaReviewDto{{
+			CommitId: "head-sha",
+			Event:    giteaReviewEventComment,
+			Comments: []giteaReviewCommentDto{{Path: "main.go", Body: "added line", NewPosition: 3}},
+		}}))
+	})
+
+	ginkgo.It("Should create a single review of all the comments", func() {
+		store.mutex.Lock()
+		store.reviews = nil
+		store.mutex.Unlock()
+
+		err := r.CreateMergeRequestDiscussions(context.Background(), []CreateMergeRequestDiscussionInput{
+			{ProjectId: 1, MergeRequestId: 2, Body: "added line", Position: PositionDto{HeadSha: "head-sha", NewPath: "main.go", OldPath: "main.go", NewLine: 3}},
+			{ProjectId: 1, MergeRequestId: 2, Body: "removed line", Position: PositionDto{HeadSha: "head-sha", NewPath: "main.go", OldPath: "main.go", OldLine: 2}},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(store.reviews).To(gomega.Equal([]giteaReviewDto{{
+			CommitId: "head-sha",
+			Event:    giteaReviewEventComment,
+			Comments: []giteaReviewCommentDto{
+				{Path: "main.go", Body: "added line", NewPosition: 3},
+				{Path: "main.go", Body: "removed line", OldPosition: 2},
+			},
+		}}))
+	})
+
+	ginkgo.It("Should create the comments one by one if the review is rejected", func() {
+		store.mutex.Lock()
+		store.reviews = nil
+		store.mutex.Unlock()
+
+		err := r.CreateMergeRequestDiscussions(context.Background(), []CreateMergeRequestDiscussionInput{
+			{ProjectId: 1, MergeRequestId: 2, Body: "added line", Position: PositionDto{HeadSha: "head-sha", NewPath: "main.go", OldPath: "main.go", NewLine: 3}},
+			{ProjectId: 1, MergeRequestId: 2, Body: "out of the diff", Position: PositionDto{HeadSha: "head-sha", NewPath: "main.go", OldPath: "main.go", NewLine: 300}},
+		})
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(store.reviews).To(gomega.Equal([]giteaReviewDto{{
+			CommitId: "head-sha",
+			Event:    giteaReviewEventComment,
+			Comments: []giteaReviewCommentDto{{Path: "main.go", Body: "added line", NewPosition: 3}},
+		}}))
+	})
+
+	ginkgo.It("Should get the raw file at the ref", func() {
+		content, err := r.GetFile(context.Background(), 1, "docs/.gitlab-mr-reviewer.yml", "head-sha")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(string(content)).To(gomega.Equal("Language: Japanese\n"))
+
+		_, err = r.GetFile(context.Background(), 1, ".gitlab-mr-reviewer.yml", "head-sha")
+		gomega.Expect(errors.Is(err, retry.ErrorNotFound)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should return typed error with invalid token", func() {
+		_, err := NewGiteaRepository(logger, &http.Client{}, testServer.URL, "wrong-token").GetMergeRequest(context.Background(), 1, 2)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(errors.Is(err, retry.ErrorUnauthorized)).To(gomega.BeTrue())
+	})
+})
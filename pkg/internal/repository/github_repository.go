@@ -0,0 +1,404 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultGithubBaseUrl = "https://api.github.com"
+	githubApiVersion     = "2022-11-28"
+	githubMaxPerPage     = 100
+
+	githubMediaTypeJSON = "application/vnd.github+json"
+	githubMediaTypeDiff = "application/vnd.github.diff"
+	githubMediaTypeRaw  = "application/vnd.github.raw+json"
+
+	githubFileStatusAdded   = "added"
+	githubFileStatusRemoved = "removed"
+	githubFileStatusRenamed = "renamed"
+
+	githubSideRight = "RIGHT"
+	githubSideLeft  = "LEFT"
+
+	// githubActionsLogin is the user of the GITHUB_TOKEN of GitHub Actions
+	githubActionsLogin = "github-actions[bot]"
+)
+
+var githubNextLinkRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// githubPullRequestDto is the pull request of the GitHub REST API, see https://docs.github.com/en/rest/pulls/pulls#get-a-pull-request
+type githubPullRequestDto struct {
+	Number int32            `json:"number"`
+	Title  string           `json:"title"`
+	Body   string           `json:"body"`
+	User   githubUserDto    `json:"user"`
+	Labels []githubLabelDto `json:"labels"`
+	Base   githubRefDto     `json:"base"`
+	Head   githubRefDto     `json:"head"`
+}
+type githubUserDto struct {
+	Login string `json:"login"`
+}
+type githubLabelDto struct {
+	Name string `json:"name"`
+}
+type githubRefDto struct {
+	Sha string `json:"sha"`
+}
+
+// githubFileDto is a changed file of the pull request, the patch is missing if the diff is too large or binary
+type githubFileDto struct {
+	Filename         string `json:"filename"`
+	PreviousFilename string `json:"previous_filename"`
+	Status           string `json:"status"`
+	Changes          int    `json:"changes"`
+	Patch            string `json:"patch"`
+}
+type githubCommitDto struct {
+	Sha    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+	} `json:"commit"`
+}
+type githubCompareDto struct {
+	Status  string            `json:"status"`
+	Commits []githubCommitDto `json:"commits"`
+	Files   []githubFileDto   `json:"files"`
+}
+type githubIssueCommentDto struct {
+	Id   int64         `json:"id"`
+	Body string        `json:"body"`
+	User githubUserDto `json:"user"`
+}
+
+// githubReviewCommentDto anchors a review comment to a line of the diff, see https://docs.github.com/en/rest/pulls/comments#create-a-review-comment-for-a-pull-request
+type githubReviewCommentDto struct {
+	Body     string `json:"body"`
+	CommitId string `json:"commit_id"`
+	Path     string `json:"path"`
+	Line     int32  `json:"line"`
+	Side     string `json:"side"`
+}
+
+type githubRepository struct {
+	logger      *logging.ZaprLogger
+	httpClient  *http.Client
+	baseUrl     string
+	token       string
+	perPage     int
+	currentUser currentUser
+}
+
+// NewGithubRepository review the pull requests of GitHub, the projectId is the numeric id of the GitHub repository
+// and the mergeRequestId is the number of the pull request
+func NewGithubRepository(logger *logging.ZaprLogger, httpClient *http.Client, baseUrl string, token string, perPage int) CodeHostRepository {
+	if len(baseUrl) == 0 {
+		baseUrl = defaultGithubBaseUrl
+	}
+	if perPage <= 0 {
+		perPage = defaultPerPage
+	}
+	return &githubRepository{
+		logger:     logger,
+		httpClient: httpClient,
+		baseUrl:    strings.TrimSuffix(baseUrl, "/"),
+		token:      token,
+		perPage:    min(perPage, githubMaxPerPage),
+	}
+}
+
+func (r *githubRepository) GetMergeRequest(ctx context.Context, projectId, mergeRequestId int32) (*MergeRequestDto, error) {
+	var pullRequest githubPullRequestDto
+	if _, err := r.send(ctx, http.MethodGet, r.pullRequestUrl(projectId, mergeRequestId), nil, githubMediaTypeJSON, http.StatusOK, &pullRequest); err != nil {
+		return nil, err
+	}
+	return &MergeRequestDto{
+		ProjectId:   projectId,
+		Id:          pullRequest.Number,
+		Title:       pullRequest.Title,
+		Description: pullRequest.Body,
+		Author:      AuthorDto{Username: pullRequest.User.Login},
+		Labels:      toGithubLabelNames(pullRequest.Labels),
+		DiffRefs: DiffRefsDto{
+			BaseSha:  pullRequest.Base.Sha,
+			StartSha: pullRequest.Base.Sha,
+			HeadSha:  pullRequest.Head.Sha,
+		},
+	}, nil
+}
+
+// ListMergeRequestCommits list the commits of every page, GitHub lists at most 250 commits of a pull request
+func (r *githubRepository) ListMergeRequestCommits(ctx context.Context, projectId, mergeRequestId int32) ([]CommitDto, error) {
+	commits, err := githubListAllPages[githubCommitDto](ctx, r, r.pullRequestUrl(projectId, mergeRequestId)+"/commits")
+	if err != nil {
+		return nil, err
+	}
+	return toGithubCommitDtos(commits), nil
+}
+
+// ListDiffByMergeRequestId list the files of every page, the diff is empty if it is too large
+func (r *githubRepository) ListDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error) {
+	files, err := githubListAllPages[githubFileDto](ctx, r, r.pullRequestUrl(projectId, mergeRequestId)+"/files")
+	if err != nil {
+		return nil, err
+	}
+	return toGithubDiffDtos(files), nil
+}
+
+// ListRawDiffByMergeRequestId list the diffs parsed from the unified diff of the pull request, which includes the patches missing from the files
+func (r *githubRepository) ListRawDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error) {
+	bodyBytes, err := r.send(ctx, http.MethodGet, r.pullRequestUrl(projectId, mergeRequestId), nil, githubMediaTypeDiff, http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseRawDiff(string(bodyBytes)), nil
+}
+
+// ListComparedCommits return the commits of the compare, which returns the diffs in the same response anyway
+func (r *githubRepository) ListComparedCommits(ctx context.Context, projectId int32, from, to string) ([]CommitDto, error) {
+	compare, err := r.CompareCommits(ctx, projectId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return compare.Commits, nil
+}
+
+// CompareCommits return the commits and diffs between the merge base of from and to, and to
+func (r *githubRepository) CompareCommits(ctx context.Context, projectId int32, from, to string) (*CompareDto, error) {
+	var compare githubCompareDto
+	compareUrl := fmt.Sprintf("%s/compare/%s...%s", r.repositoryUrl(projectId), url.PathEscape(from), url.PathEscape(to))
+	if _, err := r.send(ctx, http.MethodGet, compareUrl, nil, githubMediaTypeJSON, http.StatusOK, &compare); err != nil {
+		return nil, err
+	}
+
+	return &CompareDto{
+		Commits:        toGithubCommitDtos(compare.Commits),
+		Diffs:          toGithubDiffDtos(compare.Files),
+		CompareSameRef: compare.Status == "identical",
+	}, nil
+}
+
+// GetFile get the raw file at the ref, see https://docs.github.com/en/rest/repos/contents#get-repository-content
+func (r *githubRepository) GetFile(ctx context.Context, projectId int32, path, ref string) ([]byte, error) {
+	contentUrl := fmt.Sprintf("%s/contents/%s?ref=%s", r.repositoryUrl(projectId), escapeFilePath(path), url.QueryEscape(ref))
+	return r.send(ctx, http.MethodGet, contentUrl, nil, githubMediaTypeRaw, http.StatusOK, nil)
+}
+
+// CreateMergeRequestSummary create the summary issue comment, or update the one posted by the previous review
+func (r *githubRepository) CreateMergeRequestSummary(ctx context.Context, input CreateMergeRequestSummaryInput) error {
+	note := renderMergeRequestSummary(input)
+	r.logger.Debug(fmt.Sprintf("generated note: %s", note))
+
+	existingNote, err := findSummaryNote(ctx, r, input.ProjectId, input.MergeRequestId)
+	if err != nil {
+		return err
+	}
+	if existingNote == nil {
+		commentsUrl := fmt.Sprintf("%s/issues/%d/comments", r.repositoryUrl(input.ProjectId), input.MergeRequestId)
+		_, err := r.send(ctx, http.MethodPost, commentsUrl, map[string]string{"body": note}, githubMediaTypeJSON, http.StatusCreated, nil)
+		return errors.Wrap(err, "Failed to create note")
+	}
+	if existingNote.Body == note {
+		r.logger.Info(fmt.Sprintf("Summary note %d is up to date", existingNote.Id))
+		return nil
+	}
+	return r.UpdateMergeRequestNote(ctx, input.ProjectId, input.MergeRequestId, existingNote.Id, note)
+}
+
+// CreateMergeRequestDiscussion create a review comment on the line of the new file, or the removed line of the old file,
+// GitHub takes the path of the new file for both sides
+func (r *githubRepository) CreateMergeRequestDiscussion(ctx context.Context, input CreateMergeRequestDiscussionInput) error {
+	comment := githubReviewCommentDto{
+		Body:     input.Body,
+		CommitId: input.Position.HeadSha,
+		Path:     input.Position.NewPath,
+		Line:     input.Position.NewLine,
+		Side:     githubSideRight,
+	}
+	if input.Position.NewLine == 0 {
+		comment.Line, comment.Side = input.Position.OldLine, githubSideLeft
+	}
+
+	// a rejected comment doesn't fail the review, it isn't worth waiting for many attempts
+	commentsUrl := r.pullRequestUrl(input.ProjectId, input.MergeRequestId) + "/comments"
+	_, err := r.send(retry.WithMaxAttempts(ctx, 2), http.MethodPost, commentsUrl, comment, githubMediaTypeJSON, http.StatusCreated, nil)
+	return errors.Wrap(err, "Failed to create discussion")
+}
+
+func (r *githubRepository) CreateMergeRequestDiscussions(ctx context.Context, inputs []CreateMergeRequestDiscussionInput) error {
+	return createMergeRequestDiscussions(ctx, r, r.logger, inputs)
+}
+
+// ListMergeRequestNotes list the issue comments of the pull request, which GitHub never marks as system notes
+func (r *githubRepository) ListMergeRequestNotes(ctx context.Context, projectId, mergeRequestId int32) ([]NoteDto, error) {
+	comments, err := githubListAllPages[githubIssueCommentDto](ctx, r, fmt.Sprintf("%s/issues/%d/comments", r.repositoryUrl(projectId), mergeRequestId))
+	if err != nil {
+		return nil, err
+	}
+	notes := make([]NoteDto, len(comments))
+	for i, comment := range comments {
+		notes[i] = NoteDto{Id: comment.Id, Body: comment.Body, Author: AuthorDto{Username: comment.User.Login}}
+	}
+	return notes, nil
+}
+
+// currentUsername get the user of the token, see https://docs.github.com/en/rest/users/users#get-the-authenticated-user,
+// the GITHUB_TOKEN of GitHub Actions is forbidden to get it, and posts as githubActionsLogin
+func (r *githubRepository) currentUsername(ctx context.Context) (string, error) {
+	return r.currentUser.get(func() (string, error) {
+		var user githubUserDto
+		_, err := r.send(ctx, http.MethodGet, r.baseUrl+"/user", nil, githubMediaTypeJSON, http.StatusOK, &user)
+		if errors.Is(err, retry.ErrorForbidden) {
+			return githubActionsLogin, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return user.Login, nil
+	})
+}
+
+func (r *githubRepository) UpdateMergeRequestNote(ctx context.Context, projectId, mergeRequestId int32, noteId int64, body string) error {
+	commentUrl := fmt.Sprintf("%s/issues/comments/%d", r.repositoryUrl(projectId), noteId)
+	_, err := r.send(ctx, http.MethodPatch, commentUrl, map[string]string{"body": body}, githubMediaTypeJSON, http.StatusOK, nil)
+	return errors.Wrap(err, "Failed to update note")
+}
+
+func (r *githubRepository) GetReviewedHeadSha(ctx context.Context, projectId, mergeRequestId int32) (string, error) {
+	return getReviewedHeadSha(ctx, r, projectId, mergeRequestId)
+}
+
+// repositoryUrl address the repository by its id, so that the projectId is numeric for both Gitlab and GitHub
+func (r *githubRepository) repositoryUrl(projectId int32) string {
+	return fmt.Sprintf("%s/repositories/%d", r.baseUrl, projectId)
+}
+
+func (r *githubRepository) pullRequestUrl(projectId, mergeRequestId int32) string {
+	return fmt.Sprintf("%s/pulls/%d", r.repositoryUrl(projectId), mergeRequestId)
+}
+
+// send the request to the GitHub API and return the response body, which is decoded into the response if it is given
+func (r *githubRepository) send(ctx context.Context, method, url string, requestBody any, accept string, expectedStatus int, response any) ([]byte, error) {
+	bodyBytes, _, err := r.sendWithHeader(ctx, method, url, requestBody, accept, expectedStatus, response)
+	return bodyBytes, err
+}
+
+func (r *githubRepository) sendWithHeader(ctx context.Context, method, url string, requestBody any, accept string, expectedStatus int, response any) ([]byte, http.Header, error) {
+	var body io.Reader
+	if requestBody != nil {
+		requestBodyByte, err := json.Marshal(requestBody)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = bytes.NewReader(requestBodyByte)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	request.Header.Set("Accept", accept)
+	request.Header.Set("X-GitHub-Api-Version", githubApiVersion)
+	if len(r.token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	if requestBody != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.httpClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != expectedStatus {
+		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
+		return nil, nil, retry.NewStatusError(resp.StatusCode, string(bodyBytes))
+	}
+
+	if response != nil {
+		if err := json.Unmarshal(bodyBytes, response); err != nil {
+			return nil, nil, err
+		}
+	}
+	return bodyBytes, resp.Header, nil
+}
+
+// githubListAllPages follow the next link of the Link header to list the items of every page, see https://docs.github.com/en/rest/using-the-rest-api/using-pagination-in-the-rest-api
+func githubListAllPages[T any](ctx context.Context, r *githubRepository, baseUrl string) ([]T, error) {
+	var items []T
+
+	query := url.Values{}
+	query.Set("per_page", strconv.Itoa(r.perPage))
+	for nextUrl := fmt.Sprintf("%s?%s", baseUrl, query.Encode()); len(nextUrl) > 0; {
+		var pageItems []T
+		_, header, err := r.sendWithHeader(ctx, http.MethodGet, nextUrl, nil, githubMediaTypeJSON, http.StatusOK, &pageItems)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, pageItems...)
+
+		nextUrl = ""
+		if matches := githubNextLinkRegexp.FindStringSubmatch(header.Get("Link")); matches != nil {
+			nextUrl = matches[1]
+		}
+	}
+
+	return items, nil
+}
+
+// toGithubDiffDtos convert the files into DiffDto, the changed file without a patch is too large
+func toGithubDiffDtos(files []githubFileDto) []DiffDto {
+	diffs := make([]DiffDto, len(files))
+	for i, file := range files {
+		diff := DiffDto{
+			NewPath:     file.Filename,
+			OldPath:     file.Filename,
+			NewFile:     file.Status == githubFileStatusAdded,
+			DeletedFile: file.Status == githubFileStatusRemoved,
+			RenameFile:  file.Status == githubFileStatusRenamed,
+			TooLarge:    len(file.Patch) == 0 && file.Changes > 0,
+		}
+		if diff.RenameFile && len(file.PreviousFilename) > 0 {
+			diff.OldPath = file.PreviousFilename
+		}
+		if len(file.Patch) > 0 {
+			diff.Diff = strings.TrimSuffix(file.Patch, "\n") + "\n"
+		}
+		diffs[i] = diff
+	}
+	return diffs
+}
+
+func toGithubCommitDtos(githubCommits []githubCommitDto) []CommitDto {
+	commits := make([]CommitDto, len(githubCommits))
+	for i, commit := range githubCommits {
+		title, _, _ := strings.Cut(commit.Commit.Message, "\n")
+		commits[i] = CommitDto{Id: commit.Sha, ShortId: shortSha(commit.Sha), Title: title, Message: commit.Commit.Message}
+	}
+	return commits
+}
+
+func toGithubLabelNames(labels []githubLabelDto) []string {
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = label.Name
+	}
+	return names
+}
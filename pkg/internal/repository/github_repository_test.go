@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+)
+
+// mockGithubStore keeps the comments of the single pull request served by the mock GitHub server
+type mockGithubStore struct {
+	mutex          sync.Mutex
+	issueComments  []githubIssueCommentDto
+	reviewComments []githubReviewCommentDto
+	updateCount    int
+}
+
+func runMockGithubServer(token string, store *mockGithubStore) *httptest.Server {
+	files := []githubFileDto{
+		{Filename: "main.go", Status: "modified", Changes: 2, Patch: "@@ -1,3 +1,4 @@\n package main\n \n+import \"fmt\"\n func main() {}"},
+		{Filename: "new.go", PreviousFilename: "old.go", Status: "renamed"},
+		{Filename: "large.go", Status: "added", Changes: 50000},
+	}
+
+	serveMux := http.NewServeMux()
+	var handler http.Handler = serveMux
+	authHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token || r.Header.Get("X-GitHub-Api-Version") != githubApiVersion {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"Bad credentials"}`))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	serveMux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"login": "reviewer-bot"}`))
+	})
+	serveMux.HandleFunc("GET /repositories/1/pulls/2", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == githubMediaTypeDiff {
+			w.Write([]byte("diff --git a/large.go b/large.go\nnew file mode 100644\n--- /dev/null\n+++ b/large.go\n@@ -0,0 +1 @@\n+package main\n"))
+			return
+		}
+		w.Write([]byte(`{"number": 2, "title": "Add fmt", "body": "Import fmt.", "user": {"login": "alice"}, "labels": [{"name": "enhancement"}], "base": {"sha": "base-sha"}, "head": {"sha": "head-sha"}}`))
+	})
+	serveMux.HandleFunc("GET /repositories/1/pulls/2/files", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		page = max(page, 1)
+		start, end := min((page-1)*perPage, len(files)), min(page*perPage, len(files))
+		if end < len(files) {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?per_page=%d&page=%d>; rel="next", <http://%s%s?page=9>; rel="last"`, r.Host, r.URL.Path, perPage, page+1, r.Host, r.URL.Path))
+		}
+		json.NewEncoder(w).Encode(files[start:end])
+	})
+	serveMux.HandleFunc("GET /repositories/1/pulls/2/commits", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"sha": "base-sha-0123456789", "commit": {"message": "Add main"}}, {"sha": "head-sha-0123456789", "commit": {"message": "Add fmt\n\nImport fmt."}}]`))
+	})
+	serveMux.HandleFunc("GET /repositories/1/compare/{basehead}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("basehead") != "base-sha...head-sha" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"status": "ahead", "commits": [{"sha": "head-sha-0123456789", "commit": {"message": "Add fmt\n\nImport fmt."}}], "files": []}`))
+	})
+	serveMux.HandleFunc("GET /repositories/1/contents/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != githubMediaTypeRaw || r.PathValue("path") != "docs/.gitlab-mr-reviewer.yml" || r.URL.Query().Get("ref") != "head-sha" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+		w.Write([]byte("Language: Japanese\n"))
+	})
+	serveMux.HandleFunc("GET /repositories/1/issues/2/comments", func(w http.ResponseWriter, r *http.Request) {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		json.NewEncoder(w).Encode(store.issueComments)
+	})
+	serveMux.HandleFunc("POST /repositories/1/issues/2/comments", func(w http.ResponseWriter, r *http.Request) {
+		var comment githubIssueCommentDto
+		if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		comment.Id = int64(len(store.issueComments) + 100)
+		comment.User.Login = "reviewer-bot"
+		store.issueComments = append(store.issueComments, comment)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(comment)
+	})
+	serveMux.HandleFunc("PATCH /repositories/1/issues/comments/{commentId}", func(w http.ResponseWriter, r *http.Request) {
+		var comment githubIssueCommentDto
+		if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		commentId, _ := strconv.ParseInt(r.PathValue("commentId"), 10, 64)
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		for i := range store.issueComments {
+			if store.issueComments[i].Id == commentId {
+				store.issueComments[i].Body = comment.Body
+				store.updateCount++
+				json.NewEncoder(w).Encode(store.issueComments[i])
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	serveMux.HandleFunc("POST /repositories/1/pulls/2/comments", func(w http.ResponseWriter, r *http.Request) {
+		var comment githubReviewCommentDto
+		if err := json.NewDecoder(r.Body).Decode(&comment); err != nil || comment.Line <= 0 || len(comment.CommitId) == 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		store.reviewComments = append(store.reviewComments, comment)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(comment)
+	})
+
+	return httptest.NewServer(authHandler)
+}
+
+var _ = ginkgo.Describe("GithubRepository", ginkgo.Ordered, func() {
+	var logger *logging.ZaprLogger
+	var testServer *httptest.Server
+	var store *mockGithubStore
+	var r CodeHostRepository
+	token := "fake-token"
+
+	ginkgo.BeforeAll(func() {
+		var err error
+		logger, err = logging.NewZaprLogger(false, "info")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		store = &mockGithubStore{}
+		testServer = runMockGithubServer(token, store)
+		r = NewGithubRepository(logger, &http.Client{}, testServer.URL, token, 2)
+	})
+
+	ginkgo.AfterAll(func() {
+		testServer.Close()
+	})
+
+	ginkgo.It("Should get the pull request", func() {
+		mergeRequest, err := r.GetMergeRequest(context.Background(), 1, 2)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(*mergeRequest).To(gomega.Equal(MergeRequestDto{
+			ProjectId:   1,
+			Id:          2,
+			Title:       "Add fmt",
+			Description: "Import fmt.",
+			Author:      AuthorDto{Username: "alice"},
+			Labels:      []string{"enhancement"},
+			DiffRefs:    DiffRefsDto{BaseSha: "base-sha", StartSha: "base-sha", HeadSha: "head-sha"},
+		}))
+	})
+
+	ginkgo.It("Should list the files of every page", func() {
+		diffs, err := r.ListDiffByMergeRequestId(context.Background(), 1, 2)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(diffs).To(gomega.HaveLen(3))
+		gomega.Expect(diffs[0].Diff).To(gomega.HavePrefix("@@ -1,3 +1,4 @@\n"))
+		gomega.Expect(diffs[0].TooLarge).To(gomega.BeFalse())
+		gomega.Expect(diffs[1].RenameFile).To(gomega.BeTrue())
+		gomega.Expect(diffs[1].OldPath).To(gomega.Equal("old.go"))
+		gomega.Expect(diffs[1].TooLarge).To(gomega.BeFalse())
+		gomega.Expect(diffs[2].NewFile).To(gomega.BeTrue())
+		gomega.Expect(diffs[2].TooLarge).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should list the diffs of the unified diff", func() {
+		diffs, err := r.ListRawDiffByMergeRequestId(context.Background(), 1, 2)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(diffs).To(gomega.Equal([]DiffDto{{Diff: "@@ -0,0 +1 @@\n+package main\n", NewPath: "large.go", OldPath: "large.go", NewFile: true}}))
+	})
+
+	ginkgo.It("Should list the commits of the pull request", func() {
+		commits, err := r.ListMergeRequestCommits(context.Background(), 1, 2)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(commits).To(gomega.Equal([]CommitDto{
+			{Id: "base-sha-0123456789", ShortId: "base-sha", Title: "Add main", Message: "Add main"},
+			{Id: "head-sha-0123456789", ShortId: "head-sha", Title: "Add fmt", Message: "Add fmt\n\nImport fmt."},
+		}))
+	})
+
+	ginkgo.It("Should compare the commits", func() {
+		compare, err := r.CompareCommits(context.Background(), 1, "base-sha", "head-sha")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(compare.Commits).To(gomega.Equal([]CommitDto{{Id: "head-sha-0123456789", ShortId: "head-sha", Title: "Add fmt", Message: "Add fmt\n\nImport fmt."}}))
+	})
+
+	ginkgo.It("Should create the summary comment, and update it on the next review", func() {
+		ctx := context.Background()
+		input := CreateMergeRequestSummaryInput{ProjectId: 1, MergeRequestId: 2, RelativeChangeNote: "first", SummaryNote: "summary", HeadSha: "0123abcd"}
+		gomega.Expect(r.CreateMergeRequestSummary(ctx, input)).To(gomega.Succeed())
+		gomega.Expect(store.issueComments).To(gomega.HaveLen(1))
+
+		reviewedHeadSha, err := r.GetReviewedHeadSha(ctx, 1, 2)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(reviewedHeadSha).To(gomega.Equal("0123abcd"))
+
+		input.RelativeChangeNote = "second"
+		gomega.Expect(r.CreateMergeRequestSummary(ctx, input)).To(gomega.Succeed())
+		gomega.Expect(store.issueComments).To(gomega.HaveLen(1))
+		gomega.Expect(store.issueComments[0].Body).To(gomega.ContainSubstring("second"))
+		gomega.Expect(store.updateCount).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("Should create the review comments on either side", func() {
+		ctx := context.Background()
+		err := r.CreateMergeRequestDiscussion(ctx, CreateMergeRequestDiscussionInput{
+			ProjectId: 1, MergeRequestId: 2, Body: "added line",
+			Position: PositionDto{HeadSha: "head-sha", NewPath: "main.go", OldPath: "main.go", NewLine: 3},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		err = r.CreateMergeRequestDiscussion(ctx, CreateMergeRequestDiscussionInput{
+			ProjectId: 1, MergeRequestId: 2, Body: "removed line",
+			Position: PositionDto{HeadSha: "head-sha", NewPath: "new.go", OldPath: "old.go", OldLine: 5},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		gomega.Expect(store.reviewComments).To(gomega.Equal([]githubReviewCommentDto{
+			{Body: "added line", CommitId: "head-sha", Path: "main.go", Line: 3, Side: githubSideRight},
+			{Body: "removed line", CommitId: "head-sha", Path: "new.go", Line: 5, Side: githubSideLeft},
+		}))
+	})
+
+	ginkgo.It("Should get the raw file at the ref", func() {
+		content, err := r.GetFile(context.Background(), 1, "docs/.gitlab-mr-reviewer.yml", "head-sha")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(string(content)).To(gomega.Equal("Language: Japanese\n"))
+
+		_, err = r.GetFile(context.Background(), 1, ".gitlab-mr-reviewer.yml", "head-sha")
+		gomega.Expect(errors.Is(err, retry.ErrorNotFound)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should return typed error with invalid token", func() {
+		_, err := NewGithubRepository(logger, &http.Client{}, testServer.URL, "wrong-token", 2).GetMergeRequest(context.Background(), 1, 2)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(errors.Is(err, retry.ErrorUnauthorized)).To(gomega.BeTrue())
+	})
+})
@@ -7,22 +7,34 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
 	"io"
 	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 )
 
-type GitlabRepository interface {
-	ListDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error)
-	GetMergeRequest(ctx context.Context, projectId, mergeRequestId int32) (*MergeRequestDto, error)
-	CreateMergeRequestSummary(context.Context, CreateMergeRequestSummaryInput) error
-}
+const (
+	// summaryNoteMarker is a hidden marker to find the summary note posted by the bot
+	summaryNoteMarker = "<!-- gitlab-mr-reviewer:summary -->"
+	// headShaMarker is a hidden marker to record the head sha reviewed by the bot
+	headShaMarker = "<!-- gitlab-mr-reviewer:head-sha=%s -->"
+	// promptVersionsMarker is a hidden marker to record the versions of the prompt templates the review is generated with
+	promptVersionsMarker = "<!-- gitlab-mr-reviewer:prompts=%s -->"
+
+	defaultPerPage = 20
+)
+
+var headShaMarkerRegexp = regexp.MustCompile(`<!-- gitlab-mr-reviewer:head-sha=([0-9a-f]+) -->`)
 
 type CommitDto struct {
 	Id      string `json:"id"`
 	ShortId string `json:"short_id"`
 	Title   string `json:"title"`
-	Message string `json:"Message"`
+	Message string `json:"message"`
 }
 type DiffDto struct {
 	Diff        string `json:"diff"`
@@ -31,22 +43,80 @@ type DiffDto struct {
 	NewFile     bool   `json:"new_file"`
 	RenameFile  bool   `json:"renamed_file"`
 	DeletedFile bool   `json:"deleted_file"`
+	TooLarge    bool   `json:"too_large"`
+	Collapsed   bool   `json:"collapsed"`
 }
 type DiffRefsDto struct {
-	BaseSha string `json:"base_sha"`
-	HeadSha string `json:"head_sha"`
+	BaseSha  string `json:"base_sha"`
+	StartSha string `json:"start_sha"`
+	HeadSha  string `json:"head_sha"`
 }
 type MergeRequestDto struct {
 	ProjectId   int32       `json:"project_id"`
 	Id          int32       `json:"iid"`
 	Title       string      `json:"title"`
 	Description string      `json:"description"`
+	Author      AuthorDto   `json:"author"`
+	Labels      []string    `json:"labels"`
 	DiffRefs    DiffRefsDto `json:"diff_refs"`
 }
+type AuthorDto struct {
+	Username string `json:"username"`
+}
+
+type CompareDto struct {
+	Commits        []CommitDto `json:"commits"`
+	Diffs          []DiffDto   `json:"diffs"`
+	CompareSameRef bool        `json:"compare_same_ref"`
+}
+
+type NoteDto struct {
+	Id     int64     `json:"id"`
+	Body   string    `json:"body"`
+	System bool      `json:"system"`
+	Author AuthorDto `json:"author"`
+}
+
+type OmittedChangeDto struct {
+	Path   string
+	Reason string
+}
 
 type CreateMergeRequestSummaryInput struct {
 	ProjectId, MergeRequestId       int32
 	RelativeChangeNote, SummaryNote string
+	// FindingNote is the rendered findings of the review, it is empty if there is no finding
+	FindingNote string
+	// OmittedChanges are the files which couldn't be included in the review
+	OmittedChanges []OmittedChangeDto
+	// NonConventionalCommits are the commits which don't follow Conventional Commits
+	NonConventionalCommits []CommitDto
+	// HeadSha is recorded in the note, so that the next review only needs to review the later commits
+	HeadSha string
+	// ReviewedHeadSha is the head sha of the previous review, it is set if only the later commits are reviewed
+	ReviewedHeadSha string
+	// PromptVersions are the versions of the prompt templates, which are recorded in the note
+	PromptVersions []string
+	// Usage is the tokens consumed by the review, which is rendered as the footer of the note if it is set
+	Usage *UsageReportDto
+}
+
+type CreateMergeRequestDiscussionInput struct {
+	ProjectId, MergeRequestId int32
+	Body                      string
+	Position                  PositionDto
+}
+
+// PositionDto anchors a discussion to a line of the diff, see https://docs.gitlab.com/ee/api/discussions.html#create-a-new-thread-in-the-merge-request-diff
+type PositionDto struct {
+	PositionType string `json:"position_type"`
+	BaseSha      string `json:"base_sha"`
+	StartSha     string `json:"start_sha"`
+	HeadSha      string `json:"head_sha"`
+	NewPath      string `json:"new_path"`
+	OldPath      string `json:"old_path"`
+	NewLine      int32  `json:"new_line,omitempty"`
+	OldLine      int32  `json:"old_line,omitempty"`
 }
 
 type gitlabRepository struct {
@@ -54,22 +124,35 @@ type gitlabRepository struct {
 	httpClient    *http.Client
 	baseUrl       string
 	authorization string
+	perPage       int
+	currentUser   currentUser
 }
 
-func NewGitlabRepository(logger *logging.ZaprLogger, baseUrl string, authorization string) GitlabRepository {
+func NewGitlabRepository(logger *logging.ZaprLogger, httpClient *http.Client, baseUrl string, authorization string, perPage int) CodeHostRepository {
+	if perPage <= 0 {
+		perPage = defaultPerPage
+	}
 	return &gitlabRepository{
-		httpClient:    &http.Client{},
+		httpClient:    httpClient,
 		logger:        logger,
 		baseUrl:       baseUrl,
 		authorization: authorization,
+		perPage:       perPage,
 	}
 }
 
+// ListDiffByMergeRequestId list the diffs of every page, the diff is empty if it is too large or collapsed
 func (r *gitlabRepository) ListDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error) {
-	var diffs []DiffDto
-
 	url := fmt.Sprintf("%s/api/v4/projects/%d/merge_requests/%d/diffs", r.baseUrl, projectId, mergeRequestId)
 
+	return listAllPages[DiffDto](ctx, r, url, nil)
+}
+
+// ListRawDiffByMergeRequestId list the diffs parsed from the raw unified diff of the merge request,
+// which are not limited in size like ListDiffByMergeRequestId
+func (r *gitlabRepository) ListRawDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error) {
+	url := fmt.Sprintf("%s/api/v4/projects/%d/merge_requests/%d/raw_diffs", r.baseUrl, projectId, mergeRequestId)
+
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
@@ -81,20 +164,45 @@ func (r *gitlabRepository) ListDiffByMergeRequestId(ctx context.Context, project
 		return nil, err
 	}
 	defer response.Body.Close()
+	bodyBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
 	if response.StatusCode != http.StatusOK {
-		bodyBytes, err := io.ReadAll(response.Body)
-		if err != nil {
-			r.logger.Info(fmt.Sprintf("Failed to read response body: %s", err))
-		}
 		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
-		return nil, errors.New(fmt.Sprintf("response status code %d", response.StatusCode))
+		return nil, retry.NewStatusError(response.StatusCode, string(bodyBytes))
 	}
 
-	if err := json.NewDecoder(response.Body).Decode(&diffs); err != nil {
+	return parseRawDiff(string(bodyBytes)), nil
+}
+
+// GetFile get the raw file at the ref, see https://docs.gitlab.com/ee/api/repository_files.html#get-raw-file-from-repository
+func (r *gitlabRepository) GetFile(ctx context.Context, projectId int32, path, ref string) ([]byte, error) {
+	query := url.Values{}
+	query.Set("ref", ref)
+	url := fmt.Sprintf("%s/api/v4/projects/%d/repository/files/%s/raw?%s", r.baseUrl, projectId, url.PathEscape(path), query.Encode())
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
 		return nil, err
 	}
+	request.Header.Add("PRIVATE-TOKEN", r.authorization)
 
-	return diffs, nil
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	bodyBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
+		return nil, retry.NewStatusError(response.StatusCode, string(bodyBytes))
+	}
+
+	return bodyBytes, nil
 }
 
 func (r *gitlabRepository) GetMergeRequest(ctx context.Context, projectId, mergeRequestId int32) (*MergeRequestDto, error) {
@@ -121,7 +229,7 @@ func (r *gitlabRepository) GetMergeRequest(ctx context.Context, projectId, merge
 			r.logger.Info(fmt.Sprintf("Failed to read response body: %s", err.Error()))
 		}
 		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
-		return nil, errors.New(fmt.Sprintf("response status code %d", response.StatusCode))
+		return nil, retry.NewStatusError(response.StatusCode, string(bodyBytes))
 	}
 
 	if err := json.NewDecoder(response.Body).Decode(&mr); err != nil {
@@ -132,19 +240,165 @@ func (r *gitlabRepository) GetMergeRequest(ctx context.Context, projectId, merge
 	return &mr, nil
 }
 
+// ListMergeRequestCommits list the commits of every page, see https://docs.gitlab.com/ee/api/merge_requests.html#get-single-merge-request-commits
+func (r *gitlabRepository) ListMergeRequestCommits(ctx context.Context, projectId, mergeRequestId int32) ([]CommitDto, error) {
+	url := fmt.Sprintf("%s/api/v4/projects/%d/merge_requests/%d/commits", r.baseUrl, projectId, mergeRequestId)
+
+	commits, err := listAllPages[CommitDto](ctx, r, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Gitlab lists the latest commit first
+	slices.Reverse(commits)
+	return commits, nil
+}
+
+// CreateMergeRequestSummary create the summary note, or update the one posted by the previous review
 func (r *gitlabRepository) CreateMergeRequestSummary(ctx context.Context, input CreateMergeRequestSummaryInput) error {
+	note := renderMergeRequestSummary(input)
+	r.logger.Debug(fmt.Sprintf("generated note: %s", note))
+
+	existingNote, err := findSummaryNote(ctx, r, input.ProjectId, input.MergeRequestId)
+	if err != nil {
+		return err
+	}
+	if existingNote == nil {
+		return r.createMergeRequestNote(ctx, input.ProjectId, input.MergeRequestId, note)
+	}
+	if existingNote.Body == note {
+		r.logger.Info(fmt.Sprintf("Summary note %d is up to date", existingNote.Id))
+		return nil
+	}
+	return r.UpdateMergeRequestNote(ctx, input.ProjectId, input.MergeRequestId, existingNote.Id, note)
+}
+
+// renderMergeRequestSummary render the body of the summary note
+func renderMergeRequestSummary(input CreateMergeRequestSummaryInput) string {
 	builder := strings.Builder{}
+	builder.WriteString(summaryNoteMarker)
+	builder.WriteString("\n")
+	if len(input.HeadSha) > 0 {
+		builder.WriteString(fmt.Sprintf(headShaMarker, input.HeadSha))
+		builder.WriteString("\n")
+	}
+	if len(input.PromptVersions) > 0 {
+		builder.WriteString(fmt.Sprintf(promptVersionsMarker, strings.Join(input.PromptVersions, ",")))
+		builder.WriteString("\n")
+	}
 	builder.WriteString(":robot: CodeReviewerBot\n\n")
+	if len(input.ReviewedHeadSha) > 0 {
+		builder.WriteString(fmt.Sprintf("> :information_source: **Incremental review** of the commits pushed since the last review (`%s...%s`).\n\n", shortSha(input.ReviewedHeadSha), shortSha(input.HeadSha)))
+	}
 	builder.WriteString(input.RelativeChangeNote)
 	builder.WriteString("\n---\n")
-	builder.WriteString(input.SummaryNote)
-	builder.WriteString("\n---\n")
+	if len(input.FindingNote) > 0 {
+		builder.WriteString(input.FindingNote)
+		builder.WriteString("\n---\n")
+	}
+	if len(input.SummaryNote) > 0 {
+		builder.WriteString(input.SummaryNote)
+		builder.WriteString("\n---\n")
+	}
+	if len(input.OmittedChanges) > 0 {
+		builder.WriteString("### Files not reviewed\n")
+		for _, change := range input.OmittedChanges {
+			builder.WriteString(fmt.Sprintf("- `%s`: %s\n", change.Path, change.Reason))
+		}
+		builder.WriteString("\n---\n")
+	}
+	if len(input.NonConventionalCommits) > 0 {
+		builder.WriteString(renderNonConventionalCommits(input.NonConventionalCommits))
+		builder.WriteString("\n---\n")
+	}
 	builder.WriteString("### Ignoring further reviews\n- Type `@codeReview: ignore` anywhere in the MR description to ignore further reviews from the bot.")
+	if input.Usage != nil {
+		builder.WriteString("\n\n")
+		builder.WriteString(renderUsage(*input.Usage))
+	}
 
-	note := builder.String()
-	r.logger.Debug(fmt.Sprintf("generated note: %s", note))
+	return builder.String()
+}
+
+// renderNonConventionalCommits render the list of the commits which don't follow Conventional Commits
+func renderNonConventionalCommits(commits []CommitDto) string {
+	builder := strings.Builder{}
+	builder.WriteString("### Commits not following [Conventional Commits](https://www.conventionalcommits.org)\n")
+	for _, commit := range commits {
+		builder.WriteString(fmt.Sprintf("- `%s` %s\n", shortSha(commit.Id), commit.Title))
+	}
+	return builder.String()
+}
+
+func (r *gitlabRepository) GetReviewedHeadSha(ctx context.Context, projectId, mergeRequestId int32) (string, error) {
+	return getReviewedHeadSha(ctx, r, projectId, mergeRequestId)
+}
+
+// currentUsername get the user of the token, see https://docs.gitlab.com/ee/api/users.html#list-current-user
+func (r *gitlabRepository) currentUsername(ctx context.Context) (string, error) {
+	return r.currentUser.get(func() (string, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/v4/user", r.baseUrl), nil)
+		if err != nil {
+			return "", err
+		}
+		request.Header.Add("PRIVATE-TOKEN", r.authorization)
 
-	return r.createMergeRequestNote(ctx, input.ProjectId, input.MergeRequestId, note)
+		response, err := r.httpClient.Do(request)
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(response.Body)
+			r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
+			return "", retry.NewStatusError(response.StatusCode, string(bodyBytes))
+		}
+
+		var user AuthorDto
+		if err := json.NewDecoder(response.Body).Decode(&user); err != nil {
+			return "", err
+		}
+		return user.Username, nil
+	})
+}
+
+func (r *gitlabRepository) ListMergeRequestNotes(ctx context.Context, projectId, mergeRequestId int32) ([]NoteDto, error) {
+	url := fmt.Sprintf("%s/api/v4/projects/%d/merge_requests/%d/notes", r.baseUrl, projectId, mergeRequestId)
+
+	return listAllPages[NoteDto](ctx, r, url, map[string]string{"sort": "asc"})
+}
+
+func (r *gitlabRepository) UpdateMergeRequestNote(ctx context.Context, projectId, mergeRequestId int32, noteId int64, body string) error {
+	url := fmt.Sprintf("%s/api/v4/projects/%d/merge_requests/%d/notes/%d", r.baseUrl, projectId, mergeRequestId, noteId)
+
+	requestBody := map[string]string{
+		"body": body,
+	}
+	requestBodyByte, err := json.Marshal(requestBody)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(requestBodyByte))
+	if err != nil {
+		return err
+	}
+	request.Header.Add("PRIVATE-TOKEN", r.authorization)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		bodyBytes, err := io.ReadAll(response.Body)
+		if err != nil {
+			r.logger.Info(fmt.Sprintf("Failed to read response body: %s", err))
+		}
+		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
+		return errors.Wrap(retry.NewStatusError(response.StatusCode, string(bodyBytes)), "Failed to update note")
+	}
+	return nil
 }
 
 func (r *gitlabRepository) createMergeRequestNote(ctx context.Context, projectId, mergeRequestId int32, note string) error {
@@ -169,17 +423,234 @@ func (r *gitlabRepository) createMergeRequestNote(ctx context.Context, projectId
 	if err != nil {
 		return err
 	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		bodyBytes, err := io.ReadAll(response.Body)
+		if err != nil {
+			r.logger.Info(fmt.Sprintf("Failed to read response body: %s", err))
+		}
+		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
+		return errors.Wrap(retry.NewStatusError(response.StatusCode, string(bodyBytes)), "Failed to create note")
+	}
+	return nil
+}
+
+func (r *gitlabRepository) CreateMergeRequestDiscussion(ctx context.Context, input CreateMergeRequestDiscussionInput) error {
+	url := fmt.Sprintf("%s/api/v4/projects/%d/merge_requests/%d/discussions", r.baseUrl, input.ProjectId, input.MergeRequestId)
+
+	requestBody := map[string]any{
+		"body":     input.Body,
+		"position": input.Position,
+	}
+	requestBodyByte, err := json.Marshal(requestBody)
+	if err != nil {
+		return err
+	}
+
+	// a rejected discussion doesn't fail the review, it isn't worth waiting for many attempts
+	request, err := http.NewRequestWithContext(retry.WithMaxAttempts(ctx, 2), http.MethodPost, url, bytes.NewReader(requestBodyByte))
+	if err != nil {
+		return err
+	}
+	request.Header.Add("PRIVATE-TOKEN", r.authorization)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
 	if response.StatusCode != http.StatusCreated {
 		bodyBytes, err := io.ReadAll(response.Body)
 		if err != nil {
 			r.logger.Info(fmt.Sprintf("Failed to read response body: %s", err))
 		}
 		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
-		return errors.New(fmt.Sprintf("Failed to create note. status: %d, response: %s", response.StatusCode, string(bodyBytes)))
+		return errors.Wrap(retry.NewStatusError(response.StatusCode, string(bodyBytes)), "Failed to create discussion")
 	}
 	return nil
 }
 
-func (r *gitlabRepository) ListCommitFromSource() {
+func (r *gitlabRepository) CreateMergeRequestDiscussions(ctx context.Context, inputs []CreateMergeRequestDiscussionInput) error {
+	return createMergeRequestDiscussions(ctx, r, r.logger, inputs)
+}
+
+// ListComparedCommits return the commits of the compare, which returns the diffs in the same response anyway
+func (r *gitlabRepository) ListComparedCommits(ctx context.Context, projectId int32, from, to string) ([]CommitDto, error) {
+	compare, err := r.CompareCommits(ctx, projectId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return compare.Commits, nil
+}
+
+// CompareCommits return the commits and diffs between the merge base of from and to, and to
+func (r *gitlabRepository) CompareCommits(ctx context.Context, projectId int32, from, to string) (*CompareDto, error) {
+	var compare CompareDto
+
+	query := url.Values{}
+	query.Set("from", from)
+	query.Set("to", to)
+	url := fmt.Sprintf("%s/api/v4/projects/%d/repository/compare?%s", r.baseUrl, projectId, query.Encode())
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("PRIVATE-TOKEN", r.authorization)
+
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		bodyBytes, err := io.ReadAll(response.Body)
+		if err != nil {
+			r.logger.Info(fmt.Sprintf("Failed to read response body: %s", err))
+		}
+		r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
+		return nil, retry.NewStatusError(response.StatusCode, string(bodyBytes))
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&compare); err != nil {
+		return nil, err
+	}
+
+	return &compare, nil
+}
+
+// listAllPages follow the X-Next-Page header to list the items of every page, see https://docs.gitlab.com/ee/api/rest/#pagination
+func listAllPages[T any](ctx context.Context, r *gitlabRepository, baseUrl string, params map[string]string) ([]T, error) {
+	var items []T
+
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	query.Set("per_page", strconv.Itoa(r.perPage))
+
+	for page := "1"; len(page) > 0; {
+		query.Set("page", page)
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?%s", baseUrl, query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Add("PRIVATE-TOKEN", r.authorization)
+
+		response, err := r.httpClient.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusOK {
+			bodyBytes, err := io.ReadAll(response.Body)
+			if err != nil {
+				r.logger.Info(fmt.Sprintf("Failed to read response body: %s", err))
+			}
+			response.Body.Close()
+			r.logger.Info(fmt.Sprintf("response: %s", string(bodyBytes)))
+			return nil, retry.NewStatusError(response.StatusCode, string(bodyBytes))
+		}
+
+		var pageItems []T
+		err = json.NewDecoder(response.Body).Decode(&pageItems)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, pageItems...)
+		page = response.Header.Get("X-Next-Page")
+	}
+
+	return items, nil
+}
+
+// parseRawDiff split the raw unified diff of multiple files into DiffDto, the diff of each file starts with `diff --git`
+func parseRawDiff(raw string) []DiffDto {
+	var diffs []DiffDto
+	var diff *DiffDto
+	var builder strings.Builder
+	flush := func() {
+		if diff != nil {
+			diff.Diff = builder.String()
+			diffs = append(diffs, *diff)
+		}
+		builder.Reset()
+	}
 
+	for _, line := range strings.SplitAfter(raw, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			// the paths of the header are overridden by the `---`, `+++` and rename lines, which are missing if only the mode or a binary file changes
+			oldPath, newPath := parseRawDiffHeader(strings.TrimSpace(strings.TrimPrefix(line, "diff --git ")))
+			diff = &DiffDto{OldPath: oldPath, NewPath: newPath}
+		case diff == nil:
+		case builder.Len() > 0:
+			// the hunks have started
+			builder.WriteString(line)
+		case strings.HasPrefix(line, "@@ "):
+			builder.WriteString(line)
+		case strings.HasPrefix(line, "new file mode"):
+			diff.NewFile = true
+		case strings.HasPrefix(line, "deleted file mode"):
+			diff.DeletedFile = true
+		case strings.HasPrefix(line, "--- "):
+			if oldPath := parseRawDiffPath(line, "--- a/"); oldPath != "" {
+				diff.OldPath = oldPath
+			} else {
+				diff.NewFile = true
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if newPath := parseRawDiffPath(line, "+++ b/"); newPath != "" {
+				diff.NewPath = newPath
+			} else {
+				diff.DeletedFile = true
+			}
+		case strings.HasPrefix(line, "rename from "):
+			diff.OldPath = strings.TrimSpace(strings.TrimPrefix(line, "rename from "))
+			diff.RenameFile = true
+		case strings.HasPrefix(line, "rename to "):
+			diff.NewPath = strings.TrimSpace(strings.TrimPrefix(line, "rename to "))
+			diff.RenameFile = true
+		}
+	}
+	flush()
+
+	for i := range diffs {
+		// Gitlab keeps both paths even if the file is new or deleted
+		if diffs[i].NewFile {
+			diffs[i].OldPath = diffs[i].NewPath
+		}
+		if diffs[i].DeletedFile {
+			diffs[i].NewPath = diffs[i].OldPath
+		}
+	}
+	return diffs
+}
+
+// parseRawDiffHeader return the paths of `a/<old> b/<new>`
+func parseRawDiffHeader(header string) (string, string) {
+	header = strings.TrimPrefix(header, "a/")
+	if i := strings.Index(header, " b/"); i >= 0 {
+		return header[:i], header[i+len(" b/"):]
+	}
+	return header, header
+}
+
+// parseRawDiffPath return the path of the `---` or `+++` line, or empty if it is /dev/null
+func parseRawDiffPath(line string, prefix string) string {
+	line = strings.TrimRight(line, "\n")
+	if !strings.HasPrefix(line, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(line, prefix)
+}
+
+func shortSha(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
 }
@@ -6,14 +6,55 @@ import (
 	"fmt"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
 	"gitlab-mr-reviewer/pkg/logging"
-	"io"
+	"gitlab-mr-reviewer/pkg/retry"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 )
 
-func runMockGitlabServer(logger *logging.ZaprLogger, authorization string) *httptest.Server {
+// mockGitlabStore keeps the notes of the single merge request served by the mock Gitlab server
+type mockGitlabStore struct {
+	mutex       sync.Mutex
+	notes       []NoteDto
+	updateCount int
+}
+
+func (s *mockGitlabStore) listNotes() []NoteDto {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.notes)
+}
+
+func (s *mockGitlabStore) createNote(username string, body string) NoteDto {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	note := NoteDto{Id: int64(len(s.notes) + 1), Body: body, Author: AuthorDto{Username: username}}
+	s.notes = append(s.notes, note)
+	return note
+}
+
+func (s *mockGitlabStore) updateNote(id int64, body string) (NoteDto, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.notes {
+		if s.notes[i].Id == id {
+			s.notes[i].Body = body
+			s.updateCount++
+			return s.notes[i], true
+		}
+	}
+	return NoteDto{}, false
+}
+
+// mockGitlabUsername is the user of the token, who posts the notes through the API
+const mockGitlabUsername = "reviewer-bot"
+
+func runMockGitlabServer(logger *logging.ZaprLogger, authorization string, store *mockGitlabStore) *httptest.Server {
 	serveMux := http.NewServeMux()
 	logMiddleware := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -41,6 +82,9 @@ func runMockGitlabServer(logger *logging.ZaprLogger, authorization string) *http
 	serveMux.Handle("/auth/health", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))))
+	serveMux.Handle("GET /api/v4/user", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(AuthorDto{Username: mockGitlabUsername})
+	}))))
 	serveMux.Handle("GET /api/v4/projects/{projectId}/merge_requests/{mergeRequestId}", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		projectId, err := strconv.Atoi(r.PathValue("projectId"))
 		if err != nil {
@@ -91,7 +135,7 @@ func runMockGitlabServer(logger *logging.ZaprLogger, authorization string) *http
 			w.WriteHeader(http.StatusBadRequest)
 		}
 
-		bytes, err := json.Marshal([]map[string]any{
+		diffs := []map[string]any{
 			{
 				"diff":         "@@ -0,0 +1,9 @@\n+package mutation\n+\n+import \"github.com/pkg/errors\"\n+\n+var (\n+\tErrorFailedCreateConfigmap = errors.New(\"Failed to create configmap\")\n+\tErrorConfigmapExists       = errors.New(\"Configmap already exists\")\n+\tErrorConfigmapNotFound     = errors.New(\"Configmap not found\")\n+)\n",
 				"new_path":     "internal/mutation/errors.go",
@@ -102,7 +146,39 @@ func runMockGitlabServer(logger *logging.ZaprLogger, authorization string) *http
 				"renamed_file": false,
 				"deleted_file": false,
 			},
-		})
+			{
+				"diff":         "@@ -1,3 +1,3 @@\n package mutation\n-const a = 1\n+const a = 2\n \n",
+				"new_path":     "internal/mutation/constants.go",
+				"old_path":     "internal/mutation/constants.go",
+				"new_file":     false,
+				"renamed_file": false,
+				"deleted_file": false,
+			},
+			{
+				"diff":         "",
+				"new_path":     "internal/mutation/generated.go",
+				"old_path":     "internal/mutation/generated.go",
+				"new_file":     false,
+				"renamed_file": false,
+				"deleted_file": false,
+				"too_large":    true,
+			},
+		}
+
+		perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+		if err != nil {
+			perPage = 20
+		}
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil {
+			page = 1
+		}
+		start, end := min((page-1)*perPage, len(diffs)), min(page*perPage, len(diffs))
+		if end < len(diffs) {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+
+		bytes, err := json.Marshal(diffs[start:end])
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -115,36 +191,220 @@ func runMockGitlabServer(logger *logging.ZaprLogger, authorization string) *http
 		}
 	}))))
 
+	serveMux.Handle("GET /api/v4/projects/{projectId}/merge_requests/{mergeRequestId}/commits", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the latest commit comes first
+		commits := []map[string]any{
+			{"id": "94e7e0bb7144018e544743e1d6f22731f8ddeba1", "title": "fix typo", "message": "fix typo\n"},
+			{"id": "5d2c8e7f0a1b3c4d5e6f7a8b9c0d1e2f3a4b5c6d", "title": "feat: add the errors", "message": "feat: add the errors\n\nThe errors are compared with errors.Is.\n"},
+			{"id": "0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b", "title": "chore: init", "message": "chore: init\n"},
+		}
+
+		perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+		if err != nil {
+			perPage = 20
+		}
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil {
+			page = 1
+		}
+		start, end := min((page-1)*perPage, len(commits)), min(page*perPage, len(commits))
+		if end < len(commits) {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+
+		bytes, err := json.Marshal(commits[start:end])
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(bytes); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}))))
+
+	serveMux.Handle("GET /api/v4/projects/{projectId}/merge_requests/{mergeRequestId}/raw_diffs", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("diff --git a/internal/mutation/generated.go b/internal/mutation/generated.go\n" +
+			"index 3b18e51..a9c9b3c 100644\n" +
+			"--- a/internal/mutation/generated.go\n" +
+			"+++ b/internal/mutation/generated.go\n" +
+			"@@ -1,2 +1,2 @@\n" +
+			" package mutation\n" +
+			"-var generated = 1\n" +
+			"+var generated = 2\n" +
+			"diff --git a/internal/mutation/new.go b/internal/mutation/new.go\n" +
+			"new file mode 100644\n" +
+			"--- /dev/null\n" +
+			"+++ b/internal/mutation/new.go\n" +
+			"@@ -0,0 +1 @@\n" +
+			"+package mutation\n" +
+			"diff --git a/internal/mutation/old.go b/internal/mutation/renamed.go\n" +
+			"similarity index 100%\n" +
+			"rename from internal/mutation/old.go\n" +
+			"rename to internal/mutation/renamed.go\n" +
+			"diff --git a/assets/logo.png b/assets/logo.png\n" +
+			"new file mode 100644\n" +
+			"index 0000000..e69de29\n" +
+			"Binary files /dev/null and b/assets/logo.png differ\n" +
+			"diff --git a/scripts/run.sh b/scripts/run.sh\n" +
+			"old mode 100644\n" +
+			"new mode 100755\n"))
+	}))))
+
+	serveMux.Handle("GET /api/v4/projects/{projectId}/merge_requests/{mergeRequestId}/notes", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+		if err != nil {
+			perPage = 20
+		}
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil {
+			page = 1
+		}
+
+		notes := store.listNotes()
+		start, end := min((page-1)*perPage, len(notes)), min(page*perPage, len(notes))
+		if end < len(notes) {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+
+		responseBody, err := json.Marshal(notes[start:end])
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(responseBody); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}))))
+
 	serveMux.Handle("POST /api/v4/projects/{projectId}/merge_requests/{mergeRequestId}/notes", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		projectId, err := strconv.Atoi(r.PathValue("projectId"))
+		_, err := strconv.Atoi(r.PathValue("projectId"))
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
-		mergeRequestId, err := strconv.Atoi(r.PathValue("mergeRequestId"))
+		_, err = strconv.Atoi(r.PathValue("mergeRequestId"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		var requestBody NoteDto
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		logger.Info(fmt.Sprintf("[MockGitlabServer]RequestBody: %s", requestBody.Body))
+
+		responseBody, err := json.Marshal(store.createNote(mockGitlabUsername, requestBody.Body))
+		if err != nil {
+			logger.Info(fmt.Sprintf("[MockGitlabServer]Error marshalling response: %s", err.Error()))
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if _, err := w.Write(responseBody); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+	}))))
+
+	serveMux.Handle("PUT /api/v4/projects/{projectId}/merge_requests/{mergeRequestId}/notes/{noteId}", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		noteId, err := strconv.ParseInt(r.PathValue("noteId"), 10, 64)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
-		requestBodyBytes, err := io.ReadAll(r.Body)
+		var requestBody NoteDto
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		note, ok := store.updateNote(noteId, requestBody.Body)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		responseBody, err := json.Marshal(note)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(responseBody); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}))))
+
+	serveMux.Handle("POST /api/v4/projects/{projectId}/merge_requests/{mergeRequestId}/discussions", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := strconv.Atoi(r.PathValue("projectId"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		_, err = strconv.Atoi(r.PathValue("mergeRequestId"))
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
-		logger.Info(fmt.Sprintf("[MockGitlabServer]RequestBody: %s", string(requestBodyBytes)))
+		var requestBody struct {
+			Body     string      `json:"body"`
+			Position PositionDto `json:"position"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		logger.Info(fmt.Sprintf("[MockGitlabServer]RequestBody: %#v", requestBody))
+
+		position := requestBody.Position
+		if len(position.BaseSha) == 0 || len(position.StartSha) == 0 || len(position.HeadSha) == 0 || len(position.NewPath) == 0 || position.NewLine <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message":"400 Bad request - Note {:line_code=>[\"can't be blank\"]}"}`))
+			return
+		}
 
 		responseBody, err := json.Marshal(map[string]any{
-			"body":         string(requestBodyBytes),
-			"noteable_iid": mergeRequestId,
-			"project_id":   projectId,
+			"id": "6a9c1750b37d513a43987b574953fceb50b03ce7",
+			"notes": []map[string]any{
+				{
+					"type":     "DiffNote",
+					"body":     requestBody.Body,
+					"position": position,
+				},
+			},
 		})
 		if err != nil {
-			logger.Info(fmt.Sprintf("[MockGitlabServer]Error marshalling response: %s", err.Error()))
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
 			return
@@ -157,7 +417,64 @@ func runMockGitlabServer(logger *logging.ZaprLogger, authorization string) *http
 			w.Write([]byte(err.Error()))
 			return
 		}
+	}))))
+
+	serveMux.Handle("GET /api/v4/projects/{projectId}/repository/compare", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		if len(from) == 0 || len(to) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if from == to {
+			responseBody, _ := json.Marshal(map[string]any{"commits": []any{}, "diffs": []any{}, "compare_same_ref": true})
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(responseBody)
+			return
+		}
 
+		responseBody, err := json.Marshal(map[string]any{
+			"commit": map[string]any{"id": to},
+			"commits": []map[string]any{
+				{
+					"id":       to,
+					"short_id": to[:8],
+					"title":    "fix: use pkg/errors",
+					"message":  "fix: use pkg/errors\n",
+				},
+			},
+			"diffs": []map[string]any{
+				{
+					"diff":         "@@ -1,3 +1,3 @@\n package mutation\n-import \"errors\"\n+import \"github.com/pkg/errors\"\n \n",
+					"new_path":     "internal/mutation/errors.go",
+					"old_path":     "internal/mutation/errors.go",
+					"new_file":     false,
+					"renamed_file": false,
+					"deleted_file": false,
+				},
+			},
+			"compare_same_ref": false,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(responseBody); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}))))
+
+	serveMux.Handle("GET /api/v4/projects/{projectId}/repository/files/{filePath}/raw", logMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the file path is a single escaped segment
+		if r.PathValue("filePath") != "docs/.gitlab-mr-reviewer.yml" || r.URL.Query().Get("ref") != "94e7e0bb7144018e544743e1d6f22731f8ddeba1" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"404 File Not Found"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("Language: Japanese\n"))
 	}))))
 
 	return httptest.NewServer(serveMux)
@@ -165,17 +482,19 @@ func runMockGitlabServer(logger *logging.ZaprLogger, authorization string) *http
 
 var _ = ginkgo.Describe("GitlabRepository", ginkgo.Ordered, func() {
 	var logger *logging.ZaprLogger
-	var r GitlabRepository
+	var r CodeHostRepository
 	var testServer *httptest.Server
+	var store *mockGitlabStore
 	authorization := "fake-token"
 	ginkgo.BeforeAll(func() {
 		var err error
 		logger, err = logging.NewZaprLogger(false, "info")
 		gomega.Expect(err).ToNot(gomega.HaveOccurred())
 
-		testServer = runMockGitlabServer(logger, authorization)
+		store = &mockGitlabStore{}
+		testServer = runMockGitlabServer(logger, authorization, store)
 		logger.Info(fmt.Sprintf("testServer: %s", testServer.URL))
-		r = NewGitlabRepository(logger, testServer.URL, authorization)
+		r = NewGitlabRepository(logger, &http.Client{}, testServer.URL, authorization, 2)
 	})
 
 	ginkgo.It("Mock Gitlab Server should be running", func() {
@@ -218,6 +537,15 @@ var _ = ginkgo.Describe("GitlabRepository", ginkgo.Ordered, func() {
 
 	})
 
+	ginkgo.It("Should skip the summary note of other users", func() {
+		ctx := context.Background()
+		store.createNote("alice", summaryNoteMarker+"\n"+fmt.Sprintf(headShaMarker, "94e7e0bb7144018e544743e1d6f22731f8ddeba1"))
+
+		reviewedHeadSha, err := r.GetReviewedHeadSha(ctx, 1, 1)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(reviewedHeadSha).To(gomega.BeEmpty())
+	})
+
 	ginkgo.It("Should be able to CreateMergeRequestSummary", func() {
 		ctx := context.Background()
 		projectId := int32(1)
@@ -236,6 +564,173 @@ var _ = ginkgo.Describe("GitlabRepository", ginkgo.Ordered, func() {
 
 	})
 
+	ginkgo.It("Should update the summary note instead of creating a new one", func() {
+		ctx := context.Background()
+		input := CreateMergeRequestSummaryInput{
+			ProjectId:          1,
+			MergeRequestId:     1,
+			RelativeChangeNote: "## High-level Summary\nThe first review.",
+			SummaryNote:        "### Release Notes\n- The first review.",
+		}
+
+		ginkgo.By("other notes should be kept")
+		for i := 0; i < 120; i++ {
+			store.createNote("alice", fmt.Sprintf("note %d", i))
+		}
+
+		ginkgo.By("create or update the summary note")
+		gomega.Expect(r.CreateMergeRequestSummary(ctx, input)).To(gomega.Succeed())
+		notes, err := r.ListMergeRequestNotes(ctx, 1, 1)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(notes).To(gomega.HaveLen(len(store.listNotes())))
+		updateCount := store.updateCount
+
+		ginkgo.By("update the summary note")
+		input.RelativeChangeNote = "## High-level Summary\nThe second review."
+		gomega.Expect(r.CreateMergeRequestSummary(ctx, input)).To(gomega.Succeed())
+		gomega.Expect(store.listNotes()).To(gomega.HaveLen(len(notes)))
+		gomega.Expect(store.updateCount).To(gomega.Equal(updateCount + 1))
+
+		var summaryNotes []NoteDto
+		for _, note := range store.listNotes() {
+			if note.Author.Username == mockGitlabUsername && strings.HasPrefix(note.Body, summaryNoteMarker) {
+				summaryNotes = append(summaryNotes, note)
+			}
+		}
+		gomega.Expect(summaryNotes).To(gomega.HaveLen(1))
+		gomega.Expect(summaryNotes[0].Body).To(gomega.ContainSubstring("The second review."))
+
+		ginkgo.By("leave the summary note alone if nothing changed")
+		gomega.Expect(r.CreateMergeRequestSummary(ctx, input)).To(gomega.Succeed())
+		gomega.Expect(store.updateCount).To(gomega.Equal(updateCount + 1))
+	})
+
+	ginkgo.It("Should be able to CreateMergeRequestDiscussion", func() {
+		ctx := context.Background()
+		input := CreateMergeRequestDiscussionInput{
+			ProjectId:      1,
+			MergeRequestId: 1,
+			Body:           "Error strings should not be capitalized.",
+			Position: PositionDto{
+				PositionType: "text",
+				BaseSha:      "63c97907ceb628e4fbbc125ca9ce5bd2a1f9566f",
+				StartSha:     "63c97907ceb628e4fbbc125ca9ce5bd2a1f9566f",
+				HeadSha:      "94e7e0bb7144018e544743e1d6f22731f8ddeba1",
+				NewPath:      "internal/mutation/errors.go",
+				OldPath:      "internal/mutation/errors.go",
+				NewLine:      6,
+			},
+		}
+
+		ginkgo.By("send request to server")
+		err := r.CreateMergeRequestDiscussion(ctx, input)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		ginkgo.By("should fail without the line")
+		input.Position.NewLine = 0
+		err = r.CreateMergeRequestDiscussion(ctx, input)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("Should list the diffs of every page", func() {
+		ctx := context.Background()
+
+		diffs, err := r.ListDiffByMergeRequestId(ctx, 1, 1)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(diffs).To(gomega.HaveLen(3))
+		gomega.Expect(diffs[2].NewPath).To(gomega.Equal("internal/mutation/generated.go"))
+		gomega.Expect(diffs[2].TooLarge).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should list the commits of every page, oldest first", func() {
+		ctx := context.Background()
+
+		commits, err := r.ListMergeRequestCommits(ctx, 1, 1)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(commits).To(gomega.HaveLen(3))
+		gomega.Expect(commits[0].Title).To(gomega.Equal("chore: init"))
+		gomega.Expect(commits[1].Message).To(gomega.Equal("feat: add the errors\n\nThe errors are compared with errors.Is.\n"))
+		gomega.Expect(commits[2].Id).To(gomega.Equal("94e7e0bb7144018e544743e1d6f22731f8ddeba1"))
+	})
+
+	ginkgo.It("Should list the raw diffs", func() {
+		ctx := context.Background()
+
+		diffs, err := r.ListRawDiffByMergeRequestId(ctx, 1, 1)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(diffs).To(gomega.Equal([]DiffDto{
+			{
+				Diff:    "@@ -1,2 +1,2 @@\n package mutation\n-var generated = 1\n+var generated = 2\n",
+				NewPath: "internal/mutation/generated.go",
+				OldPath: "internal/mutation/generated.go",
+			},
+			{
+				Diff:    "@@ -0,0 +1 @@\n+package mutation\n",
+				NewPath: "internal/mutation/new.go",
+				OldPath: "internal/mutation/new.go",
+				NewFile: true,
+			},
+			{
+				NewPath:    "internal/mutation/renamed.go",
+				OldPath:    "internal/mutation/old.go",
+				RenameFile: true,
+			},
+			{
+				NewPath: "assets/logo.png",
+				OldPath: "assets/logo.png",
+				NewFile: true,
+			},
+			{
+				NewPath: "scripts/run.sh",
+				OldPath: "scripts/run.sh",
+			},
+		}))
+	})
+
+	ginkgo.It("Should record the reviewed head sha in the summary note", func() {
+		ctx := context.Background()
+		headSha := "94e7e0bb7144018e544743e1d6f22731f8ddeba1"
+
+		ginkgo.By("create the summary note with head sha")
+		err := r.CreateMergeRequestSummary(ctx, CreateMergeRequestSummaryInput{
+			ProjectId:          1,
+			MergeRequestId:     1,
+			RelativeChangeNote: "## High-level Summary\nThe incremental review.",
+			SummaryNote:        "### Release Notes\n- The incremental review.",
+			HeadSha:            headSha,
+			ReviewedHeadSha:    "63c97907ceb628e4fbbc125ca9ce5bd2a1f9566f",
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		ginkgo.By("get the reviewed head sha")
+		reviewedHeadSha, err := r.GetReviewedHeadSha(ctx, 1, 1)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(reviewedHeadSha).To(gomega.Equal(headSha))
+	})
+
+	ginkgo.It("Should be able to CompareCommits", func() {
+		ctx := context.Background()
+
+		compare, err := r.CompareCommits(ctx, 1, "63c97907ceb628e4fbbc125ca9ce5bd2a1f9566f", "94e7e0bb7144018e544743e1d6f22731f8ddeba1")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(compare.CompareSameRef).To(gomega.BeFalse())
+		gomega.Expect(compare.Commits).To(gomega.HaveLen(1))
+		gomega.Expect(compare.Diffs).To(gomega.HaveLen(1))
+		gomega.Expect(compare.Diffs[0].NewPath).To(gomega.Equal("internal/mutation/errors.go"))
+	})
+
+	ginkgo.It("Should be able to GetFile", func() {
+		ctx := context.Background()
+
+		content, err := r.GetFile(ctx, 1, "docs/.gitlab-mr-reviewer.yml", "94e7e0bb7144018e544743e1d6f22731f8ddeba1")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(string(content)).To(gomega.Equal("Language: Japanese\n"))
+
+		ginkgo.By("the file doesn't exist")
+		_, err = r.GetFile(ctx, 1, ".gitlab-mr-reviewer.yml", "94e7e0bb7144018e544743e1d6f22731f8ddeba1")
+		gomega.Expect(errors.Is(err, retry.ErrorNotFound)).To(gomega.BeTrue())
+	})
+
 	ginkgo.AfterAll(func() {
 		testServer.Close()
 	})
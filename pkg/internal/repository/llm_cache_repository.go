@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"gitlab-mr-reviewer/pkg/logging"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LLMCache keeps the responses of the LLM by the hash of their requests
+type LLMCache interface {
+	// Get return the response of the key, it is false if the response isn't cached or is expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte) error
+}
+
+type fileLLMCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewFileLLMCache keep the responses as the files in the dir, which expire after the ttl, they never expire if the ttl is 0
+func NewFileLLMCache(dir string, ttl time.Duration) LLMCache {
+	return &fileLLMCache{
+		dir: dir,
+		ttl: ttl,
+	}
+}
+
+func (c *fileLLMCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+func (c *fileLLMCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+		return nil, false, nil
+	}
+
+	value, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *fileLLMCache) Set(ctx context.Context, key string, value []byte) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// the file is renamed into place, so that a concurrent review never reads a partially written response
+	file, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(value); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// llmCacheKeyDto is everything which the response depends on, the key of the cache is its hash
+type llmCacheKeyDto struct {
+	Stage          string           `json:"stage"`
+	Model          string           `json:"model"`
+	PromptVersions []string         `json:"prompt_versions"`
+	MaxOutputToken int64            `json:"max_output_token"`
+	Messages       []domain.Message `json:"messages"`
+	ResponseSchema *ResponseSchema  `json:"response_schema,omitempty"`
+}
+
+// llmCacheValueDto is the cached response, along with the usage of the completion which created it
+type llmCacheValueDto struct {
+	Messages []domain.Message `json:"messages"`
+	Usage    Usage            `json:"usage"`
+}
+
+type cachedLLMRepository struct {
+	llmRepository LLMRepository
+	cache         LLMCache
+	logger        *logging.ZaprLogger
+}
+
+// NewCachedLLMRepository answer the requests which are same as the previous ones from the cache, instead of paying for the same completions again.
+// The usage of a cached response has no tokens, and is marked as the CacheHit.
+func NewCachedLLMRepository(llmRepository LLMRepository, cache LLMCache, logger *logging.ZaprLogger) LLMRepository {
+	return &cachedLLMRepository{
+		llmRepository: llmRepository,
+		cache:         cache,
+		logger:        logger,
+	}
+}
+
+func (r *cachedLLMRepository) SummarizeRelativeChanges(ctx context.Context, input SummarizeRelativeChangesInput) (SummarizeRelativeChangesOutput, error) {
+	key := llmCacheKeyDto{Stage: stageSummarizeRelativeChanges, Model: input.Model, PromptVersions: input.PromptVersions, MaxOutputToken: input.MaxOutputToken, Messages: input.MessageContext}
+	messages, usage, err := r.complete(ctx, key, func() ([]domain.Message, Usage, error) {
+		output, err := r.llmRepository.SummarizeRelativeChanges(ctx, input)
+		return output.Messages, output.Usage, err
+	})
+	if err != nil {
+		return SummarizeRelativeChangesOutput{}, err
+	}
+	return SummarizeRelativeChangesOutput{Messages: messages, Usage: usage}, nil
+}
+
+func (r *cachedLLMRepository) SummarizeReleaseNote(ctx context.Context, input SummarizeReleaseNoteInput) (SummarizeReleaseNoteOutput, error) {
+	key := llmCacheKeyDto{Stage: stageSummarizeReleaseNote, Model: input.Model, PromptVersions: input.PromptVersions, MaxOutputToken: input.MaxOutputToken, Messages: input.MessageContext}
+	messages, usage, err := r.complete(ctx, key, func() ([]domain.Message, Usage, error) {
+		output, err := r.llmRepository.SummarizeReleaseNote(ctx, input)
+		return output.Messages, output.Usage, err
+	})
+	if err != nil {
+		return SummarizeReleaseNoteOutput{}, err
+	}
+	return SummarizeReleaseNoteOutput{Messages: messages, Usage: usage}, nil
+}
+
+func (r *cachedLLMRepository) ReviewRelativeChanges(ctx context.Context, input ReviewRelativeChangesInput) (ReviewRelativeChangesOutput, error) {
+	key := llmCacheKeyDto{Stage: stageReviewRelativeChanges, Model: input.Model, PromptVersions: input.PromptVersions, MaxOutputToken: input.MaxOutputToken, Messages: input.MessageContext, ResponseSchema: &input.ResponseSchema}
+	messages, usage, err := r.complete(ctx, key, func() ([]domain.Message, Usage, error) {
+		output, err := r.llmRepository.ReviewRelativeChanges(ctx, input)
+		return output.Messages, output.Usage, err
+	})
+	if err != nil {
+		return ReviewRelativeChangesOutput{}, err
+	}
+	return ReviewRelativeChangesOutput{Messages: messages, Usage: usage}, nil
+}
+
+// complete return the cached response of the key, or create the completion and cache it.
+// The cache only saves the tokens, so the completion is created as usual if the cache fails.
+func (r *cachedLLMRepository) complete(ctx context.Context, keyDto llmCacheKeyDto, createCompletion func() ([]domain.Message, Usage, error)) ([]domain.Message, Usage, error) {
+	key, err := hashLLMCacheKey(keyDto)
+	if err != nil {
+		return nil, Usage{}, err
+	}
+
+	value, ok, err := r.cache.Get(ctx, key)
+	if err != nil {
+		r.logger.WithError(err).Warn(fmt.Sprintf("Failed to get the cached response %s", key))
+	}
+	if ok {
+		var cached llmCacheValueDto
+		if err := json.Unmarshal(value, &cached); err == nil {
+			r.logger.WithValues("stage", keyDto.Stage, "model", keyDto.Model, "key", key).Info("LLM cache hit")
+			return cached.Messages, Usage{CacheHit: true}, nil
+		}
+		r.logger.Warn(fmt.Sprintf("Ignore the broken cached response %s", key))
+	}
+
+	messages, usage, err := createCompletion()
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	value, err = json.Marshal(llmCacheValueDto{Messages: messages, Usage: usage})
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	if err := r.cache.Set(ctx, key, value); err != nil {
+		r.logger.WithError(err).Warn(fmt.Sprintf("Failed to cache the response %s", key))
+	}
+	return messages, usage, nil
+}
+
+// hashLLMCacheKey hash the request into the hex of sha256, the keys of the JSON schema are sorted by encoding/json so the hash is stable
+func hashLLMCacheKey(keyDto llmCacheKeyDto) (string, error) {
+	content, err := json.Marshal(keyDto)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to marshal cache key")
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
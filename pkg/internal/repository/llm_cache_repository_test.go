@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"gitlab-mr-reviewer/pkg/logging"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// countingLLMRepository answers every completion with the count of the completions so far
+type countingLLMRepository struct {
+	count int
+}
+
+func (r *countingLLMRepository) complete() ([]domain.Message, Usage) {
+	r.count++
+	return []domain.Message{domain.NewAssistantMessage(string(rune('0' + r.count)))}, Usage{InputTokens: 100, OutputTokens: 10}
+}
+
+func (r *countingLLMRepository) SummarizeRelativeChanges(ctx context.Context, input SummarizeRelativeChangesInput) (SummarizeRelativeChangesOutput, error) {
+	messages, usage := r.complete()
+	return SummarizeRelativeChangesOutput{Messages: messages, Usage: usage}, nil
+}
+
+func (r *countingLLMRepository) SummarizeReleaseNote(ctx context.Context, input SummarizeReleaseNoteInput) (SummarizeReleaseNoteOutput, error) {
+	messages, usage := r.complete()
+	return SummarizeReleaseNoteOutput{Messages: messages, Usage: usage}, nil
+}
+
+func (r *countingLLMRepository) ReviewRelativeChanges(ctx context.Context, input ReviewRelativeChangesInput) (ReviewRelativeChangesOutput, error) {
+	messages, usage := r.complete()
+	return ReviewRelativeChangesOutput{Messages: messages, Usage: usage}, nil
+}
+
+var _ = ginkgo.Describe("CachedLLMRepository", func() {
+	var dir string
+	var llmRepository *countingLLMRepository
+	var r LLMRepository
+	input := SummarizeRelativeChangesInput{
+		CompletionInput: CompletionInput{
+			MessageContext: []domain.Message{{Role: domain.RoleSystem, Content: "You are a reviewer."}, domain.NewUserMessage("Summarize the diff.")},
+			MaxOutputToken: 1000,
+			Model:          "gpt-4o-mini",
+			PromptVersions: []string{"relative_changes@v2"},
+		},
+	}
+
+	ginkgo.BeforeEach(func() {
+		logger, err := logging.NewZaprLogger(false, "info")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		dir = ginkgo.GinkgoT().TempDir()
+		llmRepository = &countingLLMRepository{}
+		r = NewCachedLLMRepository(llmRepository, NewFileLLMCache(dir, time.Hour), logger)
+	})
+
+	ginkgo.It("Should answer the same request from the cache", func() {
+		output, err := r.SummarizeRelativeChanges(context.Background(), input)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(output.Usage).To(gomega.Equal(Usage{InputTokens: 100, OutputTokens: 10}))
+
+		cached, err := r.SummarizeRelativeChanges(context.Background(), input)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(cached.Messages).To(gomega.Equal(output.Messages))
+		gomega.Expect(cached.Usage).To(gomega.Equal(Usage{CacheHit: true}))
+		gomega.Expect(llmRepository.count).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("Should miss the cache if the model, the prompt versions, the messages or the stage differ", func() {
+		_, err := r.SummarizeRelativeChanges(context.Background(), input)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		otherModel := input
+		otherModel.Model = "gpt-4o"
+		otherVersions := input
+		otherVersions.PromptVersions = []string{"relative_changes@v3"}
+		otherMessages := input
+		otherMessages.MessageContext = []domain.Message{{Role: domain.RoleSystem, Content: "You are a reviewer."}, domain.NewUserMessage("Summarize the other diff.")}
+		for _, other := range []SummarizeRelativeChangesInput{otherModel, otherVersions, otherMessages} {
+			output, err := r.SummarizeRelativeChanges(context.Background(), other)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(output.Usage.CacheHit).To(gomega.BeFalse())
+		}
+		output, err := r.SummarizeReleaseNote(context.Background(), SummarizeReleaseNoteInput(input))
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(output.Usage.CacheHit).To(gomega.BeFalse())
+		gomega.Expect(llmRepository.count).To(gomega.Equal(5))
+	})
+
+	ginkgo.It("Should miss the expired response", func() {
+		_, err := r.SummarizeRelativeChanges(context.Background(), input)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		expired := time.Now().Add(-2 * time.Hour)
+		files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(files).To(gomega.HaveLen(1))
+		gomega.Expect(os.Chtimes(files[0], expired, expired)).To(gomega.Succeed())
+
+		output, err := r.SummarizeRelativeChanges(context.Background(), input)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(output.Usage.CacheHit).To(gomega.BeFalse())
+		gomega.Expect(llmRepository.count).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("Should ignore the broken response", func() {
+		_, err := r.SummarizeRelativeChanges(context.Background(), input)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(os.WriteFile(files[0], []byte("{"), 0o644)).To(gomega.Succeed())
+
+		output, err := r.SummarizeRelativeChanges(context.Background(), input)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(output.Usage.CacheHit).To(gomega.BeFalse())
+		gomega.Expect(llmRepository.count).To(gomega.Equal(2))
+	})
+})
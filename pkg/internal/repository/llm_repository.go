@@ -8,22 +8,54 @@ import (
 type LLMRepository interface {
 	SummarizeRelativeChanges(ctx context.Context, input SummarizeRelativeChangesInput) (SummarizeRelativeChangesOutput, error)
 	SummarizeReleaseNote(ctx context.Context, input SummarizeReleaseNoteInput) (SummarizeReleaseNoteOutput, error)
+	ReviewRelativeChanges(ctx context.Context, input ReviewRelativeChangesInput) (ReviewRelativeChangesOutput, error)
 }
 
-type SummarizeRelativeChangesInput struct {
+// CompletionInput is the messages and the model which every completion is created with
+type CompletionInput struct {
 	MessageContext []domain.Message
 	MaxOutputToken int64
 	Model          string
+	// PromptVersions are the versions of the prompt templates which the messages are rendered with
+	PromptVersions []string
+}
+
+type SummarizeRelativeChangesInput struct {
+	CompletionInput
 }
 type SummarizeRelativeChangesOutput struct {
 	Messages []domain.Message
+	Usage    Usage
 }
 
 type SummarizeReleaseNoteInput struct {
-	MessageContext []domain.Message
-	MaxOutputToken int64
-	Model          string
+	CompletionInput
 }
 type SummarizeReleaseNoteOutput struct {
 	Messages []domain.Message
+	Usage    Usage
+}
+
+type ReviewRelativeChangesInput struct {
+	CompletionInput
+	// ResponseSchema is the JSON schema which the response has to conform to
+	ResponseSchema ResponseSchema
+}
+type ReviewRelativeChangesOutput struct {
+	Messages []domain.Message
+	Usage    Usage
+}
+
+// ResponseSchema describes the structured response, the Schema is a JSON schema whose root is an object
+type ResponseSchema struct {
+	Name        string
+	Description string
+	Schema      map[string]any
+}
+
+// Usage is the tokens consumed by a completion, a cached response consumes no tokens
+type Usage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+	CacheHit     bool  `json:"cache_hit,omitempty"`
 }
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"time"
+)
+
+const (
+	// progressInterval is how often the progress of a streamed completion is printed
+	progressInterval = 5 * time.Second
+	// completionAttemptTimeout is the timeout of every attempt of a completion which isn't streamed
+	completionAttemptTimeout = 3 * time.Minute
+)
+
+// errIdleTimeout is the cause of canceling the stream which receives nothing within the idle timeout
+var errIdleTimeout = errors.New("Stream is idle")
+
+// StreamOption streams the completions, a streamed completion is canceled if it receives nothing within the IdleTimeout
+// instead of a fixed timeout, so that a long review isn't killed while it is being generated.
+// The progress of the completions is printed to the Progress if it isn't nil.
+type StreamOption struct {
+	Enabled     bool
+	IdleTimeout time.Duration
+	// FirstTokenTimeout is the idle timeout until the first delta, which the reasoning models take long to think before.
+	// It is the IdleTimeout if it is 0
+	FirstTokenTimeout time.Duration
+	Progress          io.Writer
+}
+
+// firstTokenTimeout return the timeout of waiting for the first delta
+func (o StreamOption) firstTokenTimeout() time.Duration {
+	if o.FirstTokenTimeout > 0 {
+		return o.FirstTokenTimeout
+	}
+	return o.IdleTimeout
+}
+
+// completionStream watch a streamed completion, it resets the idle timeout on every delta and prints the progress.
+// The idle timer starts with the first token timeout, since the request is sent until the first delta
+type completionStream struct {
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	option    StreamOption
+	label     string
+	idleTimer *time.Timer
+	startedAt time.Time
+	printedAt time.Time
+	received  int
+	// started is whether any delta is received, the idle timeout replaces the first token timeout after it
+	started bool
+}
+
+// newCompletionStream return the context of the streamed completion, which is canceled if the stream is idle
+func newCompletionStream(ctx context.Context, option StreamOption, model string, stage string) (context.Context, *completionStream) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	now := time.Now()
+	s := &completionStream{
+		ctx:       ctx,
+		cancel:    cancel,
+		option:    option,
+		label:     fmt.Sprintf("%s %s", model, stage),
+		startedAt: now,
+		printedAt: now,
+	}
+	s.idleTimer = time.AfterFunc(option.firstTokenTimeout(), func() { cancel(errIdleTimeout) })
+	return ctx, s
+}
+
+// receive reset the idle timeout, and print the characters received so far at most every progressInterval
+func (s *completionStream) receive(delta string) {
+	s.idleTimer.Reset(s.option.IdleTimeout)
+	s.started = true
+	s.received += len(delta)
+	if now := time.Now(); now.Sub(s.printedAt) >= progressInterval {
+		s.printedAt = now
+		s.print(fmt.Sprintf("%d characters received", s.received))
+	}
+}
+
+// done stop watching the stream, the error of the stream is replaced if it is canceled for being idle
+func (s *completionStream) done(err error) error {
+	s.idleTimer.Stop()
+	if err != nil && errors.Is(context.Cause(s.ctx), errIdleTimeout) {
+		if s.started {
+			err = errors.Wrapf(errIdleTimeout, "Nothing is received for %s", s.option.IdleTimeout)
+		} else {
+			err = errors.Wrapf(errIdleTimeout, "The first token isn't received for %s", s.option.firstTokenTimeout())
+		}
+	}
+	s.cancel(nil)
+
+	if err != nil {
+		s.print("failed")
+		return err
+	}
+	s.print(fmt.Sprintf("done, %d characters received", s.received))
+	return nil
+}
+
+func (s *completionStream) print(message string) {
+	if s.option.Progress == nil {
+		return
+	}
+	fmt.Fprintf(s.option.Progress, "%s: %s in %s\n", s.label, message, time.Since(s.startedAt).Round(time.Second))
+}
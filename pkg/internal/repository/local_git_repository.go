@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// LocalProjectId and LocalMergeRequestId are the placeholder ids of the single merge request of the local git repository
+	LocalProjectId      int32 = 1
+	LocalMergeRequestId int32 = 1
+
+	gitLogFieldSep  = "\x00"
+	gitLogRecordSep = "\x1e"
+)
+
+type localGitRepository struct {
+	logger  *logging.ZaprLogger
+	workDir string
+	base    string
+	head    string
+	writer  io.Writer
+}
+
+// NewLocalGitRepository read the merge request from `git diff <base>...<head>` of the working copy, and write the review to the writer instead of posting it
+func NewLocalGitRepository(logger *logging.ZaprLogger, workDir, base, head string, writer io.Writer) CodeHostRepository {
+	return &localGitRepository{
+		logger:  logger,
+		workDir: workDir,
+		base:    base,
+		head:    head,
+		writer:  writer,
+	}
+}
+
+// GetMergeRequest take the title from the subject of the first commit, and the description from the messages of all the commits
+func (r *localGitRepository) GetMergeRequest(ctx context.Context, projectId, mergeRequestId int32) (*MergeRequestDto, error) {
+	baseSha, err := r.git(ctx, "merge-base", r.base, r.head)
+	if err != nil {
+		return nil, err
+	}
+	headSha, err := r.git(ctx, "rev-parse", "--verify", r.head+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	commits, err := r.listCommits(ctx, baseSha, headSha)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, errors.Errorf("There is no commit between %s and %s", r.base, r.head)
+	}
+
+	title := commits[0].Title
+	var descriptions []string
+	for _, commit := range commits {
+		descriptions = append(descriptions, strings.TrimSpace(commit.Message))
+	}
+	author, err := r.git(ctx, "log", "-1", "--format=%an", headSha)
+	if err != nil {
+		return nil, err
+	}
+	r.logger.Info(fmt.Sprintf("Review %d commits of %s...%s", len(commits), r.base, r.head))
+
+	return &MergeRequestDto{
+		ProjectId:   projectId,
+		Id:          mergeRequestId,
+		Title:       title,
+		Description: strings.Join(descriptions, "\n\n"),
+		Author:      AuthorDto{Username: author},
+		DiffRefs: DiffRefsDto{
+			BaseSha:  baseSha,
+			StartSha: baseSha,
+			HeadSha:  headSha,
+		},
+	}, nil
+}
+
+func (r *localGitRepository) ListMergeRequestCommits(ctx context.Context, projectId, mergeRequestId int32) ([]CommitDto, error) {
+	return r.listCommits(ctx, r.base, r.head)
+}
+
+func (r *localGitRepository) ListDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error) {
+	return r.diff(ctx, r.base+"..."+r.head)
+}
+
+func (r *localGitRepository) ListRawDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]DiffDto, error) {
+	return r.diff(ctx, r.base+"..."+r.head)
+}
+
+func (r *localGitRepository) CompareCommits(ctx context.Context, projectId int32, from, to string) (*CompareDto, error) {
+	diffs, err := r.diff(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := r.listCommits(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return &CompareDto{Commits: commits, Diffs: diffs, CompareSameRef: from == to}, nil
+}
+
+func (r *localGitRepository) ListComparedCommits(ctx context.Context, projectId int32, from, to string) ([]CommitDto, error) {
+	return r.listCommits(ctx, from, to)
+}
+
+// GetFile read the file at the ref of the working copy
+func (r *localGitRepository) GetFile(ctx context.Context, projectId int32, path, ref string) ([]byte, error) {
+	// ls-tree lists nothing instead of failing if the file doesn't exist at the ref
+	entry, err := r.git(ctx, "ls-tree", "--name-only", ref, "--", path)
+	if err != nil {
+		return nil, err
+	}
+	if len(entry) == 0 {
+		return nil, errors.Wrapf(retry.ErrorNotFound, "%s doesn't exist at %s", path, ref)
+	}
+	output, err := r.runGit(ctx, "show", ref+":"+path)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(output), nil
+}
+
+func (r *localGitRepository) CreateMergeRequestSummary(ctx context.Context, input CreateMergeRequestSummaryInput) error {
+	_, err := fmt.Fprintln(r.writer, renderLocalReview(input))
+	return err
+}
+
+func (r *localGitRepository) CreateMergeRequestDiscussion(ctx context.Context, input CreateMergeRequestDiscussionInput) error {
+	_, err := fmt.Fprintf(r.writer, "### %s:%d\n\n%s\n\n", input.Position.NewPath, input.Position.NewLine, input.Body)
+	return err
+}
+
+func (r *localGitRepository) CreateMergeRequestDiscussions(ctx context.Context, inputs []CreateMergeRequestDiscussionInput) error {
+	return createMergeRequestDiscussions(ctx, r, r.logger, inputs)
+}
+
+// ListMergeRequestNotes return no note, since the local review is never posted
+func (r *localGitRepository) ListMergeRequestNotes(ctx context.Context, projectId, mergeRequestId int32) ([]NoteDto, error) {
+	return nil, nil
+}
+
+func (r *localGitRepository) UpdateMergeRequestNote(ctx context.Context, projectId, mergeRequestId int32, noteId int64, body string) error {
+	return nil
+}
+
+// GetReviewedHeadSha return empty, so that the local review always reviews the whole diff
+func (r *localGitRepository) GetReviewedHeadSha(ctx context.Context, projectId, mergeRequestId int32) (string, error) {
+	return "", nil
+}
+
+// listCommits list the commits from the oldest, the same as Gitlab, while git log lists the latest commit first without --reverse
+func (r *localGitRepository) listCommits(ctx context.Context, from, to string) ([]CommitDto, error) {
+	output, err := r.git(ctx, "log", "--reverse", "--format=%H%x00%h%x00%s%x00%B%x1e", from+".."+to)
+	if err != nil {
+		return nil, err
+	}
+	var commits []CommitDto
+	for _, record := range strings.Split(output, gitLogRecordSep) {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), gitLogFieldSep, 4)
+		if len(fields) < 4 {
+			continue
+		}
+		commits = append(commits, CommitDto{Id: fields[0], ShortId: fields[1], Title: fields[2], Message: fields[3]})
+	}
+	return commits, nil
+}
+
+func (r *localGitRepository) diff(ctx context.Context, revisions ...string) ([]DiffDto, error) {
+	args := append([]string{"-c", "core.quotePath=false", "diff", "--no-color", "--no-ext-diff", "--find-renames", "--src-prefix=a/", "--dst-prefix=b/"}, revisions...)
+	output, err := r.runGit(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseRawDiff(output), nil
+}
+
+// git run the git command and return its trimmed output
+func (r *localGitRepository) git(ctx context.Context, args ...string) (string, error) {
+	output, err := r.runGit(ctx, args...)
+	return strings.TrimSpace(output), err
+}
+
+func (r *localGitRepository) runGit(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.workDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "Failed to run git %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// renderLocalReview render the summary for the terminal, without the markers of the Gitlab note
+func renderLocalReview(input CreateMergeRequestSummaryInput) string {
+	builder := strings.Builder{}
+	builder.WriteString(input.RelativeChangeNote)
+	builder.WriteString("\n\n---\n")
+	if len(input.FindingNote) > 0 {
+		builder.WriteString(input.FindingNote)
+		builder.WriteString("\n---\n")
+	}
+	if len(input.SummaryNote) > 0 {
+		builder.WriteString(input.SummaryNote)
+		builder.WriteString("\n")
+	}
+	if len(input.OmittedChanges) > 0 {
+		builder.WriteString("\n---\n### Files not reviewed\n")
+		for _, change := range input.OmittedChanges {
+			builder.WriteString(fmt.Sprintf("- `%s`: %s\n", change.Path, change.Reason))
+		}
+	}
+	if len(input.NonConventionalCommits) > 0 {
+		builder.WriteString("\n---\n")
+		builder.WriteString(renderNonConventionalCommits(input.NonConventionalCommits))
+	}
+	if input.Usage != nil {
+		builder.WriteString("\n")
+		builder.WriteString(renderUsage(*input.Usage))
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
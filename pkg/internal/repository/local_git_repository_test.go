@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+var _ = ginkgo.Describe("LocalGitRepository", ginkgo.Ordered, func() {
+	var workDir string
+	var output *bytes.Buffer
+	var r CodeHostRepository
+
+	runGit := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = workDir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=tester", "GIT_AUTHOR_EMAIL=tester@example.com", "GIT_COMMITTER_NAME=tester", "GIT_COMMITTER_EMAIL=tester@example.com")
+		out, err := cmd.CombinedOutput()
+		gomega.Expect(err).ToNot(gomega.HaveOccurred(), string(out))
+	}
+	writeFile := func(path, content string) {
+		gomega.Expect(os.WriteFile(filepath.Join(workDir, path), []byte(content), 0o644)).To(gomega.Succeed())
+	}
+
+	ginkgo.BeforeAll(func() {
+		logger, err := logging.NewZaprLogger(false, "info")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		workDir = ginkgo.GinkgoT().TempDir()
+		runGit("init", "--quiet", "--initial-branch=main")
+		writeFile("main.go", "package main\n\nfunc main() {\n}\n")
+		writeFile("old.go", "package main\n")
+		writeFile("removed.go", "package main\n")
+		runGit("add", "-A")
+		runGit("commit", "--quiet", "-m", "Initial commit")
+
+		runGit("checkout", "--quiet", "-b", "feature")
+		writeFile("main.go", "package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n")
+		writeFile("added.go", "package main\n\nvar added = 1\n")
+		runGit("mv", "old.go", "renamed.go")
+		runGit("rm", "--quiet", "removed.go")
+		runGit("add", "-A")
+		runGit("commit", "--quiet", "-m", "Print hello", "-m", "The main prints hello.")
+		writeFile("added.go", "package main\n\nvar added = 2\n")
+		runGit("commit", "--quiet", "-am", "Change added")
+
+		output = &bytes.Buffer{}
+		r = NewLocalGitRepository(logger, workDir, "main", "feature", output)
+	})
+
+	ginkgo.It("Should take the title and the description from the commits", func() {
+		mergeRequest, err := r.GetMergeRequest(context.Background(), LocalProjectId, LocalMergeRequestId)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(mergeRequest.Id).To(gomega.Equal(LocalMergeRequestId))
+		gomega.Expect(mergeRequest.Title).To(gomega.Equal("Print hello"))
+		gomega.Expect(mergeRequest.Description).To(gomega.Equal("Print hello\n\nThe main prints hello.\n\nChange added"))
+		gomega.Expect(mergeRequest.DiffRefs.BaseSha).ToNot(gomega.BeEmpty())
+		gomega.Expect(mergeRequest.DiffRefs.HeadSha).ToNot(gomega.Equal(mergeRequest.DiffRefs.BaseSha))
+	})
+
+	ginkgo.It("Should list the diffs of the files", func() {
+		diffs, err := r.ListDiffByMergeRequestId(context.Background(), LocalProjectId, LocalMergeRequestId)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(diffs).To(gomega.HaveLen(4))
+
+		byPath := map[string]DiffDto{}
+		for _, diff := range diffs {
+			byPath[diff.NewPath] = diff
+		}
+		gomega.Expect(byPath["added.go"].NewFile).To(gomega.BeTrue())
+		gomega.Expect(byPath["added.go"].Diff).To(gomega.Equal("@@ -0,0 +1,3 @@\n+package main\n+\n+var added = 2\n"))
+		gomega.Expect(byPath["main.go"].Diff).To(gomega.HavePrefix("@@ -1,4 +1,5 @@\n"))
+		gomega.Expect(byPath["main.go"].Diff).To(gomega.ContainSubstring("+\tprintln(\"hello\")\n"))
+		gomega.Expect(byPath["removed.go"].DeletedFile).To(gomega.BeTrue())
+		gomega.Expect(byPath["renamed.go"].RenameFile).To(gomega.BeTrue())
+		gomega.Expect(byPath["renamed.go"].OldPath).To(gomega.Equal("old.go"))
+		gomega.Expect(byPath["renamed.go"].Diff).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("Should list the commits, oldest first", func() {
+		commits, err := r.ListMergeRequestCommits(context.Background(), LocalProjectId, LocalMergeRequestId)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(commits).To(gomega.HaveLen(2))
+		gomega.Expect(commits[0].Title).To(gomega.Equal("Print hello"))
+		gomega.Expect(commits[0].Message).To(gomega.ContainSubstring("The main prints hello."))
+		gomega.Expect(commits[1].Title).To(gomega.Equal("Change added"))
+	})
+
+	ginkgo.It("Should read the file at the ref", func() {
+		content, err := r.GetFile(context.Background(), LocalProjectId, "added.go", "feature")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(string(content)).To(gomega.Equal("package main\n\nvar added = 2\n"))
+
+		_, err = r.GetFile(context.Background(), LocalProjectId, "added.go", "main")
+		gomega.Expect(errors.Is(err, retry.ErrorNotFound)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should fail with an unknown revision", func() {
+		_, err := NewLocalGitRepository(nil, workDir, "unknown", "feature", output).GetMergeRequest(context.Background(), LocalProjectId, LocalMergeRequestId)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("Should write the review to the writer", func() {
+		ctx := context.Background()
+		err := r.CreateMergeRequestSummary(ctx, CreateMergeRequestSummaryInput{
+			RelativeChangeNote: "relative change note",
+			SummaryNote:        "summary note",
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		err = r.CreateMergeRequestDiscussion(ctx, CreateMergeRequestDiscussionInput{Body: "comment", Position: PositionDto{NewPath: "main.go", NewLine: 4}})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		gomega.Expect(output.String()).To(gomega.ContainSubstring("relative change note"))
+		gomega.Expect(output.String()).To(gomega.ContainSubstring("summary note"))
+		gomega.Expect(output.String()).To(gomega.ContainSubstring("### main.go:4\n\ncomment"))
+		gomega.Expect(output.String()).ToNot(gomega.ContainSubstring("<!--"))
+	})
+})
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metricsLLMRepository struct {
+	llmRepository LLMRepository
+	tokens        *prometheus.CounterVec
+}
+
+// NewMetricsLLMRepository count the input and output tokens of every completion by the model and the stage
+func NewMetricsLLMRepository(llmRepository LLMRepository, tokens *prometheus.CounterVec) LLMRepository {
+	return &metricsLLMRepository{
+		llmRepository: llmRepository,
+		tokens:        tokens,
+	}
+}
+
+func (r *metricsLLMRepository) SummarizeRelativeChanges(ctx context.Context, input SummarizeRelativeChangesInput) (SummarizeRelativeChangesOutput, error) {
+	output, err := r.llmRepository.SummarizeRelativeChanges(ctx, input)
+	if err == nil {
+		r.count(input.Model, stageSummarizeRelativeChanges, output.Usage)
+	}
+	return output, err
+}
+
+func (r *metricsLLMRepository) SummarizeReleaseNote(ctx context.Context, input SummarizeReleaseNoteInput) (SummarizeReleaseNoteOutput, error) {
+	output, err := r.llmRepository.SummarizeReleaseNote(ctx, input)
+	if err == nil {
+		r.count(input.Model, stageSummarizeReleaseNote, output.Usage)
+	}
+	return output, err
+}
+
+func (r *metricsLLMRepository) ReviewRelativeChanges(ctx context.Context, input ReviewRelativeChangesInput) (ReviewRelativeChangesOutput, error) {
+	output, err := r.llmRepository.ReviewRelativeChanges(ctx, input)
+	if err == nil {
+		r.count(input.Model, stageReviewRelativeChanges, output.Usage)
+	}
+	return output, err
+}
+
+func (r *metricsLLMRepository) count(model string, stage string, usage Usage) {
+	r.tokens.WithLabelValues(model, stage, "input").Add(float64(usage.InputTokens))
+	r.tokens.WithLabelValues(model, stage, "output").Add(float64(usage.OutputTokens))
+}
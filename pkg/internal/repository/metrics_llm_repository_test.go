@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"gitlab-mr-reviewer/pkg/metrics"
+)
+
+var _ = ginkgo.Describe("MetricsLLMRepository", func() {
+	ginkgo.It("Should count the tokens by the model and the stage", func() {
+		reviewerMetrics := metrics.NewReviewerMetrics()
+		r := NewMetricsLLMRepository(&countingLLMRepository{}, reviewerMetrics.Tokens)
+		_, err := r.ReviewRelativeChanges(context.Background(), ReviewRelativeChangesInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{domain.NewUserMessage("Review the changes.")},
+				MaxOutputToken: 1000,
+				Model:          "gpt-4o-mini",
+			},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		gomega.Expect(testutil.ToFloat64(reviewerMetrics.Tokens.WithLabelValues("gpt-4o-mini", stageReviewRelativeChanges, "input"))).To(gomega.Equal(100.0))
+		gomega.Expect(testutil.ToFloat64(reviewerMetrics.Tokens.WithLabelValues("gpt-4o-mini", stageReviewRelativeChanges, "output"))).To(gomega.Equal(10.0))
+	})
+})
@@ -8,121 +8,157 @@ import (
 	"github.com/pkg/errors"
 	"gitlab-mr-reviewer/pkg/internal/domain"
 	"gitlab-mr-reviewer/pkg/logging"
-	"time"
+	"gitlab-mr-reviewer/pkg/retry"
+	"net/http"
+	"strings"
 )
 
 type openaiRepository struct {
-	client *openai.Client
-	logger *logging.ZaprLogger
+	client       *openai.Client
+	logger       *logging.ZaprLogger
+	streamOption StreamOption
 }
 
-func NewOpenaiRepository(logger *logging.ZaprLogger, apiKey string) LLMRepository {
-	client := openai.NewClient(
-		option.WithAPIKey(apiKey),                 // defaults to os.LookupEnv("OPENAI_API_KEY")
-		option.WithRequestTimeout(60*time.Second), // set default timeout
-	)
+// NewOpenaiRepository create the repository of OpenAI, or any OpenAI-compatible API such as Ollama, vLLM and LM Studio with the baseUrl.
+// The apiKey is optional for the local servers which don't require authorization.
+func NewOpenaiRepository(logger *logging.ZaprLogger, httpClient *http.Client, baseUrl string, apiKey string, streamOption StreamOption) LLMRepository {
+	options := []option.RequestOption{
+		option.WithHTTPClient(httpClient),
+		option.WithMaxRetries(0), // retried by the http client
+	}
+	if len(baseUrl) > 0 {
+		// the request paths are resolved relative to the base url, e.g. http://localhost:11434/v1/ + chat/completions
+		options = append(options, option.WithBaseURL(strings.TrimSuffix(baseUrl, "/")+"/"))
+	}
+	if len(apiKey) > 0 {
+		options = append(options, option.WithAPIKey(apiKey)) // defaults to os.LookupEnv("OPENAI_API_KEY")
+	}
+	client := openai.NewClient(options...)
 
 	return &openaiRepository{
-		client: client,
-		logger: logger,
+		client:       client,
+		logger:       logger,
+		streamOption: streamOption,
 	}
 }
 
 func (r *openaiRepository) SummarizeRelativeChanges(ctx context.Context, input SummarizeRelativeChangesInput) (SummarizeRelativeChangesOutput, error) {
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
-	defer cancel()
-
-	openaiMessages := make([]openai.ChatCompletionMessageParamUnion, len(input.MessageContext))
-	for i, message := range input.MessageContext {
-		msg, err := toChatCompletionMessage(message)
-		if err != nil {
-			return SummarizeRelativeChangesOutput{}, err
-		}
-		openaiMessages[i] = msg
-	}
-
-	resp, err := r.client.Chat.Completions.New(
-		ctx,
-		openai.ChatCompletionNewParams{
-			Messages:            openai.F(openaiMessages),
-			Model:               openai.F(input.Model),
-			MaxCompletionTokens: openai.Int(input.MaxOutputToken),
-		},
-	)
+	messages, usage, err := r.createChatCompletion(ctx, stageSummarizeRelativeChanges, input.MessageContext, input.Model, input.MaxOutputToken, nil)
 	if err != nil {
 		return SummarizeRelativeChangesOutput{}, err
 	}
+	return SummarizeRelativeChangesOutput{Messages: messages, Usage: usage}, nil
+}
 
-	output := SummarizeRelativeChangesOutput{
-		Messages: make([]domain.Message, len(resp.Choices)),
-	}
-
-	if len(resp.Choices) == 0 {
-		return output, errors.New("response has no choices")
+func (r *openaiRepository) SummarizeReleaseNote(ctx context.Context, input SummarizeReleaseNoteInput) (SummarizeReleaseNoteOutput, error) {
+	messages, usage, err := r.createChatCompletion(ctx, stageSummarizeReleaseNote, input.MessageContext, input.Model, input.MaxOutputToken, nil)
+	if err != nil {
+		return SummarizeReleaseNoteOutput{}, err
 	}
+	return SummarizeReleaseNoteOutput{Messages: messages, Usage: usage}, nil
+}
 
-	r.logger.Info(fmt.Sprintf("Response Model: %s", resp.Model))
-	r.logger.Info(fmt.Sprintf("Response TotalTokens: %d", resp.Usage.TotalTokens))
-	r.logger.Info(fmt.Sprintf("Response PromptTokens: %d", resp.Usage.PromptTokens))
-	r.logger.Info(fmt.Sprintf("Response CompletionTokens: %d", resp.Usage.CompletionTokens))
-	for i, choice := range resp.Choices {
-		r.logger.Info(fmt.Sprintf("Received response role: %s", choice.Message.Role))
-		r.logger.Info(fmt.Sprintf("Received response choice: %s", choice.Message.Content))
-		//r.logger.Info(fmt.Sprintf("Received response json: %#v", choice.Message.JSON))
-
-		output.Messages[i] = toDomainMessage(choice.Message)
+func (r *openaiRepository) ReviewRelativeChanges(ctx context.Context, input ReviewRelativeChangesInput) (ReviewRelativeChangesOutput, error) {
+	messages, usage, err := r.createChatCompletion(ctx, stageReviewRelativeChanges, input.MessageContext, input.Model, input.MaxOutputToken, &input.ResponseSchema)
+	if err != nil {
+		return ReviewRelativeChangesOutput{}, err
 	}
-
-	return output, nil
+	return ReviewRelativeChangesOutput{Messages: messages, Usage: usage}, nil
 }
 
-func (r *openaiRepository) SummarizeReleaseNote(ctx context.Context, input SummarizeReleaseNoteInput) (SummarizeReleaseNoteOutput, error) {
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
-	defer cancel()
-
-	openaiMessages := make([]openai.ChatCompletionMessageParamUnion, len(input.MessageContext))
-	for i, message := range input.MessageContext {
+// createChatCompletion create the chat completion, the response conforms to the responseSchema with Structured Outputs if it is given
+func (r *openaiRepository) createChatCompletion(ctx context.Context, stage string, messageContext []domain.Message, model string, maxOutputToken int64, responseSchema *ResponseSchema) ([]domain.Message, Usage, error) {
+	openaiMessages := make([]openai.ChatCompletionMessageParamUnion, len(messageContext))
+	for i, message := range messageContext {
 		msg, err := toChatCompletionMessage(message)
 		if err != nil {
-			return SummarizeReleaseNoteOutput{}, err
+			return nil, Usage{}, err
 		}
 		openaiMessages[i] = msg
 	}
 
-	resp, err := r.client.Chat.Completions.New(
-		ctx,
-		openai.ChatCompletionNewParams{
-			Messages:            openai.F(openaiMessages),
-			Model:               openai.F(input.Model),
-			MaxCompletionTokens: openai.Int(input.MaxOutputToken),
-		},
-	)
-	if err != nil {
-		return SummarizeReleaseNoteOutput{}, err
+	params := openai.ChatCompletionNewParams{
+		Messages:            openai.F(openaiMessages),
+		Model:               openai.F(model),
+		MaxCompletionTokens: openai.Int(maxOutputToken),
+	}
+	if responseSchema != nil {
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](openai.ResponseFormatJSONSchemaParam{
+			Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:        openai.F(responseSchema.Name),
+				Description: openai.F(responseSchema.Description),
+				Schema:      openai.F[any](responseSchema.Schema),
+				Strict:      openai.Bool(true),
+			}),
+		})
 	}
 
-	output := SummarizeReleaseNoteOutput{
-		Messages: make([]domain.Message, len(resp.Choices)),
+	var resp *openai.ChatCompletion
+	var err error
+	if r.streamOption.Enabled {
+		resp, err = r.streamChatCompletion(ctx, stage, params)
+	} else {
+		resp, err = r.newChatCompletion(ctx, params)
+	}
+	if err != nil {
+		var apiError *openai.Error
+		if errors.As(err, &apiError) {
+			return nil, Usage{}, errors.Wrap(retry.NewStatusError(apiError.StatusCode, apiError.Message), "Failed to create chat completion")
+		}
+		return nil, Usage{}, err
 	}
 
 	if len(resp.Choices) == 0 {
-		return output, errors.New("response has no choices")
+		return nil, Usage{}, errors.New("response has no choices")
 	}
 
 	r.logger.Info(fmt.Sprintf("Response Model: %s", resp.Model))
 	r.logger.Info(fmt.Sprintf("Response TotalTokens: %d", resp.Usage.TotalTokens))
 	r.logger.Info(fmt.Sprintf("Response PromptTokens: %d", resp.Usage.PromptTokens))
 	r.logger.Info(fmt.Sprintf("Response CompletionTokens: %d", resp.Usage.CompletionTokens))
+	messages := make([]domain.Message, len(resp.Choices))
 	for i, choice := range resp.Choices {
 		r.logger.Info(fmt.Sprintf("Received response role: %s", choice.Message.Role))
 		r.logger.Info(fmt.Sprintf("Received response choice: %s", choice.Message.Content))
-		//r.logger.Info(fmt.Sprintf("Received response json: %#v", choice.Message.JSON))
 
-		output.Messages[i] = toDomainMessage(choice.Message)
+		messages[i] = toDomainMessage(choice.Message)
 	}
 
-	return output, nil
+	usage := Usage{InputTokens: resp.Usage.PromptTokens, OutputTokens: resp.Usage.CompletionTokens}
+	return messages, usage, nil
+}
+
+func (r *openaiRepository) newChatCompletion(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	return r.client.Chat.Completions.New(retry.WithAttemptTimeout(ctx, completionAttemptTimeout), params)
+}
 
+// streamChatCompletion assemble the deltas of the streamed chat completion, along with the usage which is sent at the end of the stream
+func (r *openaiRepository) streamChatCompletion(ctx context.Context, stage string, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	ctx, completionStream := newCompletionStream(ctx, r.streamOption, params.Model.Value, stage)
+	params.StreamOptions = openai.F(openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)})
+
+	stream := r.client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+	acc := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		chunk := stream.Current()
+		if !acc.AddChunk(chunk) {
+			return nil, completionStream.done(errors.Errorf("Failed to assemble the chunk %s of the stream", chunk.ID))
+		}
+		for _, choice := range chunk.Choices {
+			completionStream.receive(choice.Delta.Content)
+		}
+	}
+	// the stream ends without any error if the response is canceled while it is read, see ssestream.eventStreamDecoder
+	err := stream.Err()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err := completionStream.done(err); err != nil {
+		return nil, err
+	}
+	return &acc.ChatCompletion, nil
 }
 
 func toChatCompletionMessage(message domain.Message) (openai.ChatCompletionMessageParamUnion, error) {
@@ -1,18 +1,92 @@
 package repository
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
+	"gitlab-mr-reviewer/pkg/internal/domain"
 	"gitlab-mr-reviewer/pkg/logging"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
 )
 
+type mockChatCompletionRequest struct {
+	Authorization  string
+	Model          string `json:"model"`
+	Stream         bool   `json:"stream"`
+	ResponseFormat struct {
+		Type       string `json:"type"`
+		JSONSchema struct {
+			Name   string         `json:"name"`
+			Schema map[string]any `json:"schema"`
+			Strict bool           `json:"strict"`
+		} `json:"json_schema"`
+	} `json:"response_format"`
+}
+
+// runMockOpenaiCompatibleServer stands in for a local OpenAI-compatible server such as Ollama, which serves under /v1
+func runMockOpenaiCompatibleServer(requests chan<- mockChatCompletionRequest) *httptest.Server {
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("POST /v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		var request mockChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		request.Authorization = r.Header.Get("Authorization")
+		requests <- request
+
+		if request.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			if request.Model == "thinking-model" {
+				// the model thinks before the first delta for longer than the idle timeout
+				time.Sleep(300 * time.Millisecond)
+			}
+			w.Write([]byte(`data: {"id": "chatcmpl-1", "object": "chat.completion.chunk", "model": "qwen2.5-coder:32b", "choices": [{"index": 0, "delta": {"role": "assistant", "content": "The change "}}]}` + "\n\n"))
+			w.(http.Flusher).Flush()
+			if request.Model == "stuck-model" {
+				// the stream stays open without any delta until the client gives up
+				<-r.Context().Done()
+				return
+			}
+			w.Write([]byte(`data: {"id": "chatcmpl-1", "object": "chat.completion.chunk", "model": "qwen2.5-coder:32b", "choices": [{"index": 0, "delta": {"content": "looks good."}, "finish_reason": "stop"}]}` + "\n\n"))
+			w.Write([]byte(`data: {"id": "chatcmpl-1", "object": "chat.completion.chunk", "model": "qwen2.5-coder:32b", "choices": [], "usage": {"prompt_tokens": 20, "completion_tokens": 5, "total_tokens": 25}}` + "\n\n"))
+			w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"id": "chatcmpl-1",
+			"object": "chat.completion",
+			"model": "qwen2.5-coder:32b",
+			"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "The change looks good."}}],
+			"usage": {"prompt_tokens": 20, "completion_tokens": 5, "total_tokens": 25}
+		}`))
+	})
+	return httptest.NewServer(serveMux)
+}
+
 var _ = ginkgo.Describe("Openai Repository", ginkgo.Ordered, func() {
 	var logger *logging.ZaprLogger
+	var testServer *httptest.Server
+	var requests chan mockChatCompletionRequest
 
 	ginkgo.BeforeAll(func() {
 		var err error
 		logger, err = logging.NewZaprLogger(false, "info")
 		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		requests = make(chan mockChatCompletionRequest, 10)
+		testServer = runMockOpenaiCompatibleServer(requests)
+	})
+
+	ginkgo.AfterAll(func() {
+		testServer.Close()
 	})
 
 	ginkgo.It("Always success", func() {
@@ -20,4 +94,109 @@ var _ = ginkgo.Describe("Openai Repository", ginkgo.Ordered, func() {
 		gomega.Expect(logger).ToNot(gomega.BeNil())
 	})
 
+	ginkgo.It("Should call the OpenAI-compatible server without authorization", func() {
+		// the client defaults to the OPENAI_API_KEY environment variable
+		ginkgo.GinkgoT().Setenv("OPENAI_API_KEY", "")
+		gomega.Expect(os.Unsetenv("OPENAI_API_KEY")).To(gomega.Succeed())
+
+		r := NewOpenaiRepository(logger, &http.Client{}, testServer.URL+"/v1", "", StreamOption{})
+		output, err := r.SummarizeRelativeChanges(context.Background(), SummarizeRelativeChangesInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{domain.NewUserMessage("Summarize the changes.")},
+				MaxOutputToken: 1000,
+				Model:          "qwen2.5-coder:32b",
+			},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(output.Messages).To(gomega.Equal([]domain.Message{domain.NewAssistantMessage("The change looks good.")}))
+
+		var request mockChatCompletionRequest
+		gomega.Eventually(requests).Should(gomega.Receive(&request))
+		gomega.Expect(request.Model).To(gomega.Equal("qwen2.5-coder:32b"))
+		gomega.Expect(request.Authorization).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("Should call the OpenAI-compatible server with authorization", func() {
+		r := NewOpenaiRepository(logger, &http.Client{}, testServer.URL+"/v1/", "fake-api-key", StreamOption{})
+		_, err := r.SummarizeReleaseNote(context.Background(), SummarizeReleaseNoteInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{domain.NewUserMessage("Write the release note.")},
+				MaxOutputToken: 1000,
+				Model:          "llama3.1",
+			},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		var request mockChatCompletionRequest
+		gomega.Eventually(requests).Should(gomega.Receive(&request))
+		gomega.Expect(request.Model).To(gomega.Equal("llama3.1"))
+		gomega.Expect(request.Authorization).To(gomega.Equal("Bearer fake-api-key"))
+	})
+
+	ginkgo.It("Should request the structured outputs of the response schema", func() {
+		r := NewOpenaiRepository(logger, &http.Client{}, testServer.URL+"/v1", "fake-api-key", StreamOption{})
+		_, err := r.ReviewRelativeChanges(context.Background(), ReviewRelativeChangesInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{domain.NewUserMessage("Review the changes.")},
+				MaxOutputToken: 1000,
+				Model:          "gpt-4o-mini",
+			},
+			ResponseSchema: ResponseSchema{Name: "report_findings", Schema: map[string]any{"type": "object"}},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		var request mockChatCompletionRequest
+		gomega.Eventually(requests).Should(gomega.Receive(&request))
+		gomega.Expect(request.ResponseFormat.Type).To(gomega.Equal("json_schema"))
+		gomega.Expect(request.ResponseFormat.JSONSchema.Name).To(gomega.Equal("report_findings"))
+		gomega.Expect(request.ResponseFormat.JSONSchema.Schema).To(gomega.Equal(map[string]any{"type": "object"}))
+		gomega.Expect(request.ResponseFormat.JSONSchema.Strict).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should assemble the streamed chat completion", func() {
+		progress := &bytes.Buffer{}
+		r := NewOpenaiRepository(logger, &http.Client{}, testServer.URL+"/v1", "fake-api-key", StreamOption{Enabled: true, IdleTimeout: time.Second, Progress: progress})
+		output, err := r.SummarizeRelativeChanges(context.Background(), SummarizeRelativeChangesInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{domain.NewUserMessage("Summarize the changes.")},
+				MaxOutputToken: 1000,
+				Model:          "qwen2.5-coder:32b",
+			},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(output.Messages).To(gomega.Equal([]domain.Message{domain.NewAssistantMessage("The change looks good.")}))
+		gomega.Expect(output.Usage).To(gomega.Equal(Usage{InputTokens: 20, OutputTokens: 5}))
+		gomega.Expect(progress.String()).To(gomega.ContainSubstring("qwen2.5-coder:32b summarize_relative_changes: done, 22 characters received"))
+
+		var request mockChatCompletionRequest
+		gomega.Eventually(requests).Should(gomega.Receive(&request))
+		gomega.Expect(request.Stream).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should cancel the stream which is idle", func() {
+		r := NewOpenaiRepository(logger, &http.Client{}, testServer.URL+"/v1", "fake-api-key", StreamOption{Enabled: true, IdleTimeout: 100 * time.Millisecond})
+		_, err := r.SummarizeRelativeChanges(context.Background(), SummarizeRelativeChangesInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{domain.NewUserMessage("Summarize the changes.")},
+				MaxOutputToken: 1000,
+				Model:          "stuck-model",
+			},
+		})
+		gomega.Expect(err).To(gomega.MatchError(errIdleTimeout))
+		gomega.Eventually(requests).Should(gomega.Receive())
+	})
+
+	ginkgo.It("Should wait for the first token longer than the idle timeout", func() {
+		r := NewOpenaiRepository(logger, &http.Client{}, testServer.URL+"/v1", "fake-api-key", StreamOption{Enabled: true, IdleTimeout: 100 * time.Millisecond, FirstTokenTimeout: time.Second})
+		output, err := r.SummarizeRelativeChanges(context.Background(), SummarizeRelativeChangesInput{
+			CompletionInput: CompletionInput{
+				MessageContext: []domain.Message{domain.NewUserMessage("Summarize the changes.")},
+				MaxOutputToken: 1000,
+				Model:          "thinking-model",
+			},
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(output.Messages).To(gomega.Equal([]domain.Message{domain.NewAssistantMessage("The change looks good.")}))
+		gomega.Eventually(requests).Should(gomega.Receive())
+	})
 })
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"gitlab-mr-reviewer/pkg/ratelimit"
+	"gitlab-mr-reviewer/pkg/utils"
+)
+
+type rateLimitedLLMRepository struct {
+	llmRepository LLMRepository
+	limiter       *ratelimit.Limiter
+}
+
+// NewRateLimitedLLMRepository wait for the limiter before every completion, so that the concurrent reviews stay within the limits of the provider.
+// A completion is counted as the tokens of its messages and the MaxOutputToken, which the providers reserve for the request.
+func NewRateLimitedLLMRepository(llmRepository LLMRepository, limiter *ratelimit.Limiter) LLMRepository {
+	return &rateLimitedLLMRepository{
+		llmRepository: llmRepository,
+		limiter:       limiter,
+	}
+}
+
+func (r *rateLimitedLLMRepository) SummarizeRelativeChanges(ctx context.Context, input SummarizeRelativeChangesInput) (SummarizeRelativeChangesOutput, error) {
+	if err := r.wait(ctx, input.Model, input.MessageContext, input.MaxOutputToken); err != nil {
+		return SummarizeRelativeChangesOutput{}, err
+	}
+	return r.llmRepository.SummarizeRelativeChanges(ctx, input)
+}
+
+func (r *rateLimitedLLMRepository) SummarizeReleaseNote(ctx context.Context, input SummarizeReleaseNoteInput) (SummarizeReleaseNoteOutput, error) {
+	if err := r.wait(ctx, input.Model, input.MessageContext, input.MaxOutputToken); err != nil {
+		return SummarizeReleaseNoteOutput{}, err
+	}
+	return r.llmRepository.SummarizeReleaseNote(ctx, input)
+}
+
+func (r *rateLimitedLLMRepository) ReviewRelativeChanges(ctx context.Context, input ReviewRelativeChangesInput) (ReviewRelativeChangesOutput, error) {
+	if err := r.wait(ctx, input.Model, input.MessageContext, input.MaxOutputToken); err != nil {
+		return ReviewRelativeChangesOutput{}, err
+	}
+	return r.llmRepository.ReviewRelativeChanges(ctx, input)
+}
+
+func (r *rateLimitedLLMRepository) wait(ctx context.Context, model string, messages []domain.Message, maxOutputToken int64) error {
+	tokens := maxOutputToken
+	for _, message := range messages {
+		count, err := utils.CountTokens(model, message.Content)
+		if err != nil {
+			return err
+		}
+		tokens += count
+	}
+	return r.limiter.Wait(ctx, tokens)
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"gitlab-mr-reviewer/pkg/ratelimit"
+	"time"
+)
+
+var _ = ginkgo.Describe("RateLimitedLLMRepository", func() {
+	input := SummarizeRelativeChangesInput{
+		CompletionInput: CompletionInput{
+			MessageContext: []domain.Message{{Role: domain.RoleSystem, Content: "You are a reviewer."}, domain.NewUserMessage("Summarize the diff.")},
+			MaxOutputToken: 1000,
+			Model:          "gpt-4o-mini",
+		},
+	}
+
+	ginkgo.It("Should wait for the tokens of the messages and the MaxOutputToken", func() {
+		llmRepository := &countingLLMRepository{}
+		r := NewRateLimitedLLMRepository(llmRepository, ratelimit.NewLimiter(0, 1500))
+
+		_, err := r.SummarizeRelativeChanges(context.Background(), input)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = r.SummarizeRelativeChanges(ctx, input)
+		gomega.Expect(err).To(gomega.MatchError(context.DeadlineExceeded))
+		gomega.Expect(llmRepository.count).To(gomega.Equal(1))
+	})
+})
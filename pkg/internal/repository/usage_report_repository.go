@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type UsageReportRepository interface {
+	SaveUsageReport(ctx context.Context, report UsageReportDto) error
+}
+
+// UsageReportDto is the tokens consumed by a review, and their cost in USD
+type UsageReportDto struct {
+	ProjectId      int32           `json:"project_id"`
+	MergeRequestId int32           `json:"merge_request_id"`
+	HeadSha        string          `json:"head_sha"`
+	ReviewedAt     time.Time       `json:"reviewed_at"`
+	Models         []ModelUsageDto `json:"models"`
+	CacheHits      int             `json:"cache_hits"`
+	InputTokens    int64           `json:"input_tokens"`
+	OutputTokens   int64           `json:"output_tokens"`
+	Cost           float64         `json:"cost_usd"`
+}
+
+// ModelUsageDto is the tokens consumed by the completions of a model, Priced is false if the model isn't in the price table
+type ModelUsageDto struct {
+	Model        string  `json:"model"`
+	Completions  int     `json:"completions"`
+	CacheHits    int     `json:"cache_hits"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	Cost         float64 `json:"cost_usd"`
+	Priced       bool    `json:"priced"`
+}
+
+type fileUsageReportRepository struct {
+	mutex sync.Mutex
+	path  string
+}
+
+// NewFileUsageReportRepository append the reports to the file as JSON Lines, a review per line,
+// so that the reports of the webhook server are collected into the same file. The reports are discarded if the path is empty.
+func NewFileUsageReportRepository(path string) UsageReportRepository {
+	return &fileUsageReportRepository{
+		path: path,
+	}
+}
+
+func (r *fileUsageReportRepository) SaveUsageReport(ctx context.Context, report UsageReportDto) error {
+	if len(r.path) == 0 {
+		return nil
+	}
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open usage report")
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "Failed to write usage report")
+	}
+	return nil
+}
+
+// renderUsage render the token usage as the footer of the note
+func renderUsage(usage UsageReportDto) string {
+	var models, unpricedModels []string
+	for _, model := range usage.Models {
+		models = append(models, model.Model)
+		if !model.Priced {
+			unpricedModels = append(unpricedModels, model.Model)
+		}
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("<sub>Token usage: %d input and %d output tokens of %s", usage.InputTokens, usage.OutputTokens, strings.Join(models, ", ")))
+	switch {
+	case len(unpricedModels) == len(models):
+		builder.WriteString(", the cost is unknown.")
+	case len(unpricedModels) > 0:
+		builder.WriteString(fmt.Sprintf(", costing $%.4f excluding the unpriced %s.", usage.Cost, strings.Join(unpricedModels, ", ")))
+	default:
+		builder.WriteString(fmt.Sprintf(", costing $%.4f.", usage.Cost))
+	}
+	if usage.CacheHits > 0 {
+		builder.WriteString(fmt.Sprintf(" %d of the responses are cached.", usage.CacheHits))
+	}
+	builder.WriteString("</sub>")
+	return builder.String()
+}
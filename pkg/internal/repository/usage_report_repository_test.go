@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+)
+
+var _ = ginkgo.Describe("UsageReportRepository", func() {
+	report := UsageReportDto{
+		ProjectId:      1,
+		MergeRequestId: 2,
+		HeadSha:        "head-sha",
+		Models:         []ModelUsageDto{{Model: "gpt-4o-mini", Completions: 3, InputTokens: 3000, OutputTokens: 300, Cost: 0.00063, Priced: true}},
+		InputTokens:    3000,
+		OutputTokens:   300,
+		Cost:           0.00063,
+	}
+
+	ginkgo.It("Should append a report per line", func() {
+		path := filepath.Join(ginkgo.GinkgoT().TempDir(), "usage.jsonl")
+		r := NewFileUsageReportRepository(path)
+		gomega.Expect(r.SaveUsageReport(context.Background(), report)).To(gomega.Succeed())
+		gomega.Expect(r.SaveUsageReport(context.Background(), report)).To(gomega.Succeed())
+
+		file, err := os.Open(path)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		defer file.Close()
+		var reports []UsageReportDto
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var line UsageReportDto
+			gomega.Expect(json.Unmarshal(scanner.Bytes(), &line)).To(gomega.Succeed())
+			reports = append(reports, line)
+		}
+		gomega.Expect(reports).To(gomega.Equal([]UsageReportDto{report, report}))
+	})
+
+	ginkgo.It("Should discard the report without the path", func() {
+		gomega.Expect(NewFileUsageReportRepository("").SaveUsageReport(context.Background(), report)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("Should render the usage as the footer", func() {
+		gomega.Expect(renderUsage(report)).To(gomega.Equal("<sub>Token usage: 3000 input and 300 output tokens of gpt-4o-mini, costing $0.0006.</sub>"))
+
+		unpriced := report
+		unpriced.Models = []ModelUsageDto{{Model: "llama3", Completions: 1, InputTokens: 3000, OutputTokens: 300}}
+		gomega.Expect(renderUsage(unpriced)).To(gomega.Equal("<sub>Token usage: 3000 input and 300 output tokens of llama3, the cost is unknown.</sub>"))
+
+		cached := report
+		cached.CacheHits = 2
+		gomega.Expect(renderUsage(cached)).To(gomega.HaveSuffix(" 2 of the responses are cached.</sub>"))
+	})
+})
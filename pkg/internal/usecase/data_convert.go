@@ -5,36 +5,143 @@ import (
 	"gitlab-mr-reviewer/pkg/internal/repository"
 )
 
-func toMergeRequestDomain(mergeRequest *repository.MergeRequestDto, diffs []repository.DiffDto) *domain.MergeRequest {
-	relativeChanges := make([]domain.RelativeChange, len(diffs))
-	for i, diff := range diffs {
-		relativeChanges[i] = domain.RelativeChange{
+func toMergeRequestDomain(mergeRequest *repository.MergeRequestDto, diffs []repository.DiffDto, commits []repository.CommitDto) *domain.MergeRequest {
+	relativeChanges, omittedChanges := toRelativeChangesDomain(diffs)
+	mr := domain.NewMergeRequest(
+		mergeRequest.Id,
+		mergeRequest.ProjectId,
+		mergeRequest.Title,
+		mergeRequest.Description,
+		&domain.DifferentReference{
+			BaseSha:  mergeRequest.DiffRefs.BaseSha,
+			StartSha: mergeRequest.DiffRefs.StartSha,
+			HeadSha:  mergeRequest.DiffRefs.HeadSha,
+		},
+		relativeChanges)
+	mr.OmittedChanges = omittedChanges
+	mr.Author = mergeRequest.Author.Username
+	mr.Labels = mergeRequest.Labels
+	mr.Commits = toCommitsDomain(commits)
+	return mr
+}
+
+func toCommitsDomain(commits []repository.CommitDto) []domain.Commit {
+	var result []domain.Commit
+	for _, commit := range commits {
+		result = append(result, domain.Commit{Sha: commit.Id, Title: commit.Title, Message: commit.Message})
+	}
+	return result
+}
+
+// toRelativeChangesDomain convert the diffs into RelativeChange, and the incomplete diffs into OmittedChange
+func toRelativeChangesDomain(diffs []repository.DiffDto) ([]domain.RelativeChange, []domain.OmittedChange) {
+	var relativeChanges []domain.RelativeChange
+	var omittedChanges []domain.OmittedChange
+	for _, diff := range diffs {
+		if isIncompleteDiff(diff) {
+			omittedChanges = append(omittedChanges, domain.OmittedChange{
+				Path:   diff.NewPath,
+				Reason: "the diff is too large to be fetched",
+			})
+			continue
+		}
+
+		relativeChanges = append(relativeChanges, domain.RelativeChange{
 			Diff:        diff.Diff,
 			NewPath:     diff.NewPath,
 			OldPath:     diff.OldPath,
 			NewFile:     diff.NewFile,
 			RenameFile:  diff.RenameFile,
 			DeletedFile: diff.DeletedFile,
-		}
+		})
 	}
+	return relativeChanges, omittedChanges
+}
 
-	return domain.NewMergeRequest(
-		mergeRequest.Id,
-		mergeRequest.ProjectId,
-		mergeRequest.Title,
-		mergeRequest.Description,
-		&domain.DifferentReference{
-			BaseSha: mergeRequest.DiffRefs.BaseSha,
-			HeadSha: mergeRequest.DiffRefs.HeadSha,
-		},
-		relativeChanges)
+// isIncompleteDiff return whether Gitlab leaves the diff out, because it is too large or collapsed
+func isIncompleteDiff(diff repository.DiffDto) bool {
+	return (diff.TooLarge || diff.Collapsed) && len(diff.Diff) == 0
 }
 
-func toCreateMergeRequestSummaryInput(mergeRequest *domain.MergeRequest) repository.CreateMergeRequestSummaryInput {
+func toCreateMergeRequestSummaryInput(mergeRequest *domain.MergeRequest, promptVersions []string) repository.CreateMergeRequestSummaryInput {
+	usage := toUsageReportDto(mergeRequest)
 	return repository.CreateMergeRequestSummaryInput{
-		ProjectId:          mergeRequest.ProjectID,
-		MergeRequestId:     mergeRequest.ID,
-		RelativeChangeNote: string(mergeRequest.RelativeChangeNote),
-		SummaryNote:        string(mergeRequest.SummaryNote),
+		ProjectId:              mergeRequest.ProjectID,
+		MergeRequestId:         mergeRequest.ID,
+		RelativeChangeNote:     string(mergeRequest.RelativeChangeNote),
+		FindingNote:            string(mergeRequest.FindingNote()),
+		SummaryNote:            string(mergeRequest.SummaryNote),
+		HeadSha:                mergeRequest.DifferentReference.HeadSha,
+		ReviewedHeadSha:        mergeRequest.ReviewedHeadSha,
+		OmittedChanges:         toOmittedChangeDtos(mergeRequest.OmittedChanges),
+		NonConventionalCommits: toCommitDtos(mergeRequest.NonConventionalCommits),
+		PromptVersions:         promptVersions,
+		Usage:                  &usage,
+	}
+}
+
+func toUsageReportDto(mergeRequest *domain.MergeRequest) repository.UsageReportDto {
+	models := make([]repository.ModelUsageDto, len(mergeRequest.Usage.Models))
+	for i, usage := range mergeRequest.Usage.Models {
+		models[i] = repository.ModelUsageDto{
+			Model:        usage.Model,
+			Completions:  usage.Completions,
+			CacheHits:    usage.CacheHits,
+			InputTokens:  usage.InputTokens,
+			OutputTokens: usage.OutputTokens,
+			Cost:         usage.Cost,
+			Priced:       usage.Priced,
+		}
+	}
+	return repository.UsageReportDto{
+		ProjectId:      mergeRequest.ProjectID,
+		MergeRequestId: mergeRequest.ID,
+		HeadSha:        mergeRequest.DifferentReference.HeadSha,
+		Models:         models,
+		CacheHits:      mergeRequest.Usage.CacheHits,
+		InputTokens:    mergeRequest.Usage.InputTokens,
+		OutputTokens:   mergeRequest.Usage.OutputTokens,
+		Cost:           mergeRequest.Usage.Cost,
+	}
+}
+
+func toCommitDtos(commits []domain.Commit) []repository.CommitDto {
+	var result []repository.CommitDto
+	for _, commit := range commits {
+		result = append(result, repository.CommitDto{Id: commit.Sha, Title: commit.Title, Message: commit.Message})
+	}
+	return result
+}
+
+func toOmittedChangeDtos(omittedChanges []domain.OmittedChange) []repository.OmittedChangeDto {
+	dtos := make([]repository.OmittedChangeDto, len(omittedChanges))
+	for i, change := range omittedChanges {
+		dtos[i] = repository.OmittedChangeDto{
+			Path:   change.Path,
+			Reason: change.Reason,
+		}
+	}
+	return dtos
+}
+
+func toCreateMergeRequestDiscussionInputs(mergeRequest *domain.MergeRequest) []repository.CreateMergeRequestDiscussionInput {
+	inputs := make([]repository.CreateMergeRequestDiscussionInput, len(mergeRequest.ReviewComments))
+	for i, comment := range mergeRequest.ReviewComments {
+		inputs[i] = repository.CreateMergeRequestDiscussionInput{
+			ProjectId:      mergeRequest.ProjectID,
+			MergeRequestId: mergeRequest.ID,
+			Body:           comment.Body,
+			Position: repository.PositionDto{
+				PositionType: "text",
+				BaseSha:      mergeRequest.DifferentReference.BaseSha,
+				StartSha:     mergeRequest.DifferentReference.StartSha,
+				HeadSha:      mergeRequest.DifferentReference.HeadSha,
+				NewPath:      comment.NewPath,
+				OldPath:      comment.OldPath,
+				NewLine:      comment.NewLine,
+				OldLine:      comment.OldLine,
+			},
+		}
 	}
+	return inputs
 }
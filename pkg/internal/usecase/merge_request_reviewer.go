@@ -4,18 +4,39 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/pkg/errors"
 	"gitlab-mr-reviewer/pkg/internal/domain"
 	"gitlab-mr-reviewer/pkg/internal/repository"
 	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"text/template"
+	"time"
 )
 
 var (
 	ErrorIgnoreCodeReview = errors.New("Ignore code review.")
+	// ErrorPartialReview is returned along with the output, when the review is posted without the failed batches
+	ErrorPartialReview = errors.New("Some of the changes failed to be reviewed.")
 )
 
+// LLMError is the failure of the LLM provider, which tells it apart from the failures of Gitlab
+type LLMError struct {
+	err error
+}
+
+func (e *LLMError) Error() string {
+	return e.err.Error()
+}
+
+func (e *LLMError) Unwrap() error {
+	return e.err
+}
+
 type MergeRequestReviewer interface {
 	Apply(context.Context, *MergeRequestReviewInput) (*MergeRequestReviewOutput, error)
 }
@@ -26,114 +47,578 @@ type MergeRequestReviewInput struct {
 	Model          string `json:"model,omitempty" validate:"required"`
 	MaxInputToken  int64  `json:"max_input_token" validate:"gt=0"`
 	MaxOutputToken int64  `json:"max_output_token" validate:"gt=0"`
+	// FailOn is the severity which the findings fail the review at, the review never fails if it is empty
+	FailOn domain.Severity `json:"fail_on,omitempty" validate:"omitempty,oneof=critical high medium low info"`
 }
 type MergeRequestReviewOutput struct {
-	SummarizeRelativeChanges string `json:"summarize_relative_changes"`
-	SummarizeReleaseNote     string `json:"summarize_release_note"`
+	SummarizeRelativeChanges string                 `json:"summarize_relative_changes"`
+	SummarizeReleaseNote     string                 `json:"summarize_release_note"`
+	ReviewComments           []domain.ReviewComment `json:"review_comments"`
+	Findings                 []domain.Finding       `json:"findings"`
+	ReviewedHeadSha          string                 `json:"reviewed_head_sha,omitempty"`
+	OmittedChanges           []domain.OmittedChange `json:"omitted_changes,omitempty"`
+	// NonConventionalCommits are the commits which don't follow Conventional Commits, if the check is enabled
+	NonConventionalCommits []domain.Commit `json:"non_conventional_commits,omitempty"`
+	// Usage is the tokens consumed by the completions of the review, priced with the price table
+	Usage domain.Usage `json:"usage"`
+	// PromptVersions are the `<name>@<version>+<hash>` of the prompt templates which the review is generated with
+	PromptVersions []string `json:"prompt_versions"`
 }
 
-type gitlabMergeRequestReviewer struct {
-	logger           *logging.ZaprLogger
-	gitlabRepository repository.GitlabRepository
-	openaiRepository repository.LLMRepository
-	systemMessage    string
-	pathFilters      []*regexp.Regexp
+type mergeRequestReviewer struct {
+	logger                *logging.ZaprLogger
+	codeHostRepository    repository.CodeHostRepository
+	openaiRepository      repository.LLMRepository
+	usageReportRepository repository.UsageReportRepository
+	// prices are the prices of the models which the usage is priced with
+	prices              domain.PriceTable
+	systemMessage       string
+	promptTemplates     PromptTemplates
+	pathFilters         []*regexp.Regexp
+	inlineComment       bool
+	conventionalCommits bool
+	// contextLines are the lines of the file around each hunk which are added to the prompt, the file isn't fetched if it is 0
+	contextLines      int32
+	incrementalReview bool
+	// concurrency is the number of the batches reviewed at the same time
+	concurrency int
+	// perFile reviews every file in its own batch, so that the files of a small merge request are reviewed concurrently too
+	perFile bool
 }
 
-func NewGitlabMergeRequestReviewer(
+// findingsDto is the structured response of the review, see newFindingsResponseSchema
+type findingsDto struct {
+	Findings []findingDto `json:"findings"`
+}
+type findingDto struct {
+	File         string  `json:"file"`
+	StartLine    int32   `json:"start_line"`
+	EndLine      int32   `json:"end_line"`
+	Severity     string  `json:"severity"`
+	Category     string  `json:"category"`
+	Message      string  `json:"message"`
+	SuggestedFix *string `json:"suggested_fix"`
+}
+
+func NewMergeRequestReviewer(
 	logger *logging.ZaprLogger,
 	systemMessage string,
+	promptTemplates PromptTemplates,
 	pathFilters []string,
-	gitlabRepository repository.GitlabRepository,
-	llmRepository repository.LLMRepository) (MergeRequestReviewer, error) {
+	inlineComment bool,
+	incrementalReview bool,
+	conventionalCommits bool,
+	contextLines int32,
+	prices domain.PriceTable,
+	concurrency int,
+	perFile bool,
+	codeHostRepository repository.CodeHostRepository,
+	llmRepository repository.LLMRepository,
+	usageReportRepository repository.UsageReportRepository) (MergeRequestReviewer, error) {
 
-	filters := make([]*regexp.Regexp, len(pathFilters))
-	for i, f := range pathFilters {
-		regex, err := regexp.Compile(f)
-		if err != nil {
-			return nil, errors.Wrap(err, "[NewGitlabMergeRequestReviewer]failed to compile path filter")
-		}
-		filters[i] = regex
+	filters, err := compilePathFilters(pathFilters)
+	if err != nil {
+		return nil, errors.Wrap(err, "[NewMergeRequestReviewer]failed to compile path filter")
 	}
+	logger.Info(fmt.Sprintf("Prompt templates: %s", strings.Join(promptTemplates.Versions(), ", ")))
 
-	return &gitlabMergeRequestReviewer{
-		logger:           logger,
-		gitlabRepository: gitlabRepository,
-		openaiRepository: llmRepository,
-		systemMessage:    systemMessage,
-		pathFilters:      filters,
+	return &mergeRequestReviewer{
+		logger:                logger,
+		codeHostRepository:    codeHostRepository,
+		openaiRepository:      llmRepository,
+		usageReportRepository: usageReportRepository,
+		prices:                prices,
+		concurrency:           max(concurrency, 1),
+		perFile:               perFile,
+		systemMessage:         systemMessage,
+		promptTemplates:       promptTemplates,
+		pathFilters:           filters,
+		inlineComment:         inlineComment,
+		incrementalReview:     incrementalReview,
+		conventionalCommits:   conventionalCommits,
+		contextLines:          contextLines,
 	}, nil
 }
 
-func (r *gitlabMergeRequestReviewer) Apply(ctx context.Context, input *MergeRequestReviewInput) (*MergeRequestReviewOutput, error) {
+func (r *mergeRequestReviewer) Apply(ctx context.Context, input *MergeRequestReviewInput) (*MergeRequestReviewOutput, error) {
 	mergeRequest, err := r.getMergeRequest(ctx, input.ProjectId, input.MergeRequestId)
 	if err != nil {
 		return nil, err
 	}
+	// the tokens spent by a failed review are reported too
+	defer r.reportUsage(context.WithoutCancel(ctx), mergeRequest)
+
+	mergeRequest.ProjectConfig, err = r.getProjectConfig(ctx, mergeRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(input.FailOn) > 0 && mergeRequest.ProjectConfig.IsDisabled(domain.StageFindings) {
+		r.logger.Warn(fmt.Sprintf("Ignore %s in the disabled stages of %s, since fail-on needs the findings", domain.StageFindings, ProjectConfigFile))
+		mergeRequest.ProjectConfig.Enable(domain.StageFindings)
+	}
+
+	if r.incrementalReview {
+		if err := r.applyIncrementalChanges(ctx, mergeRequest); err != nil {
+			return nil, err
+		}
+	}
 
-	mergeRequest.FilterIgnorePaths(r.pathFilters)
+	pathFilters := r.pathFilters
+	if mergeRequest.ProjectConfig != nil {
+		projectPathFilters, err := compilePathFilters(mergeRequest.ProjectConfig.PathFilters)
+		if err != nil {
+			return nil, err
+		}
+		pathFilters = append(slices.Clone(pathFilters), projectPathFilters...)
+	}
+	mergeRequest.FilterIgnorePaths(pathFilters)
 	if mergeRequest.IgnoreReview() {
 		return nil, ErrorIgnoreCodeReview
 	}
+	if r.conventionalCommits {
+		mergeRequest.CheckConventionalCommits()
+	}
+	if r.contextLines > 0 {
+		r.addFileContext(ctx, mergeRequest)
+	}
 
-	codeReviewMessageBox, err := domain.NewCodeReviewMessageBox(r.systemMessage, input.Model, input.MaxInputToken, input.MaxOutputToken)
+	codeReviewMessageBox, err := domain.NewCodeReviewMessageBox(mergeRequest.SystemMessage(r.systemMessage), input.Model, input.MaxInputToken, input.MaxOutputToken)
 	if err != nil {
 		return nil, err
 	}
 
-	summarizeRelativeChanges, err := r.summarizeRelativeChanges(ctx, mergeRequest, codeReviewMessageBox)
+	batches, err := r.splitRelativeChanges(mergeRequest, codeReviewMessageBox)
 	if err != nil {
 		return nil, err
 	}
+
+	// map: summarize and review each batch of the relative changes within its own context,
+	// a failed batch doesn't abort the others, its files are reported as not reviewed, and ErrorPartialReview is returned after the review is posted
+	var partialSummaries []string
+	var batchErr error
+	var failedBatches int
+	for i, review := range r.reviewBatches(ctx, mergeRequest, batches, input) {
+		mergeRequest.Usage.Merge(review.usage)
+		if review.err != nil {
+			r.logger.WithError(review.err).Warn(fmt.Sprintf("Failed to review batch %d of %d", i+1, len(batches)))
+			for _, change := range batches[i] {
+				mergeRequest.OmitChange(change.NewPath, "the review failed")
+			}
+			batchErr = review.err
+			failedBatches++
+			continue
+		}
+		partialSummaries = append(partialSummaries, review.summary)
+		r.addFindings(mergeRequest, review.findings)
+	}
+	if len(partialSummaries) == 0 {
+		return nil, batchErr
+	}
+
+	// reduce: merge the partial summaries into the final one
+	summarizeRelativeChanges := partialSummaries[0]
+	if len(partialSummaries) > 1 {
+		summarizeRelativeChanges, err = r.mergePartialSummaries(ctx, mergeRequest, partialSummaries, input)
+		if err != nil {
+			return nil, err
+		}
+	}
 	mergeRequest.RelativeChangeNote = domain.Note(summarizeRelativeChanges)
 
-	summarizeReleaseNote, err := r.summarizeReleaseNote(ctx, codeReviewMessageBox)
-	if err != nil {
-		return nil, err
+	var summarizeReleaseNote string
+	if !mergeRequest.ProjectConfig.IsDisabled(domain.StageReleaseNote) {
+		summarizeReleaseNote, err = r.summarizeReleaseNote(ctx, mergeRequest, summarizeRelativeChanges, input)
+		if err != nil {
+			return nil, err
+		}
 	}
 	mergeRequest.SummaryNote = domain.Note(summarizeReleaseNote)
 
-	if err := r.gitlabRepository.CreateMergeRequestSummary(ctx, toCreateMergeRequestSummaryInput(mergeRequest)); err != nil {
+	if err := r.codeHostRepository.CreateMergeRequestSummary(ctx, toCreateMergeRequestSummaryInput(mergeRequest, r.promptTemplates.Versions())); err != nil {
 		return nil, err
 	}
+	r.createMergeRequestDiscussions(ctx, mergeRequest)
 
-	return &MergeRequestReviewOutput{
+	output := &MergeRequestReviewOutput{
 		SummarizeRelativeChanges: summarizeRelativeChanges,
 		SummarizeReleaseNote:     summarizeReleaseNote,
-	}, nil
+		ReviewComments:           mergeRequest.ReviewComments,
+		Findings:                 mergeRequest.Findings,
+		ReviewedHeadSha:          mergeRequest.ReviewedHeadSha,
+		OmittedChanges:           mergeRequest.OmittedChanges,
+		PromptVersions:           r.promptTemplates.Versions(),
+		NonConventionalCommits:   mergeRequest.NonConventionalCommits,
+		Usage:                    mergeRequest.Usage,
+	}
+	if failedBatches > 0 {
+		return output, errors.Wrapf(ErrorPartialReview, "Failed to review %d of %d batches: %s", failedBatches, len(batches), batchErr)
+	}
+	return output, nil
+}
+
+// reportUsage log the token usage of every model, and save the usage report, a failed report doesn't fail the review.
+// Nothing is reported if no completion was made, e.g. the review is ignored
+func (r *mergeRequestReviewer) reportUsage(ctx context.Context, mergeRequest *domain.MergeRequest) {
+	if len(mergeRequest.Usage.Models) == 0 {
+		return
+	}
+	for _, usage := range mergeRequest.Usage.Models {
+		r.logger.WithValues(
+			"projectId", mergeRequest.ProjectID,
+			"mergeRequestId", mergeRequest.ID,
+			"model", usage.Model,
+			"completions", usage.Completions,
+			"cacheHits", usage.CacheHits,
+			"inputTokens", usage.InputTokens,
+			"outputTokens", usage.OutputTokens,
+			"costUsd", usage.Cost,
+			"priced", usage.Priced,
+		).Info("Token usage")
+	}
+	if models := mergeRequest.Usage.UnpricedModels(); len(models) > 0 {
+		r.logger.Warn(fmt.Sprintf("The cost of %s is unknown, add them to LLM.Prices", strings.Join(models, ", ")))
+	}
+
+	report := toUsageReportDto(mergeRequest)
+	report.ReviewedAt = time.Now().UTC()
+	if err := r.usageReportRepository.SaveUsageReport(ctx, report); err != nil {
+		r.logger.WithError(err).Warn("Failed to save the usage report")
+	}
+}
+
+// getProjectConfig fetch the project config at the head commit, so that the merge request is able to change its instructions along with the code.
+// The policy is fetched at the base commit, which the author of the merge request doesn't control. It is nil if the project has none
+func (r *mergeRequestReviewer) getProjectConfig(ctx context.Context, mergeRequest *domain.MergeRequest) (*domain.ProjectConfig, error) {
+	projectConfig, err := r.getProjectConfigAt(ctx, mergeRequest, mergeRequest.DifferentReference.HeadSha)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := r.getProjectConfigAt(ctx, mergeRequest, mergeRequest.DifferentReference.BaseSha)
+	if err != nil {
+		return nil, err
+	}
+	return projectConfig.WithPolicy(policy), nil
+}
+
+// getProjectConfigAt fetch the project config at the given commit, it is nil if the commit has none
+func (r *mergeRequestReviewer) getProjectConfigAt(ctx context.Context, mergeRequest *domain.MergeRequest, ref string) (*domain.ProjectConfig, error) {
+	content, err := r.codeHostRepository.GetFile(ctx, mergeRequest.ProjectID, ProjectConfigFile, ref)
+	if errors.Is(err, retry.ErrorNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get %s at %s", ProjectConfigFile, ref)
+	}
+	projectConfig, err := parseProjectConfig(content)
+	if err != nil {
+		return nil, err
+	}
+	r.logger.Info(fmt.Sprintf("Merge %s of the commit %s into the config", ProjectConfigFile, ref))
+	return projectConfig, nil
+}
+
+// addFileContext fetch the changed files to add the lines around the hunks, which tell the model the declarations outside the hunks.
+// The deleted files are fetched at the base commit, and a file which fails to be fetched is reviewed without the context
+func (r *mergeRequestReviewer) addFileContext(ctx context.Context, mergeRequest *domain.MergeRequest) {
+	baseSha := mergeRequest.DifferentReference.BaseSha
+	if mergeRequest.IsIncremental() {
+		baseSha = mergeRequest.ReviewedHeadSha
+	}
+
+	for i, change := range mergeRequest.RelativeChanges {
+		hunks, err := change.Hunks()
+		if err != nil || len(hunks) == 0 {
+			continue
+		}
+		path, ref := change.NewPath, mergeRequest.DifferentReference.HeadSha
+		if change.DeletedFile {
+			path, ref = change.OldPath, baseSha
+		}
+
+		content, err := r.codeHostRepository.GetFile(ctx, mergeRequest.ProjectID, path, ref)
+		if err != nil {
+			r.logger.WithError(err).Warn(fmt.Sprintf("Failed to get %s at %s, review it without the context", path, ref))
+			continue
+		}
+		mergeRequest.RelativeChanges[i].Context = domain.NewFileContext(string(content), hunks, r.contextLines, change.DeletedFile)
+	}
+}
+
+// applyIncrementalChanges narrow the relative changes down to the commits pushed since the previous review
+func (r *mergeRequestReviewer) applyIncrementalChanges(ctx context.Context, mergeRequest *domain.MergeRequest) error {
+	reviewedHeadSha, err := r.codeHostRepository.GetReviewedHeadSha(ctx, mergeRequest.ProjectID, mergeRequest.ID)
+	if err != nil {
+		return err
+	}
+	headSha := mergeRequest.DifferentReference.HeadSha
+	if len(reviewedHeadSha) == 0 || len(headSha) == 0 {
+		return nil
+	}
+	if reviewedHeadSha == headSha {
+		r.logger.Info(fmt.Sprintf("Head sha %s has been reviewed", headSha))
+		return ErrorIgnoreCodeReview
+	}
+
+	if !r.isAncestor(ctx, mergeRequest.ProjectID, reviewedHeadSha, headSha) {
+		r.logger.Info(fmt.Sprintf("The reviewed head sha %s isn't an ancestor of %s after a rebase or a force push, review the whole merge request", reviewedHeadSha, headSha))
+		return nil
+	}
+
+	compare, err := r.codeHostRepository.CompareCommits(ctx, mergeRequest.ProjectID, reviewedHeadSha, headSha)
+	if err != nil {
+		r.logger.WithError(err).Warn(fmt.Sprintf("Failed to compare with the reviewed head sha %s, review the whole merge request", reviewedHeadSha))
+		return nil
+	}
+	relativeChanges, omittedChanges := toRelativeChangesDomain(compare.Diffs)
+	mergeRequest.ApplyIncrementalChanges(reviewedHeadSha, relativeChanges, omittedChanges)
+	r.logger.Info(fmt.Sprintf("Review the changes since the reviewed head sha %s", reviewedHeadSha))
+	return nil
+}
+
+// isAncestor check the ancestor is reachable from the sha, by comparing them in reverse,
+// which lists the commits of the ancestor since their merge base, and there is none if it is an ancestor
+func (r *mergeRequestReviewer) isAncestor(ctx context.Context, projectId int32, ancestor, sha string) bool {
+	commits, err := r.codeHostRepository.ListComparedCommits(ctx, projectId, sha, ancestor)
+	if err != nil {
+		r.logger.WithError(err).Warn(fmt.Sprintf("Failed to check %s is an ancestor of %s", ancestor, sha))
+		return false
+	}
+	return len(commits) == 0
 }
 
-func (r *gitlabMergeRequestReviewer) getMergeRequest(ctx context.Context, projectId int32, mergeRequestId int32) (*domain.MergeRequest, error) {
-	mergeRequestDto, err := r.gitlabRepository.GetMergeRequest(ctx, projectId, mergeRequestId)
+func (r *mergeRequestReviewer) getMergeRequest(ctx context.Context, projectId int32, mergeRequestId int32) (*domain.MergeRequest, error) {
+	mergeRequestDto, err := r.codeHostRepository.GetMergeRequest(ctx, projectId, mergeRequestId)
 	if err != nil {
 		return nil, err
 	}
-	diffsDto, err := r.gitlabRepository.ListDiffByMergeRequestId(ctx, projectId, mergeRequestId)
+	diffsDto, err := r.codeHostRepository.ListDiffByMergeRequestId(ctx, projectId, mergeRequestId)
 	if err != nil {
 		return nil, err
 	}
+	diffsDto = r.completeDiffs(ctx, mergeRequestDto, diffsDto)
+	// the commits only add to the context of the review
+	commitsDto, err := r.codeHostRepository.ListMergeRequestCommits(ctx, projectId, mergeRequestId)
+	if err != nil {
+		r.logger.WithError(err).Warn("Failed to list the commits, review without them")
+	}
+
+	return toMergeRequestDomain(mergeRequestDto, diffsDto, commitsDto), nil
+}
+
+// completeDiffs re-fetch the diffs which are too large or collapsed, through the raw diffs and then the compare endpoint
+func (r *mergeRequestReviewer) completeDiffs(ctx context.Context, mergeRequestDto *repository.MergeRequestDto, diffsDto []repository.DiffDto) []repository.DiffDto {
+	fetchers := []func() ([]repository.DiffDto, error){
+		func() ([]repository.DiffDto, error) {
+			return r.codeHostRepository.ListRawDiffByMergeRequestId(ctx, mergeRequestDto.ProjectId, mergeRequestDto.Id)
+		},
+		func() ([]repository.DiffDto, error) {
+			compare, err := r.codeHostRepository.CompareCommits(ctx, mergeRequestDto.ProjectId, mergeRequestDto.DiffRefs.BaseSha, mergeRequestDto.DiffRefs.HeadSha)
+			if err != nil {
+				return nil, err
+			}
+			return compare.Diffs, nil
+		},
+	}
+
+	for _, fetch := range fetchers {
+		if !slices.ContainsFunc(diffsDto, isIncompleteDiff) {
+			break
+		}
+
+		fetchedDiffs, err := fetch()
+		if err != nil {
+			r.logger.WithError(err).Warn("Failed to re-fetch the incomplete diffs")
+			continue
+		}
+		for i, diff := range diffsDto {
+			if !isIncompleteDiff(diff) {
+				continue
+			}
+			for _, fetchedDiff := range fetchedDiffs {
+				if fetchedDiff.NewPath == diff.NewPath && !isIncompleteDiff(fetchedDiff) && len(fetchedDiff.Diff) > 0 {
+					r.logger.Info(fmt.Sprintf("Re-fetched the incomplete diff of %s", diff.NewPath))
+					diffsDto[i].Diff = fetchedDiff.Diff
+					break
+				}
+			}
+		}
+	}
+
+	return diffsDto
+}
+
+// splitRelativeChanges split the relative changes into batches which fit into the MaxInputToken along with the prompt
+func (r *mergeRequestReviewer) splitRelativeChanges(mergeRequest *domain.MergeRequest, codeReviewMessageBox *domain.CodeReviewMessagebox) ([][]domain.RelativeChange, error) {
+	prompt, err := r.generateRelativeChangesPrompt(mergeRequest, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate relative changes prompt")
+	}
+	promptToken, err := codeReviewMessageBox.CountTokens(prompt)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to count tokens")
+	}
+	if promptToken >= codeReviewMessageBox.MaxInputToken {
+		return nil, errors.Errorf("Prompt token count (%d) exceeds maximum allowed (%d)", promptToken, codeReviewMessageBox.MaxInputToken)
+	}
+
+	batches, omitted, err := domain.SplitRelativeChanges(mergeRequest.RelativeChanges, codeReviewMessageBox.MaxInputToken-promptToken, codeReviewMessageBox.CountTokens)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to split relative changes")
+	}
+	for _, change := range omitted {
+		r.logger.Warn(fmt.Sprintf("Skip the hunk of %s which exceeds maximum allowed token", change.NewPath))
+		mergeRequest.OmitChange(change.NewPath, "a hunk of the diff exceeds the maximum input token")
+	}
+	if len(batches) == 0 {
+		return nil, errors.New("No relative changes fit into maximum allowed token")
+	}
+	if r.perFile {
+		batches = domain.SplitPerFile(batches)
+	}
+	if len(batches) > 1 {
+		r.logger.Info(fmt.Sprintf("Split relative changes into %d batches", len(batches)))
+	}
+
+	return batches, nil
+}
+
+// batchReview is the summary and the findings of a batch, which is reviewed concurrently with the other batches
+type batchReview struct {
+	summary  string
+	findings []domain.Finding
+	usage    domain.Usage
+	err      error
+}
 
-	return toMergeRequestDomain(mergeRequestDto, diffsDto), nil
+// reviewBatches review the batches by at most concurrency workers, the reviews are returned in the order of the batches
+func (r *mergeRequestReviewer) reviewBatches(ctx context.Context, mergeRequest *domain.MergeRequest, batches [][]domain.RelativeChange, input *MergeRequestReviewInput) []batchReview {
+	reviews := make([]batchReview, len(batches))
+	workers := make(chan struct{}, r.concurrency)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case workers <- struct{}{}:
+				defer func() { <-workers }()
+			case <-ctx.Done():
+				reviews[i].err = ctx.Err()
+				return
+			}
+			reviews[i] = r.reviewBatch(ctx, mergeRequest, batch, input)
+		}()
+	}
+	wg.Wait()
+	return reviews
+}
+
+// reviewBatch summarize the batch and ask for its findings, it only reads the merge request since the batches are reviewed concurrently
+func (r *mergeRequestReviewer) reviewBatch(ctx context.Context, mergeRequest *domain.MergeRequest, batch []domain.RelativeChange, input *MergeRequestReviewInput) batchReview {
+	var review batchReview
+	codeReviewMessageBox, err := domain.NewCodeReviewMessageBox(mergeRequest.SystemMessage(r.systemMessage), input.Model, input.MaxInputToken, input.MaxOutputToken)
+	if err != nil {
+		review.err = err
+		return review
+	}
+
+	review.summary, review.err = r.summarizeRelativeChanges(ctx, &review.usage, mergeRequest, batch, codeReviewMessageBox)
+	if review.err != nil || mergeRequest.ProjectConfig.IsDisabled(domain.StageFindings) {
+		return review
+	}
+	review.findings, review.err = r.reviewRelativeChanges(ctx, &review.usage, mergeRequest, codeReviewMessageBox)
+	return review
 }
 
-func (r *gitlabMergeRequestReviewer) summarizeRelativeChanges(ctx context.Context, mergeRequest *domain.MergeRequest, codeReviewMessageBox *domain.CodeReviewMessagebox) (string, error) {
-	relativeChangesPrompt, err := r.generateRelativeChangesPrompt(mergeRequest)
+func (r *mergeRequestReviewer) summarizeRelativeChanges(ctx context.Context, usage *domain.Usage, mergeRequest *domain.MergeRequest, relativeChanges []domain.RelativeChange, codeReviewMessageBox *domain.CodeReviewMessagebox) (string, error) {
+	relativeChangesPrompt, err := r.generateRelativeChangesPrompt(mergeRequest, relativeChanges)
 	if err != nil {
 		return "", errors.Wrap(err, "Failed to generate relative changes prompt")
 	}
 
-	if err := codeReviewMessageBox.AddUserMessage(relativeChangesPrompt); err != nil {
+	return r.summarizeRelativeChangesPrompt(ctx, usage, relativeChangesPrompt, codeReviewMessageBox)
+}
+
+// mergePartialSummaries merge the partial summaries level by level until a single summary is left
+func (r *mergeRequestReviewer) mergePartialSummaries(ctx context.Context, mergeRequest *domain.MergeRequest, partialSummaries []string, input *MergeRequestReviewInput) (string, error) {
+	codeReviewMessageBox, err := domain.NewCodeReviewMessageBox(mergeRequest.SystemMessage(r.systemMessage), input.Model, input.MaxInputToken, input.MaxOutputToken)
+	if err != nil {
+		return "", err
+	}
+
+	for len(partialSummaries) > 1 {
+		groups, err := r.groupPartialSummaries(mergeRequest, partialSummaries, codeReviewMessageBox)
+		if err != nil {
+			return "", err
+		}
+		if len(groups) == len(partialSummaries) {
+			return "", errors.New("Partial summaries can't be merged within maximum allowed token")
+		}
+
+		mergedSummaries := make([]string, len(groups))
+		for i, group := range groups {
+			if len(group) == 1 {
+				mergedSummaries[i] = group[0]
+				continue
+			}
+
+			codeReviewMessageBox, err := domain.NewCodeReviewMessageBox(mergeRequest.SystemMessage(r.systemMessage), input.Model, input.MaxInputToken, input.MaxOutputToken)
+			if err != nil {
+				return "", err
+			}
+			mergeSummariesPrompt, err := r.generateMergeSummariesPrompt(mergeRequest, group)
+			if err != nil {
+				return "", errors.Wrap(err, "Failed to generate merge summaries prompt")
+			}
+			mergedSummaries[i], err = r.summarizeRelativeChangesPrompt(ctx, &mergeRequest.Usage, mergeSummariesPrompt, codeReviewMessageBox)
+			if err != nil {
+				return "", err
+			}
+		}
+		partialSummaries = mergedSummaries
+	}
+
+	return partialSummaries[0], nil
+}
+
+// groupPartialSummaries group the consecutive partial summaries which merge prompt fits into the MaxInputToken
+func (r *mergeRequestReviewer) groupPartialSummaries(mergeRequest *domain.MergeRequest, partialSummaries []string, codeReviewMessageBox *domain.CodeReviewMessagebox) ([][]string, error) {
+	var groups [][]string
+	var group []string
+	for _, summary := range partialSummaries {
+		prompt, err := r.generateMergeSummariesPrompt(mergeRequest, append(slices.Clone(group), summary))
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to generate merge summaries prompt")
+		}
+		promptToken, err := codeReviewMessageBox.CountTokens(prompt)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to count tokens")
+		}
+
+		if promptToken > codeReviewMessageBox.MaxInputToken && len(group) > 0 {
+			groups = append(groups, group)
+			group = nil
+		}
+		group = append(group, summary)
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func (r *mergeRequestReviewer) summarizeRelativeChangesPrompt(ctx context.Context, usage *domain.Usage, prompt string, codeReviewMessageBox *domain.CodeReviewMessagebox) (string, error) {
+	if err := codeReviewMessageBox.AddUserMessage(prompt); err != nil {
 		return "", errors.Wrap(err, "Failed to add relative changes prompt to user message")
 	}
 
 	codeReviewData, err := r.openaiRepository.SummarizeRelativeChanges(ctx, repository.SummarizeRelativeChangesInput{
-		MessageContext: codeReviewMessageBox.Message,
-		MaxOutputToken: codeReviewMessageBox.MaxOutputToken,
-		Model:          codeReviewMessageBox.Model,
+		CompletionInput: r.newCompletionInput(codeReviewMessageBox),
 	})
 	if err != nil {
-		return "", errors.Wrap(err, "Failed to create relative changes completion")
+		return "", errors.Wrap(&LLMError{err: err}, "Failed to create relative changes completion")
 	}
+	usage.Add(codeReviewMessageBox.Model, codeReviewData.Usage.InputTokens, codeReviewData.Usage.OutputTokens, codeReviewData.Usage.CacheHit, r.prices)
 	codeReviewMessageBox.AppendMessage(codeReviewData.Messages)
 
 	lastAssistantMessage, err := codeReviewMessageBox.GetLastAssistantMessage()
@@ -142,61 +627,227 @@ func (r *gitlabMergeRequestReviewer) summarizeRelativeChanges(ctx context.Contex
 	}
 	return lastAssistantMessage.Content, nil
 }
-func (r *gitlabMergeRequestReviewer) summarizeReleaseNote(ctx context.Context, codeReviewMessageBox *domain.CodeReviewMessagebox) (string, error) {
-	releaseNotePrompt := r.generateReleaseNotePrompt()
+
+// summarizeReleaseNote write the release note from a fresh context made of the summary alone,
+// so that the diffs and the findings of the review don't leak into it
+func (r *mergeRequestReviewer) summarizeReleaseNote(ctx context.Context, mergeRequest *domain.MergeRequest, summary string, input *MergeRequestReviewInput) (string, error) {
+	codeReviewMessageBox, err := domain.NewCodeReviewMessageBox(mergeRequest.SystemMessage(r.systemMessage), input.Model, input.MaxInputToken, input.MaxOutputToken)
+	if err != nil {
+		return "", err
+	}
+	data := newPromptData(mergeRequest)
+	data.Summary = summary
+	releaseNotePrompt, err := r.promptTemplates[PromptReleaseNote].Render(data)
+	if err != nil {
+		return "", err
+	}
 
 	if err := codeReviewMessageBox.AddUserMessage(releaseNotePrompt); err != nil {
 		return "", errors.Wrap(err, "Failed to add release note prompt to user message")
 	}
 
 	codeReviewData, err := r.openaiRepository.SummarizeReleaseNote(ctx, repository.SummarizeReleaseNoteInput{
+		CompletionInput: r.newCompletionInput(codeReviewMessageBox),
+	})
+	if err != nil {
+		return "", errors.Wrap(&LLMError{err: err}, "Failed to create release note completion")
+	}
+	mergeRequest.Usage.Add(codeReviewMessageBox.Model, codeReviewData.Usage.InputTokens, codeReviewData.Usage.OutputTokens, codeReviewData.Usage.CacheHit, r.prices)
+	codeReviewMessageBox.AppendMessage(codeReviewData.Messages)
+
+	lastAssistantMessage, err := codeReviewMessageBox.GetLastAssistantMessage()
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to get last assistant message")
+	}
+	return lastAssistantMessage.Content, nil
+}
+
+// newCompletionInput ask for the completion of the messages in the message box, rendered with the current prompt templates
+func (r *mergeRequestReviewer) newCompletionInput(codeReviewMessageBox *domain.CodeReviewMessagebox) repository.CompletionInput {
+	return repository.CompletionInput{
 		MessageContext: codeReviewMessageBox.Message,
 		MaxOutputToken: codeReviewMessageBox.MaxOutputToken,
 		Model:          codeReviewMessageBox.Model,
+		PromptVersions: r.promptTemplates.Versions(),
+	}
+}
+
+// reviewRelativeChanges ask the LLM for the structured findings of the relative changes summarized in the message box
+func (r *mergeRequestReviewer) reviewRelativeChanges(ctx context.Context, usage *domain.Usage, mergeRequest *domain.MergeRequest, codeReviewMessageBox *domain.CodeReviewMessagebox) ([]domain.Finding, error) {
+	data := newPromptData(mergeRequest)
+	data.Categories = joinQuoted(domain.Categories)
+	findingsPrompt, err := r.promptTemplates[PromptFindings].Render(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := codeReviewMessageBox.AddUserMessage(findingsPrompt); err != nil {
+		return nil, errors.Wrap(err, "Failed to add findings prompt to user message")
+	}
+
+	codeReviewData, err := r.openaiRepository.ReviewRelativeChanges(ctx, repository.ReviewRelativeChangesInput{
+		CompletionInput: r.newCompletionInput(codeReviewMessageBox),
+		ResponseSchema:  newFindingsResponseSchema(),
 	})
 	if err != nil {
-		return "", errors.Wrap(err, "Failed to create release note completion")
+		return nil, errors.Wrap(&LLMError{err: err}, "Failed to create findings completion")
 	}
+	usage.Add(codeReviewMessageBox.Model, codeReviewData.Usage.InputTokens, codeReviewData.Usage.OutputTokens, codeReviewData.Usage.CacheHit, r.prices)
 	codeReviewMessageBox.AppendMessage(codeReviewData.Messages)
 
 	lastAssistantMessage, err := codeReviewMessageBox.GetLastAssistantMessage()
 	if err != nil {
-		return "", errors.Wrap(err, "Failed to get last assistant message")
+		return nil, errors.Wrap(err, "Failed to get last assistant message")
+	}
+
+	findings, err := parseFindings(lastAssistantMessage.Content)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse findings")
+	}
+	return findings, nil
+}
+
+// addFindings collect the findings into the Findings of the merge request,
+// the findings are also anchored as the ReviewComments if the inline comment is enabled
+func (r *mergeRequestReviewer) addFindings(mergeRequest *domain.MergeRequest, findings []domain.Finding) {
+	for _, finding := range findings {
+		finding, ok := mergeRequest.AddFinding(finding)
+		if !ok {
+			r.logger.Info(fmt.Sprintf("Skip finding which doesn't belong to the relative changes: %s:%d", finding.Path, finding.StartLine))
+			continue
+		}
+		if r.inlineComment && !mergeRequest.ProjectConfig.IsDisabled(domain.StageInlineComment) && !mergeRequest.CommentFinding(finding) {
+			r.logger.Info(fmt.Sprintf("Skip review comment which is not anchored to the relative changes: %s:%s", finding.Path, finding.Lines()))
+		}
 	}
-	return lastAssistantMessage.Content, nil
 }
 
-func (r *gitlabMergeRequestReviewer) generateRelativeChangesPrompt(mr *domain.MergeRequest) (string, error) {
-	promptTpl := "Provide your final response in the `markdown` format with the following content:\n" +
-		"- Summary (comment on the overall change instead of specific files within 80 words)\n" +
-		"- Table of files and their summaries. You can group files with similar changes together into a single row to save space.\n\n" +
-		"Avoid additional commentary as this summary will be added as a comment on the GitHub pull request.\n\n" +
-		"## Merge Request Title\n" +
-		"`{{.Title}}`\n\n" +
-		"## Description\n" +
-		"```\n" +
-		"{{.Description}}\n" +
-		"```\n\n" +
-		"## Diff\n" +
-		"```\n" +
-		"{{.FileDiff}}\n" +
-		"```"
-
-	marshalDifference, err := json.Marshal(mr.RelativeChanges)
+// createMergeRequestDiscussions post every ReviewComments as a positioned discussion, a rejected comment doesn't fail the others
+func (r *mergeRequestReviewer) createMergeRequestDiscussions(ctx context.Context, mergeRequest *domain.MergeRequest) {
+	inputs := toCreateMergeRequestDiscussionInputs(mergeRequest)
+	if len(inputs) == 0 {
+		return
+	}
+	if err := r.codeHostRepository.CreateMergeRequestDiscussions(ctx, inputs); err != nil {
+		r.logger.Error(err, "Failed to create discussions")
+	}
+}
+
+func (r *mergeRequestReviewer) generateRelativeChangesPrompt(mr *domain.MergeRequest, relativeChanges []domain.RelativeChange) (string, error) {
+	annotatedChanges := make([]domain.RelativeChange, len(relativeChanges))
+	for i, change := range relativeChanges {
+		annotatedChanges[i] = change.AnnotateLineNumbers()
+	}
+	marshalDifference, err := json.Marshal(annotatedChanges)
 	if err != nil {
 		return "", err
 	}
-	return fillUpTemplate(promptTpl, map[string]string{
-		"Title":       mr.Title,
-		"Description": mr.Description,
-		"FileDiff":    string(marshalDifference),
-	})
+	data := newPromptData(mr)
+	data.Diffs = string(marshalDifference)
+	return r.promptTemplates[PromptRelativeChanges].Render(data)
 }
 
-func (r *gitlabMergeRequestReviewer) generateReleaseNotePrompt() string {
-	prompt := "Create concise release notes in `markdown` format for this pull request, focusing on its purpose and user story. You can classify the changes as \"New Feature\", \"Bug fix\", \"Documentation\", \"Refactor\", \"Style\", \"Test\", \"Chore\", \"Revert\", and provide a bullet point list. For example: \"New Feature: An integrations page was added to the UI\". Keep your response within 50-100 words. Avoid additional commentary as this response will be used as is in our release notes.\n\n" +
-		"Below the release notes, generate a short, celebratory poem about the changes in this PR and add this poem as a quote (> symbol). You can use emojis in the poem, where they are relevant."
-	return prompt
+func (r *mergeRequestReviewer) generateMergeSummariesPrompt(mr *domain.MergeRequest, partialSummaries []string) (string, error) {
+	builder := strings.Builder{}
+	for i, summary := range partialSummaries {
+		builder.WriteString(fmt.Sprintf("### Part %d\n%s\n\n", i+1, summary))
+	}
+	data := newPromptData(mr)
+	data.PartialSummaries = builder.String()
+	return r.promptTemplates[PromptMergeSummaries].Render(data)
+}
+
+// newPromptData fill up the PromptData with the merge request
+func newPromptData(mr *domain.MergeRequest) PromptData {
+	return PromptData{
+		Title:       mr.Title,
+		Description: mr.Description,
+		Author:      mr.Author,
+		Labels:      mr.Labels,
+		Commits:     mr.Commits,
+		Incremental: mr.IsIncremental(),
+	}
+}
+
+// newFindingsResponseSchema return the JSON schema of findingsDto, which follows the restrictions of the OpenAI Structured Outputs,
+// every property is required and the optional one is nullable
+func newFindingsResponseSchema() repository.ResponseSchema {
+	return repository.ResponseSchema{
+		Name:        "report_findings",
+		Description: "Report the findings of the code review",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"findings": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"file":          map[string]any{"type": "string"},
+							"start_line":    map[string]any{"type": "integer"},
+							"end_line":      map[string]any{"type": "integer"},
+							"severity":      map[string]any{"type": "string", "enum": domain.Severities},
+							"category":      map[string]any{"type": "string", "enum": domain.Categories},
+							"message":       map[string]any{"type": "string"},
+							"suggested_fix": map[string]any{"type": []string{"string", "null"}},
+						},
+						"required":             []string{"file", "start_line", "end_line", "severity", "category", "message", "suggested_fix"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"findings"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+// parseFindings parse the json object responded by the LLM, the surrounding markdown code fence is allowed for the providers without structured outputs
+func parseFindings(content string) ([]domain.Finding, error) {
+	content = strings.TrimSpace(content)
+	if start, end := strings.Index(content, "{"), strings.LastIndex(content, "}"); start >= 0 && end > start {
+		content = content[start : end+1]
+	}
+
+	var dto findingsDto
+	if err := json.Unmarshal([]byte(content), &dto); err != nil {
+		return nil, err
+	}
+
+	findings := make([]domain.Finding, len(dto.Findings))
+	for i, finding := range dto.Findings {
+		findings[i] = domain.Finding{
+			Path:      finding.File,
+			StartLine: finding.StartLine,
+			EndLine:   finding.EndLine,
+			Severity:  domain.Severity(finding.Severity),
+			Category:  domain.Category(finding.Category),
+			Message:   finding.Message,
+		}
+		if finding.SuggestedFix != nil {
+			findings[i].SuggestedFix = *finding.SuggestedFix
+		}
+	}
+	return findings, nil
+}
+
+func compilePathFilters(pathFilters []string) ([]*regexp.Regexp, error) {
+	filters := make([]*regexp.Regexp, len(pathFilters))
+	for i, f := range pathFilters {
+		regex, err := regexp.Compile(f)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = regex
+	}
+	return filters, nil
+}
+
+func joinQuoted[T ~string](values []T) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("`%s`", value)
+	}
+	return strings.Join(quoted, ", ")
 }
 
 func fillUpTemplate(tpl string, data interface{}) (string, error) {
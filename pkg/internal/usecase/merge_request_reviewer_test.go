@@ -4,19 +4,24 @@ import (
 	"context"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
 	"gitlab-mr-reviewer/pkg/internal/domain"
 	"gitlab-mr-reviewer/pkg/internal/repository"
 	"gitlab-mr-reviewer/pkg/logging"
+	"gitlab-mr-reviewer/pkg/retry"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
 )
 
-type mockGitlabRepository struct {
+type mockCodeHostRepository struct {
 }
 
-func (m *mockGitlabRepository) ListDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]repository.DiffDto, error) {
+func (m *mockCodeHostRepository) ListDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]repository.DiffDto, error) {
 	diffs := []repository.DiffDto{
 		{
-			Diff:        "@@ -0,0 +1,9 @@\\n+package mutation\\n+\\n+import \\\"github.com/pkg/errors\\\"\\n+\\n+var (\\n+\\tErrorFailedCreateConfigmap = errors.New(\\\"Failed to create configmap\\\")\\n+\\tErrorConfigmapExists       = errors.New(\\\"Configmap already exists\\\")\\n+\\tErrorConfigmapNotFound     = errors.New(\\\"Configmap not found\\\")\\n+)\\n",
+			Diff:        "@@ -0,0 +1,9 @@\n+package mutation\n+\n+import \"github.com/pkg/errors\"\n+\n+var (\n+\tErrorFailedCreateConfigmap = errors.New(\"Failed to create configmap\")\n+\tErrorConfigmapExists       = errors.New(\"Configmap already exists\")\n+\tErrorConfigmapNotFound     = errors.New(\"Configmap not found\")\n+)\n",
 			NewPath:     "internal/mutation/errors.go",
 			OldPath:     "internal/mutation/errors.go",
 			NewFile:     true,
@@ -24,10 +29,36 @@ func (m *mockGitlabRepository) ListDiffByMergeRequestId(ctx context.Context, pro
 			DeletedFile: false,
 		},
 	}
+
+	switch mergeRequestId {
+	case 5:
+		// a merge request with the diffs which are too large
+		diffs = append(diffs,
+			repository.DiffDto{NewPath: "internal/mutation/generated.go", OldPath: "internal/mutation/generated.go", TooLarge: true},
+			repository.DiffDto{NewPath: "internal/mutation/collapsed.go", OldPath: "internal/mutation/collapsed.go", Collapsed: true},
+		)
+	case 2:
+		// a large merge request which exceeds the MaxInputToken
+		for _, path := range []string{"internal/webhook/errors.go", "internal/repository/errors.go"} {
+			diff := diffs[0]
+			diff.NewPath, diff.OldPath = path, path
+			diffs = append(diffs, diff)
+		}
+	}
 	return diffs, nil
 }
 
-func (m *mockGitlabRepository) GetMergeRequest(ctx context.Context, projectId, mergeRequestId int32) (*repository.MergeRequestDto, error) {
+func (m *mockCodeHostRepository) ListRawDiffByMergeRequestId(ctx context.Context, projectId, mergeRequestId int32) ([]repository.DiffDto, error) {
+	return []repository.DiffDto{
+		{
+			Diff:    "@@ -1,2 +1,2 @@\n package mutation\n-var generated = 1\n+var generated = 2\n",
+			NewPath: "internal/mutation/generated.go",
+			OldPath: "internal/mutation/generated.go",
+		},
+	}, nil
+}
+
+func (m *mockCodeHostRepository) GetMergeRequest(ctx context.Context, projectId, mergeRequestId int32) (*repository.MergeRequestDto, error) {
 	mr := &repository.MergeRequestDto{
 		ProjectId:   projectId,
 		Id:          mergeRequestId,
@@ -47,16 +78,138 @@ func (m *mockGitlabRepository) GetMergeRequest(ctx context.Context, projectId, m
 	return mr, nil
 }
 
-func (m *mockGitlabRepository) CreateMergeRequestSummary(ctx context.Context, input repository.CreateMergeRequestSummaryInput) error {
+func (m *mockCodeHostRepository) CreateMergeRequestSummary(ctx context.Context, input repository.CreateMergeRequestSummaryInput) error {
+	return nil
+}
+
+func (m *mockCodeHostRepository) CreateMergeRequestDiscussion(ctx context.Context, input repository.CreateMergeRequestDiscussionInput) error {
+	return nil
+}
+
+func (m *mockCodeHostRepository) CreateMergeRequestDiscussions(ctx context.Context, inputs []repository.CreateMergeRequestDiscussionInput) error {
+	return nil
+}
+
+func (m *mockCodeHostRepository) ListMergeRequestNotes(ctx context.Context, projectId, mergeRequestId int32) ([]repository.NoteDto, error) {
+	return nil, nil
+}
+
+func (m *mockCodeHostRepository) UpdateMergeRequestNote(ctx context.Context, projectId, mergeRequestId int32, noteId int64, body string) error {
 	return nil
 }
 
+func (m *mockCodeHostRepository) GetReviewedHeadSha(ctx context.Context, projectId, mergeRequestId int32) (string, error) {
+	switch mergeRequestId {
+	case 3:
+		// reviewed before the later commits are pushed
+		return "5a3c9f6a1b5e0c4d1b3b2d6f8e2a9c0d7e4f1a2b", nil
+	case 4:
+		// reviewed at the current head
+		return "94e7e0bb7144018e544743e1d6f22731f8ddeba1", nil
+	case 6:
+		// reviewed before the merge request is rebased
+		return rebasedHeadSha, nil
+	}
+	return "", nil
+}
+
+func (m *mockCodeHostRepository) GetFile(ctx context.Context, projectId int32, path, ref string) ([]byte, error) {
+	switch projectId {
+	case 2:
+		return []byte(projectConfig), nil
+	case 3:
+		return []byte("DisabledStages:\n  - poem\n"), nil
+	case 7:
+		return []byte("DisabledStages:\n  - findings\n"), nil
+	case 6:
+		// the merge request adds the project config, which the target branch doesn't have
+		if ref == "94e7e0bb7144018e544743e1d6f22731f8ddeba1" {
+			return []byte(projectConfig), nil
+		}
+	case 4:
+		if path == ProjectConfigFile {
+			break
+		}
+		return []byte("package mutation\n\nimport \"github.com/pkg/errors\"\n\nvar (\n\tErrorFailedCreateConfigmap = errors.New(\"Failed to create configmap\")\n\tErrorConfigmapExists       = errors.New(\"Configmap already exists\")\n\tErrorConfigmapNotFound     = errors.New(\"Configmap not found\")\n)\n"), nil
+	}
+	return nil, retry.NewStatusError(http.StatusNotFound, "404 File Not Found")
+}
+
+const projectConfig = `
+Instructions: Focus on the error handling.
+Language: Japanese
+Guidelines:
+  - Path: internal/mutation/.*
+    Instructions: Keep the errors as the sentinel errors.
+  - Path: cmd/.*
+    Instructions: Keep the flags backward compatible.
+DisabledStages:
+  - release_note
+  - inline_comment
+`
+
+func (m *mockCodeHostRepository) ListMergeRequestCommits(ctx context.Context, projectId, mergeRequestId int32) ([]repository.CommitDto, error) {
+	if projectId == 5 {
+		return nil, retry.NewStatusError(http.StatusInternalServerError, "500 Internal Server Error")
+	}
+	return []repository.CommitDto{
+		{Id: "1a2b3c4d5e6f", Title: "feat(mutation): add the error variables", Message: "feat(mutation): add the error variables\n\nThe errors are compared with errors.Is.\n"},
+		{Id: "6f5e4d3c2b1a", Title: "fix typo", Message: "fix typo\n"},
+	}, nil
+}
+
+// rebasedHeadSha is the head sha reviewed before a rebase, which isn't an ancestor of the current head
+const rebasedHeadSha = "0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d"
+
+func (m *mockCodeHostRepository) ListComparedCommits(ctx context.Context, projectId int32, from, to string) ([]repository.CommitDto, error) {
+	compare, err := m.CompareCommits(ctx, projectId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return compare.Commits, nil
+}
+
+func (m *mockCodeHostRepository) CompareCommits(ctx context.Context, projectId int32, from, to string) (*repository.CompareDto, error) {
+	if to == rebasedHeadSha {
+		return &repository.CompareDto{Commits: []repository.CommitDto{{Id: rebasedHeadSha, Title: "feat(mutation): add the error variables"}}}, nil
+	}
+	return &repository.CompareDto{
+		Diffs: []repository.DiffDto{
+			{
+				Diff:    "@@ -1,3 +1,3 @@\n package mutation\n-import \"errors\"\n+import \"github.com/pkg/errors\"\n \n",
+				NewPath: "internal/mutation/errors.go",
+				OldPath: "internal/mutation/errors.go",
+			},
+		},
+	}, nil
+}
+
 type mockOpenaiRepository struct {
-	relativeChangesSummary string
-	releaseNoteSummary     string
+	// mutex guards the recorded requests, since the batches are reviewed concurrently
+	mutex                         sync.Mutex
+	summarizeRelativeChangesCount int
+	systemMessage                 string
+	relativeChangesPrompt         string
+	relativeChangesSummary        string
+	releaseNoteSummary            string
+	// releaseNoteContext is the messages which the release note is asked with
+	releaseNoteContext []domain.Message
+	findings           string
+	// failOn fails the summaries of the prompts which contain it
+	failOn string
+	// failReleaseNote fails the release note, after the relative changes are summarized
+	failReleaseNote bool
 }
 
 func (m *mockOpenaiRepository) SummarizeRelativeChanges(ctx context.Context, input repository.SummarizeRelativeChangesInput) (repository.SummarizeRelativeChangesOutput, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.summarizeRelativeChangesCount++
+	m.systemMessage = input.MessageContext[0].Content
+	m.relativeChangesPrompt = input.MessageContext[len(input.MessageContext)-1].Content
+	if len(m.failOn) > 0 && strings.Contains(m.relativeChangesPrompt, m.failOn) {
+		return repository.SummarizeRelativeChangesOutput{}, errors.New("Failed to summarize relative changes")
+	}
 	return repository.SummarizeRelativeChangesOutput{
 		Messages: []domain.Message{
 			{
@@ -64,10 +217,17 @@ func (m *mockOpenaiRepository) SummarizeRelativeChanges(ctx context.Context, inp
 				Content: m.relativeChangesSummary,
 			},
 		},
+		Usage: repository.Usage{InputTokens: 1000, OutputTokens: 100},
 	}, nil
 }
 
 func (m *mockOpenaiRepository) SummarizeReleaseNote(ctx context.Context, input repository.SummarizeReleaseNoteInput) (repository.SummarizeReleaseNoteOutput, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.releaseNoteContext = input.MessageContext
+	if m.failReleaseNote {
+		return repository.SummarizeReleaseNoteOutput{}, errors.New("Failed to summarize release note")
+	}
 	return repository.SummarizeReleaseNoteOutput{
 		Messages: []domain.Message{
 			{
@@ -75,36 +235,69 @@ func (m *mockOpenaiRepository) SummarizeReleaseNote(ctx context.Context, input r
 				Content: m.releaseNoteSummary,
 			},
 		},
+		Usage: repository.Usage{InputTokens: 1000, OutputTokens: 100},
+	}, nil
+}
+
+func (m *mockOpenaiRepository) ReviewRelativeChanges(ctx context.Context, input repository.ReviewRelativeChangesInput) (repository.ReviewRelativeChangesOutput, error) {
+	return repository.ReviewRelativeChangesOutput{
+		Messages: []domain.Message{
+			{
+				Role:    domain.RoleAssistant,
+				Content: m.findings,
+			},
+		},
+		Usage: repository.Usage{InputTokens: 1000, OutputTokens: 100},
 	}, nil
 }
 
+type mockUsageReportRepository struct {
+	reports []repository.UsageReportDto
+}
+
+func (m *mockUsageReportRepository) SaveUsageReport(ctx context.Context, report repository.UsageReportDto) error {
+	m.reports = append(m.reports, report)
+	return nil
+}
+
+var prices = domain.PriceTable{{Model: "gpt-4o-mini", Input: 0.15, Output: 0.6}}
+
 func TestMergeRequestReviewer(t *testing.T) {
 	gomega.RegisterTestingT(t)
 
 	var _ = ginkgo.Describe("MergeRequestReviewer", ginkgo.Ordered, func() {
 		var logger *logging.ZaprLogger
+		var promptTemplates PromptTemplates
 		var mergerRequestReviewer MergeRequestReviewer
 
 		relativeChangesSummary := "## Summary(Fake Response)\n\nThis merge request introduces a `ConfigMapRepository` interface for Kubernetes API interaction and refactors the sidecar mutator logic, focusing on improved error handling and maintainability. The update enhances performance and reliability by centralizing error management with defined error variables and replacing hardcoded configuration strings with constants.\n\n| Files / Grouped Changes                                           | Summary                                                                                     |\n|-------------------------------------------------------------------|---------------------------------------------------------------------------------------------|\n| `internal/mutation/repository/configmap_repository.go`, `configmap_repository_impl.go` | Implements `ConfigMapRepository` interface for standardized configmap operations.          |\n| `beyla_sidecar_mutator.go`, `pod_webhook_handler.go`, `pod_webhook_handler_test.go` | Refactors mutator logic for better error handling, logging, and utilizes the new repository interface. |\n| `mutation/configmap_mutator.go`                                   | Removes redundant code replaced by the new `ConfigMapRepository` structure.                 |\n| `mutation/constants.go`                                           | Updates configuration path constants for better consistency and manageability.             |\n| `mutation/errors.go`                                              | Introduces custom error variables for precise error management during configmap operations.|\n| `pkg/dependencies_injection/injector.go`                          | Updates dependency injection to utilize the new `ConfigMapRepository`.                     |\n"
 		releaseNoteSummary := "### Release Notes(Fake Response)\n\n**New Feature:**\n- Introduced a `ConfigMapRepository` interface to streamline interactions with Kubernetes' API.\n\n**Refactor:**\n- Enhanced error handling and logging in the sidecar mutator by leveraging the new repository.\n- Replaced hardcoded configuration paths with constants for improved maintainability.\n\n**Chore:**\n- Added defined error variables for better error management in configmap operations.\n\n> In Kubernetes' flow, a shift takes place,  \n> ConfigMaps now fit with more grace.  \n> With errors handled and paths aligned,  \n> Sidecars move forward, redefined.  \n> 🎨 A refactor polished, a feature now bright,  \n> Our journey continues with delight! 🚀"
+		findings := "```json\n{\"findings\": [\n" +
+			"  {\"file\": \"internal/mutation/errors.go\", \"start_line\": 6, \"end_line\": 8, \"severity\": \"minor\", \"category\": \"maintainability\", \"message\": \"Error strings should not be capitalized.\", \"suggested_fix\": \"\\tErrorFailedCreateConfigmap = errors.New(\\\"failed to create configmap\\\")\"},\n" +
+			"  {\"file\": \"internal/mutation/errors.go\", \"start_line\": 20, \"end_line\": 21, \"severity\": \"critical\", \"category\": \"security\", \"message\": \"Outside of the diff.\", \"suggested_fix\": null},\n" +
+			"  {\"file\": \"internal/mutation/not_exists.go\", \"start_line\": 1, \"end_line\": 1, \"severity\": \"major\", \"category\": \"logic\", \"message\": \"This file is not changed.\", \"suggested_fix\": null}\n" +
+			"]}\n```"
 
 		ginkgo.BeforeAll(func() {
 			var err error
 			var systemMessage string
-			var gitlabRepository repository.GitlabRepository
+			var gitlabRepository repository.CodeHostRepository
 			var llmRepository repository.LLMRepository
 			var pathFilters = []string{}
 
 			logger, err = logging.NewZaprLogger(false, "info")
 			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			promptTemplates, err = NewPromptTemplates("")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
 
-			gitlabRepository = &mockGitlabRepository{}
+			gitlabRepository = &mockCodeHostRepository{}
 			llmRepository = &mockOpenaiRepository{
 				relativeChangesSummary: relativeChangesSummary,
 				releaseNoteSummary:     releaseNoteSummary,
+				findings:               findings,
 			}
 
-			mergerRequestReviewer, err = NewGitlabMergeRequestReviewer(logger, systemMessage, pathFilters, gitlabRepository, llmRepository)
+			mergerRequestReviewer, err = NewMergeRequestReviewer(logger, systemMessage, promptTemplates, pathFilters, true, true, false, 0, prices, 4, false, gitlabRepository, llmRepository, &mockUsageReportRepository{})
 			gomega.Expect(err).ToNot(gomega.HaveOccurred())
 		})
 
@@ -124,6 +317,386 @@ func TestMergeRequestReviewer(t *testing.T) {
 			gomega.Expect(output.SummarizeReleaseNote).To(gomega.Equal(releaseNoteSummary))
 			gomega.Expect(output.SummarizeRelativeChanges).To(gomega.Equal(relativeChangesSummary))
 
+			ginkgo.By("findings should belong to the relative changes")
+			gomega.Expect(output.Findings).To(gomega.HaveLen(2))
+			gomega.Expect(output.Findings[0].Severity).To(gomega.Equal(domain.SeverityMinor))
+			gomega.Expect(output.Findings[0].SuggestedFix).ToNot(gomega.BeEmpty())
+			gomega.Expect(output.Findings[1].Severity).To(gomega.Equal(domain.SeverityCritical))
+
+			ginkgo.By("review comments should be anchored to the relative changes")
+			gomega.Expect(output.ReviewComments).To(gomega.HaveLen(1))
+			gomega.Expect(output.ReviewComments[0].NewPath).To(gomega.Equal("internal/mutation/errors.go"))
+			gomega.Expect(output.ReviewComments[0].NewLine).To(gomega.Equal(int32(6)))
+			gomega.Expect(output.ReviewComments[0].Body).To(gomega.ContainSubstring("Error strings should not be capitalized."))
+
+			ginkgo.By("the versions of the prompt templates should be recorded")
+			gomega.Expect(output.PromptVersions).To(gomega.ContainElement(gomega.HavePrefix("relative_changes@v2+")))
+
+		})
+		ginkgo.It("Should summarize in batches when exceeding MaxInputToken", func() {
+			ctx := context.Background()
+			partialSummary := "## Summary(Fake Response)\n\nAdd error variables."
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: partialSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               `{"findings": []}`,
+			}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "", promptTemplates, []string{}, true, true, false, 0, prices, 4, false, &mockCodeHostRepository{}, llmRepository, &mockUsageReportRepository{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 2,
+				Model:          "gpt-4o-mini",
+				MaxInputToken:  400,
+			})
+
+			ginkgo.By("output should not error")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(output).ToNot(gomega.BeNil())
+
+			ginkgo.By("every batch and the merge should be summarized")
+			gomega.Expect(llmRepository.summarizeRelativeChangesCount).To(gomega.Equal(4))
+			gomega.Expect(output.SummarizeRelativeChanges).To(gomega.Equal(partialSummary))
+		})
+		ginkgo.It("Should report the files of the failed batch and keep the others", func() {
+			ctx := context.Background()
+			partialSummary := "## Summary(Fake Response)\n\nAdd error variables."
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: partialSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               `{"findings": []}`,
+				failOn:                 "internal/webhook/errors.go",
+			}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "", promptTemplates, []string{}, true, true, false, 0, prices, 2, false, &mockCodeHostRepository{}, llmRepository, &mockUsageReportRepository{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 2,
+				Model:          "gpt-4o-mini",
+				MaxInputToken:  400,
+			})
+
+			ginkgo.By("the review should be posted, and fail as partial")
+			gomega.Expect(errors.Is(err, ErrorPartialReview)).To(gomega.BeTrue())
+			gomega.Expect(output.SummarizeRelativeChanges).To(gomega.Equal(partialSummary))
+
+			ginkgo.By("the files of the failed batch should be reported")
+			gomega.Expect(output.OmittedChanges).To(gomega.HaveLen(1))
+			gomega.Expect(output.OmittedChanges[0].Path).To(gomega.Equal("internal/webhook/errors.go"))
+			gomega.Expect(output.OmittedChanges[0].Reason).To(gomega.Equal("the review failed"))
+		})
+		ginkgo.It("Should review every file in its own batch", func() {
+			ctx := context.Background()
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: relativeChangesSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               findings,
+			}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "", promptTemplates, []string{}, true, false, false, 0, prices, 4, true, &mockCodeHostRepository{}, llmRepository, &mockUsageReportRepository{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			_, err = mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 2,
+				Model:          "gpt-4o-mini",
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			ginkgo.By("every file should be summarized, and the summaries merged")
+			gomega.Expect(llmRepository.summarizeRelativeChangesCount).To(gomega.Equal(4))
+		})
+		ginkgo.It("Should report the diffs which are too large", func() {
+			ctx := context.Background()
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 5,
+				Model:          "gpt-4o-mini",
+			})
+
+			ginkgo.By("output should not error")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(output).ToNot(gomega.BeNil())
+
+			ginkgo.By("the diff which can't be re-fetched should be reported")
+			gomega.Expect(output.OmittedChanges).To(gomega.HaveLen(1))
+			gomega.Expect(output.OmittedChanges[0].Path).To(gomega.Equal("internal/mutation/collapsed.go"))
+		})
+		ginkgo.It("Should only review the commits pushed since the last review", func() {
+			ctx := context.Background()
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 3,
+				Model:          "gpt-4o-mini",
+			})
+
+			ginkgo.By("output should not error")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(output).ToNot(gomega.BeNil())
+			gomega.Expect(output.ReviewedHeadSha).To(gomega.Equal("5a3c9f6a1b5e0c4d1b3b2d6f8e2a9c0d7e4f1a2b"))
+
+			ginkgo.By("review comments should be anchored to the later commits")
+			gomega.Expect(output.ReviewComments).To(gomega.BeEmpty())
+		})
+		ginkgo.It("Should review the whole merge request after a rebase", func() {
+			ctx := context.Background()
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 6,
+				Model:          "gpt-4o-mini",
+			})
+
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(output.ReviewedHeadSha).To(gomega.BeEmpty())
+			gomega.Expect(output.ReviewComments).To(gomega.HaveLen(1))
+		})
+		ginkgo.It("Should write the release note from the summary alone", func() {
+			ctx := context.Background()
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: relativeChangesSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               findings,
+			}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "You are a reviewer.", promptTemplates, []string{}, true, false, false, 0, prices, 4, false, &mockCodeHostRepository{}, llmRepository, &mockUsageReportRepository{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(output.SummarizeReleaseNote).To(gomega.Equal(releaseNoteSummary))
+
+			ginkgo.By("the context should be the system message and the prompt with the summary")
+			gomega.Expect(llmRepository.releaseNoteContext).To(gomega.HaveLen(2))
+			gomega.Expect(llmRepository.releaseNoteContext[0].Role).To(gomega.Equal(domain.RoleSystem))
+			gomega.Expect(llmRepository.releaseNoteContext[1].Content).To(gomega.ContainSubstring(relativeChangesSummary))
+			gomega.Expect(llmRepository.releaseNoteContext[1].Content).ToNot(gomega.ContainSubstring("Error strings should not be capitalized."))
+		})
+		ginkgo.It("Should merge the project config over the config", func() {
+			ctx := context.Background()
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: relativeChangesSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               findings,
+			}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "You are a reviewer.", promptTemplates, []string{}, true, false, false, 0, prices, 4, false, &mockCodeHostRepository{}, llmRepository, &mockUsageReportRepository{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      2,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			ginkgo.By("the instructions, the guidelines of the changed paths and the language should be appended to the system message")
+			gomega.Expect(llmRepository.systemMessage).To(gomega.HavePrefix("You are a reviewer.\n\nFocus on the error handling."))
+			gomega.Expect(llmRepository.systemMessage).To(gomega.ContainSubstring("Keep the errors as the sentinel errors."))
+			gomega.Expect(llmRepository.systemMessage).ToNot(gomega.ContainSubstring("Keep the flags backward compatible."))
+			gomega.Expect(llmRepository.systemMessage).To(gomega.ContainSubstring("Japanese"))
+
+			ginkgo.By("the disabled stages should be skipped")
+			gomega.Expect(output.SummarizeReleaseNote).To(gomega.BeEmpty())
+			gomega.Expect(output.Findings).To(gomega.HaveLen(2))
+			gomega.Expect(output.ReviewComments).To(gomega.BeEmpty())
+		})
+		ginkgo.It("Should comment the normalized finding", func() {
+			ctx := context.Background()
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: relativeChangesSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               `{"findings": [{"file": "internal/mutation/errors.go", "start_line": 6, "end_line": 6, "severity": "Major", "category": "SECURITY", "message": "Check the input.", "suggested_fix": null}]}`,
+			}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "", promptTemplates, []string{}, true, false, false, 0, prices, 4, false, &mockCodeHostRepository{}, llmRepository, &mockUsageReportRepository{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(output.ReviewComments).To(gomega.HaveLen(1))
+			gomega.Expect(output.ReviewComments[0].Body).To(gomega.HavePrefix(":orange_circle: **high** · security"))
+		})
+		ginkgo.It("Should take the policy of the project config from the base commit", func() {
+			ctx := context.Background()
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: relativeChangesSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               findings,
+			}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "You are a reviewer.", promptTemplates, []string{}, true, false, false, 0, prices, 4, false, &mockCodeHostRepository{}, llmRepository, &mockUsageReportRepository{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      6,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			ginkgo.By("the instructions of the head commit should be appended to the system message")
+			gomega.Expect(llmRepository.systemMessage).To(gomega.HavePrefix("You are a reviewer.\n\nFocus on the error handling."))
+
+			ginkgo.By("the stages disabled by the merge request should not be skipped")
+			gomega.Expect(output.SummarizeReleaseNote).To(gomega.Equal(releaseNoteSummary))
+			gomega.Expect(output.ReviewComments).ToNot(gomega.BeEmpty())
+		})
+		ginkgo.It("Should not disable the findings with fail-on", func() {
+			ctx := context.Background()
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: relativeChangesSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               findings,
+			}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "You are a reviewer.", promptTemplates, []string{}, true, false, false, 0, prices, 4, false, &mockCodeHostRepository{}, llmRepository, &mockUsageReportRepository{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      7,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+				FailOn:         domain.SeverityCritical,
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(output.Findings).To(gomega.HaveLen(2))
+		})
+		ginkgo.It("Should add the lines of the file around the hunks", func() {
+			ctx := context.Background()
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: relativeChangesSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               findings,
+			}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "", promptTemplates, []string{}, true, false, false, 3, prices, 4, false, &mockCodeHostRepository{}, llmRepository, &mockUsageReportRepository{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			_, err = mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      4,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(llmRepository.relativeChangesPrompt).To(gomega.ContainSubstring(`"Context":"    1 package mutation\n`))
+		})
+		ginkgo.It("Should add the commits to the prompt and check Conventional Commits", func() {
+			ctx := context.Background()
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: relativeChangesSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               findings,
+			}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "", promptTemplates, []string{}, true, false, true, 0, prices, 4, false, &mockCodeHostRepository{}, llmRepository, &mockUsageReportRepository{})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			ginkgo.By("the titles and the messages of the commits should be in the prompt")
+			gomega.Expect(llmRepository.relativeChangesPrompt).To(gomega.ContainSubstring("- feat(mutation): add the error variables\nThe errors are compared with errors.Is.\n"))
+			gomega.Expect(llmRepository.relativeChangesPrompt).To(gomega.ContainSubstring("- fix typo\n"))
+
+			ginkgo.By("the commits not following Conventional Commits should be reported")
+			gomega.Expect(output.NonConventionalCommits).To(gomega.HaveLen(1))
+			gomega.Expect(output.NonConventionalCommits[0].Sha).To(gomega.Equal("6f5e4d3c2b1a"))
+		})
+		ginkgo.It("Should report the token usage of the completions", func() {
+			ctx := context.Background()
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: relativeChangesSummary,
+				releaseNoteSummary:     releaseNoteSummary,
+				findings:               findings,
+			}
+			usageReportRepository := &mockUsageReportRepository{}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "", promptTemplates, []string{}, true, false, false, 0, prices, 4, false, &mockCodeHostRepository{}, llmRepository, usageReportRepository)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			ginkgo.By("the summary, the findings and the release note should be priced")
+			gomega.Expect(output.Usage.Models).To(gomega.HaveLen(1))
+			gomega.Expect(output.Usage.Models[0].Completions).To(gomega.Equal(3))
+			gomega.Expect(output.Usage.InputTokens).To(gomega.Equal(int64(3000)))
+			gomega.Expect(output.Usage.OutputTokens).To(gomega.Equal(int64(300)))
+			gomega.Expect(output.Usage.Cost).To(gomega.BeNumerically("~", 0.00063, 1e-12))
+
+			ginkgo.By("the usage should be saved as the report")
+			gomega.Expect(usageReportRepository.reports).To(gomega.HaveLen(1))
+			gomega.Expect(usageReportRepository.reports[0].ProjectId).To(gomega.Equal(int32(1)))
+			gomega.Expect(usageReportRepository.reports[0].HeadSha).To(gomega.Equal("94e7e0bb7144018e544743e1d6f22731f8ddeba1"))
+			gomega.Expect(usageReportRepository.reports[0].ReviewedAt).ToNot(gomega.BeZero())
+			gomega.Expect(usageReportRepository.reports[0].Cost).To(gomega.Equal(output.Usage.Cost))
+		})
+		ginkgo.It("Should save the usage report of the failed review", func() {
+			ctx := context.Background()
+			llmRepository := &mockOpenaiRepository{
+				relativeChangesSummary: relativeChangesSummary,
+				findings:               findings,
+				failReleaseNote:        true,
+			}
+			usageReportRepository := &mockUsageReportRepository{}
+			mergerRequestReviewer, err := NewMergeRequestReviewer(logger, "", promptTemplates, []string{}, true, false, false, 0, prices, 4, false, &mockCodeHostRepository{}, llmRepository, usageReportRepository)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			_, err = mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+			})
+			gomega.Expect(err).To(gomega.HaveOccurred())
+
+			ginkgo.By("the tokens spent before the failure should be reported")
+			gomega.Expect(usageReportRepository.reports).To(gomega.HaveLen(1))
+			gomega.Expect(usageReportRepository.reports[0].InputTokens).To(gomega.BeNumerically(">", 0))
+		})
+		ginkgo.It("Should review without the commits if failed to list them", func() {
+			ctx := context.Background()
+
+			output, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      5,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(output.SummarizeRelativeChanges).To(gomega.Equal(relativeChangesSummary))
+		})
+		ginkgo.It("Should fail with the invalid project config", func() {
+			ctx := context.Background()
+
+			_, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      3,
+				MergeRequestId: 1,
+				Model:          "gpt-4o-mini",
+			})
+
+			gomega.Expect(errors.Is(err, ErrorInvalidProjectConfig)).To(gomega.BeTrue())
+		})
+		ginkgo.It("Should ignore code review of the reviewed head sha", func() {
+			ctx := context.Background()
+
+			_, err := mergerRequestReviewer.Apply(ctx, &MergeRequestReviewInput{
+				ProjectId:      1,
+				MergeRequestId: 4,
+				Model:          "gpt-4o-mini",
+			})
+
+			gomega.Expect(err).To(gomega.Equal(ErrorIgnoreCodeReview))
 		})
 		ginkgo.It("Should ignore code review", func() {
 			ctx := context.Background()
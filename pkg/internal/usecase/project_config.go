@@ -0,0 +1,45 @@
+package usecase
+
+import (
+	"bytes"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"regexp"
+)
+
+// ProjectConfigFile is the config kept in the root of the reviewed project
+const ProjectConfigFile = ".gitlab-mr-reviewer.yml"
+
+var ErrorInvalidProjectConfig = errors.New("Invalid project config.")
+
+// parseProjectConfig parse the yaml of the project config, and validate it the same way as the global config
+func parseProjectConfig(content []byte) (*domain.ProjectConfig, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(content)); err != nil {
+		return nil, errors.Wrapf(ErrorInvalidProjectConfig, "failed to read %s: %s", ProjectConfigFile, err)
+	}
+
+	var config domain.ProjectConfig
+	// the unknown keys are rejected, since a typo would silently be ignored
+	if err := v.UnmarshalExact(&config); err != nil {
+		return nil, errors.Wrapf(ErrorInvalidProjectConfig, "failed to unmarshal %s: %s", ProjectConfigFile, err)
+	}
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(config); err != nil {
+		return nil, errors.Wrapf(ErrorInvalidProjectConfig, "failed to validate %s: %s", ProjectConfigFile, err)
+	}
+
+	paths := append([]string{}, config.PathFilters...)
+	for _, guideline := range config.Guidelines {
+		paths = append(paths, guideline.Path)
+	}
+	for _, path := range paths {
+		if _, err := regexp.Compile(path); err != nil {
+			return nil, errors.Wrapf(ErrorInvalidProjectConfig, "failed to compile path %s of %s: %s", path, ProjectConfigFile, err)
+		}
+	}
+	return &config, nil
+}
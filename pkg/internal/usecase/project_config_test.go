@@ -0,0 +1,31 @@
+package usecase
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+)
+
+var _ = ginkgo.Describe("ProjectConfig", func() {
+	ginkgo.It("Should parse the project config", func() {
+		config, err := parseProjectConfig([]byte(projectConfig))
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(config.Language).To(gomega.Equal("Japanese"))
+		gomega.Expect(config.Guidelines).To(gomega.HaveLen(2))
+		gomega.Expect(config.Guidelines[0]).To(gomega.Equal(domain.Guideline{Path: "internal/mutation/.*", Instructions: "Keep the errors as the sentinel errors."}))
+		gomega.Expect(config.DisabledStages).To(gomega.Equal([]domain.Stage{domain.StageReleaseNote, domain.StageInlineComment}))
+	})
+
+	ginkgo.DescribeTable("Should reject the invalid project config",
+		func(content string) {
+			_, err := parseProjectConfig([]byte(content))
+			gomega.Expect(errors.Is(err, ErrorInvalidProjectConfig)).To(gomega.BeTrue())
+		},
+		ginkgo.Entry("invalid yaml", "PathFilters: [\n"),
+		ginkgo.Entry("unknown key", "PathFilter:\n  - .*.md\n"),
+		ginkgo.Entry("unknown stage", "DisabledStages:\n  - poem\n"),
+		ginkgo.Entry("guideline without instructions", "Guidelines:\n  - Path: cmd/.*\n"),
+		ginkgo.Entry("invalid path regexp", "PathFilters:\n  - \"[\"\n"),
+	)
+})
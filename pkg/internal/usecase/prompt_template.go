@@ -0,0 +1,131 @@
+package usecase
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+const (
+	PromptRelativeChanges = "relative_changes"
+	PromptMergeSummaries  = "merge_summaries"
+	PromptReleaseNote     = "release_note"
+	PromptFindings        = "findings"
+
+	promptTemplateExt = ".tmpl"
+	// promptHashLength is the length of the hex digest of the template content which is recorded along with the version
+	promptHashLength = 8
+)
+
+var (
+	//go:embed prompts/*.tmpl
+	defaultPromptTemplates embed.FS
+
+	PromptNames = []string{PromptRelativeChanges, PromptMergeSummaries, PromptReleaseNote, PromptFindings}
+
+	promptVersionRegexp = regexp.MustCompile(`^\{\{-?\s*/\*\s*version:\s*(\S+)\s*\*/\s*-?}}`)
+)
+
+// PromptData is the data which every prompt template is rendered with, see the templates in the prompts directory
+type PromptData struct {
+	Title       string
+	Description string
+	// Author is the username of the author of the merge request
+	Author  string
+	Labels  []string
+	Commits []domain.Commit
+	// Diffs is the relative changes in JSON, whose lines are prefixed with their line numbers in the new file
+	Diffs string
+	// Incremental is whether the Diffs only contain the commits pushed since the previous review
+	Incremental bool
+	// PartialSummaries are the summaries of the batches of a large merge request, as `### Part N` sections
+	PartialSummaries string
+	// Summary is the final summary of the relative changes, which the release note is written from
+	Summary string
+	// Categories are the quoted categories of the findings
+	Categories string
+}
+
+// PromptTemplate is a prompt along with its version, which is declared by the `{{/* version: <version> */}}` comment at the top
+type PromptTemplate struct {
+	Name    string
+	Version string
+	// Hash is the digest of the content, which tells apart the templates edited without bumping the version
+	Hash     string
+	Template string
+}
+
+// PromptTemplates are the templates of every prompt by name
+type PromptTemplates map[string]PromptTemplate
+
+// NewPromptTemplates load the default templates, which are overridden by the `<name>.tmpl` files in the dir if it is given
+func NewPromptTemplates(dir string) (PromptTemplates, error) {
+	templates := PromptTemplates{}
+	for _, name := range PromptNames {
+		content, err := defaultPromptTemplates.ReadFile("prompts/" + name + promptTemplateExt)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[NewPromptTemplates]failed to read default template %s", name)
+		}
+		if len(dir) > 0 {
+			overridden, err := os.ReadFile(filepath.Join(dir, name+promptTemplateExt))
+			switch {
+			case err == nil:
+				content = overridden
+			case !os.IsNotExist(err):
+				return nil, errors.Wrapf(err, "[NewPromptTemplates]failed to read template %s", name)
+			}
+		}
+
+		promptTemplate, err := newPromptTemplate(name, string(content))
+		if err != nil {
+			return nil, errors.Wrap(err, "[NewPromptTemplates]invalid template")
+		}
+		templates[name] = promptTemplate
+	}
+	return templates, nil
+}
+
+func newPromptTemplate(name string, content string) (PromptTemplate, error) {
+	matches := promptVersionRegexp.FindStringSubmatch(content)
+	if matches == nil {
+		return PromptTemplate{}, errors.Errorf("template %s has no version comment at the top", name)
+	}
+	if _, err := template.New(name).Parse(content); err != nil {
+		return PromptTemplate{}, errors.Wrapf(err, "failed to parse template %s", name)
+	}
+	digest := sha256.Sum256([]byte(content))
+	return PromptTemplate{
+		Name:     name,
+		Version:  matches[1],
+		Hash:     hex.EncodeToString(digest[:])[:promptHashLength],
+		Template: strings.TrimSuffix(content, "\n"),
+	}, nil
+}
+
+// Render fill up the template with the data
+func (t PromptTemplate) Render(data PromptData) (string, error) {
+	prompt, err := fillUpTemplate(t.Template, data)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to render prompt %s", t.Name)
+	}
+	return prompt, nil
+}
+
+// Versions return the `<name>@<version>+<hash>` of every template ordered by name, which are recorded with the review
+func (t PromptTemplates) Versions() []string {
+	versions := make([]string, 0, len(t))
+	for name, promptTemplate := range t {
+		versions = append(versions, fmt.Sprintf("%s@%s+%s", name, promptTemplate.Version, promptTemplate.Hash))
+	}
+	slices.Sort(versions)
+	return versions
+}
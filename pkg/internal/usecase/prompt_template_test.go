@@ -0,0 +1,80 @@
+package usecase
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"gitlab-mr-reviewer/pkg/internal/domain"
+	"os"
+	"path/filepath"
+)
+
+// the specs are run by the RunSpecs of TestMergeRequestReviewer, since ginkgo only runs the specs once per package
+var _ = ginkgo.Describe("PromptTemplates", func() {
+	ginkgo.It("Should load the default templates", func() {
+		templates, err := NewPromptTemplates("")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(templates.Versions()).To(gomega.HaveExactElements(
+			gomega.MatchRegexp(`^findings@v2\+[0-9a-f]{8}$`),
+			gomega.MatchRegexp(`^merge_summaries@v1\+[0-9a-f]{8}$`),
+			gomega.MatchRegexp(`^relative_changes@v2\+[0-9a-f]{8}$`),
+			gomega.MatchRegexp(`^release_note@v2\+[0-9a-f]{8}$`),
+		))
+
+		prompt, err := templates[PromptRelativeChanges].Render(PromptData{
+			Title:   "Add error variables",
+			Commits: []domain.Commit{{Sha: "abc", Title: "feat: add error variables"}},
+			Diffs:   `[{"new_path":"errors.go"}]`,
+		})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(prompt).To(gomega.ContainSubstring("Add error variables"))
+		gomega.Expect(prompt).To(gomega.ContainSubstring("feat: add error variables"))
+		gomega.Expect(prompt).To(gomega.ContainSubstring(`[{"new_path":"errors.go"}]`))
+		gomega.Expect(prompt).ToNot(gomega.ContainSubstring("version"))
+	})
+
+	ginkgo.It("Should override the default templates with the dir", func() {
+		dir := ginkgo.GinkgoT().TempDir()
+		err := os.WriteFile(filepath.Join(dir, PromptReleaseNote+".tmpl"), []byte("{{/* version: v2-team */}}Release note of {{.Title}}\n"), 0o644)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		templates, err := NewPromptTemplates(dir)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(templates[PromptReleaseNote].Version).To(gomega.Equal("v2-team"))
+		gomega.Expect(templates[PromptMergeSummaries].Version).To(gomega.Equal("v1"))
+
+		prompt, err := templates[PromptReleaseNote].Render(PromptData{Title: "Add error variables"})
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(prompt).To(gomega.Equal("Release note of Add error variables"))
+	})
+
+	ginkgo.It("Should tell apart the templates edited without bumping the version", func() {
+		dir := ginkgo.GinkgoT().TempDir()
+		err := os.WriteFile(filepath.Join(dir, PromptReleaseNote+".tmpl"), []byte("{{/* version: v2 */}}Release note of {{.Title}}\n"), 0o644)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		defaultTemplates, err := NewPromptTemplates("")
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		templates, err := NewPromptTemplates(dir)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		gomega.Expect(templates[PromptReleaseNote].Version).To(gomega.Equal(defaultTemplates[PromptReleaseNote].Version))
+		gomega.Expect(templates.Versions()).ToNot(gomega.Equal(defaultTemplates.Versions()))
+	})
+
+	ginkgo.It("Should fail without the version comment", func() {
+		dir := ginkgo.GinkgoT().TempDir()
+		err := os.WriteFile(filepath.Join(dir, PromptFindings+".tmpl"), []byte("Review {{.Diffs}}"), 0o644)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		_, err = NewPromptTemplates(dir)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("Should fail with an invalid template", func() {
+		dir := ginkgo.GinkgoT().TempDir()
+		err := os.WriteFile(filepath.Join(dir, PromptFindings+".tmpl"), []byte("{{/* version: v2 */}}Review {{.Diffs"), 0o644)
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		_, err = NewPromptTemplates(dir)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+})
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"context"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	gomega.RegisterTestingT(t)
+
+	var _ = ginkgo.Describe("Metrics", func() {
+		ginkgo.It("Should serve the metrics along with the Go runtime metrics", func() {
+			reviewerMetrics := NewReviewerMetrics()
+			reviewerMetrics.Reviews.WithLabelValues(OutcomePosted).Inc()
+
+			recorder := httptest.NewRecorder()
+			reviewerMetrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`gitlab_mr_reviewer_reviews_total{outcome="posted"} 1` + "\n"))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring("go_goroutines"))
+		})
+
+		ginkgo.It("Should push the metrics to the Pushgateway", func() {
+			reviewerMetrics := NewReviewerMetrics()
+			reviewerMetrics.Reviews.WithLabelValues(OutcomePosted).Inc()
+
+			var method, path string
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path = r.Method, r.URL.EscapedPath()
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			gomega.Expect(reviewerMetrics.Push(context.Background(), &http.Client{}, server.URL, "gitlab-mr-reviewer")).To(gomega.Succeed())
+			gomega.Expect(method).To(gomega.Equal(http.MethodPut))
+			gomega.Expect(path).To(gomega.Equal("/metrics/job/gitlab-mr-reviewer"))
+			gomega.Expect(string(body)).To(gomega.ContainSubstring("gitlab_mr_reviewer_reviews_total"))
+		})
+
+		ginkgo.It("Should fail to push if the Pushgateway rejects the metrics", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+			}))
+			defer server.Close()
+
+			gomega.Expect(NewReviewerMetrics().Push(context.Background(), &http.Client{}, server.URL, "gitlab-mr-reviewer")).ToNot(gomega.Succeed())
+		})
+
+		ginkgo.It("Should observe the duration of the requests until the body is closed", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.(http.Flusher).Flush()
+				time.Sleep(200 * time.Millisecond)
+				w.Write([]byte("404 Not Found"))
+			}))
+			defer server.Close()
+
+			reviewerMetrics := NewReviewerMetrics()
+			client := &http.Client{Transport: NewTransport(nil, reviewerMetrics.RequestDuration, ClientCodeHost)}
+			response, err := client.Get(server.URL + "/api/v4/projects/1/merge_requests/2/notes?page=3")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+			duration := func() *dto.Histogram {
+				metric := &dto.Metric{}
+				observer := reviewerMetrics.RequestDuration.WithLabelValues(ClientCodeHost, "GET /api/v4/projects/:id/merge_requests/:id/notes", "404")
+				gomega.Expect(observer.(prometheus.Metric).Write(metric)).To(gomega.Succeed())
+				return metric.GetHistogram()
+			}
+			ginkgo.By("the request should not be observed until the body is closed")
+			gomega.Expect(duration().GetSampleCount()).To(gomega.BeZero())
+
+			_, err = io.ReadAll(response.Body)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(response.Body.Close()).To(gomega.Succeed())
+			gomega.Expect(response.Body.Close()).To(gomega.Succeed())
+
+			ginkgo.By("the request should be observed once with the time to read the body")
+			gomega.Expect(duration().GetSampleCount()).To(gomega.Equal(uint64(1)))
+			gomega.Expect(duration().GetSampleSum()).To(gomega.BeNumerically(">=", 0.2))
+		})
+
+		ginkgo.It("Should replace the ids, the file paths and the repository names of the endpoint", func() {
+			gomega.Expect(Endpoint(http.MethodGet, "/api/v4/projects/1/repository/files/docs%2Fmain.go/raw")).To(gomega.Equal("GET /api/v4/projects/:id/repository/files/:path/raw"))
+			gomega.Expect(Endpoint(http.MethodGet, "/repositories/1/contents/docs/main.go")).To(gomega.Equal("GET /repositories/:id/contents/:path"))
+			gomega.Expect(Endpoint(http.MethodGet, "/repositories/1/compare/63c97907...94e7e0bb")).To(gomega.Equal("GET /repositories/:id/compare/:refs"))
+			gomega.Expect(Endpoint(http.MethodGet, "/api/v1/repos/owner/repo/raw/docs/main.go")).To(gomega.Equal("GET /api/v1/repos/:owner/:repo/raw/:path"))
+			gomega.Expect(Endpoint(http.MethodGet, "/repos/owner/repo/contents/2024/notes.md")).To(gomega.Equal("GET /repos/:owner/:repo/contents/:path"))
+			gomega.Expect(Endpoint(http.MethodGet, "/api/v1/repos/owner/repo/raw/deadbeef/x.go")).To(gomega.Equal("GET /api/v1/repos/:owner/:repo/raw/:path"))
+			gomega.Expect(Endpoint(http.MethodGet, "/api/v4/projects/1/repository/files/2024/raw")).To(gomega.Equal("GET /api/v4/projects/:id/repository/files/:path/raw"))
+			gomega.Expect(Endpoint(http.MethodPost, "/v1/chat/completions")).To(gomega.Equal("POST /v1/chat/completions"))
+		})
+	})
+
+	ginkgo.RunSpecs(t, "Metrics test")
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"net/http"
+)
+
+const (
+	OutcomePosted  = "posted"
+	OutcomeIgnored = "ignored"
+	OutcomeFailed  = "failed"
+
+	ClientCodeHost = "code_host"
+	ClientLLM      = "llm"
+)
+
+// requestDurationBuckets range up to the minutes of the completions of the large models
+var requestDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// ReviewerMetrics are the metrics of the reviews, the requests to the code host and the LLM, and the tokens,
+// which are registered along with the Go runtime and the process metrics
+type ReviewerMetrics struct {
+	Registry *prometheus.Registry
+	// Reviews has the label outcome, which is one of OutcomePosted, OutcomeIgnored and OutcomeFailed
+	Reviews *prometheus.CounterVec
+	// RequestDuration has the labels client, endpoint and status, the client is ClientCodeHost or ClientLLM
+	RequestDuration *prometheus.HistogramVec
+	// Tokens has the labels model, stage and type, the type is input or output
+	Tokens *prometheus.CounterVec
+}
+
+func NewReviewerMetrics() *ReviewerMetrics {
+	m := &ReviewerMetrics{
+		Registry: prometheus.NewRegistry(),
+		Reviews: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gitlab_mr_reviewer_reviews_total",
+			Help: "Reviews of the merge requests by the outcome.",
+		}, []string{"outcome"}),
+		RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gitlab_mr_reviewer_http_request_duration_seconds",
+			Help:    "Duration of the requests to the code host and the LLM until the response body is closed.",
+			Buckets: requestDurationBuckets,
+		}, []string{"client", "endpoint", "status"}),
+		Tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gitlab_mr_reviewer_llm_tokens_total",
+			Help: "Tokens consumed by the completions.",
+		}, []string{"model", "stage", "type"}),
+	}
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.Reviews,
+		m.RequestDuration,
+		m.Tokens,
+	)
+	return m
+}
+
+// Handler serve the metrics for Prometheus to scrape
+func (m *ReviewerMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// Push replace the metrics of the job on the Pushgateway with the metrics of the registry
+func (m *ReviewerMetrics) Push(ctx context.Context, httpClient *http.Client, pushgatewayUrl string, job string) error {
+	err := push.New(pushgatewayUrl, job).
+		Gatherer(m.Registry).
+		Client(httpClient).
+		PushContext(ctx)
+	return errors.Wrap(err, "Failed to push metrics")
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idSegment is a number or a commit sha in the path, which is replaced so that the endpoints don't grow with the merge requests
+var idSegment = regexp.MustCompile(`^([0-9]+|[0-9a-f]{7,40})$`)
+
+type transport struct {
+	next     http.RoundTripper
+	duration *prometheus.HistogramVec
+	client   string
+}
+
+// NewTransport wrap the next http.RoundTripper, and observe the duration of every request until its response body is closed,
+// so that a streamed completion is observed as a whole. The duration has the labels client, endpoint and status.
+func NewTransport(next http.RoundTripper, duration *prometheus.HistogramVec, client string) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{
+		next:     next,
+		duration: duration,
+		client:   client,
+	}
+}
+
+func (t *transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	start := time.Now()
+	response, err := t.next.RoundTrip(request)
+
+	endpoint := Endpoint(request.Method, request.URL.EscapedPath())
+	if err != nil {
+		t.duration.WithLabelValues(t.client, endpoint, "error").Observe(time.Since(start).Seconds())
+		return response, err
+	}
+	observer := t.duration.WithLabelValues(t.client, endpoint, strconv.Itoa(response.StatusCode))
+	response.Body = &observedBody{ReadCloser: response.Body, observe: func() { observer.Observe(time.Since(start).Seconds()) }}
+	return response, nil
+}
+
+// observedBody observe the duration once when the body is closed
+type observedBody struct {
+	io.ReadCloser
+	once    sync.Once
+	observe func()
+}
+
+func (b *observedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.observe)
+	return err
+}
+
+// Endpoint return the method and the path without the ids, the file paths and the repository names,
+// e.g. GET /api/v4/projects/:id/merge_requests/:id/notes
+func Endpoint(method string, path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(segments); i++ {
+		switch {
+		case i > 0 && (segments[i-1] == "files" || segments[i-1] == "contents" || segments[i-1] == "raw"):
+			// the file path of GitHub and Gitea continues until the end, and is checked first since any of its segments may look like an id
+			if segments[i-1] != "files" {
+				segments = segments[:i+1]
+			}
+			segments[i] = ":path"
+		case idSegment.MatchString(segments[i]):
+			segments[i] = ":id"
+		case i > 0 && segments[i-1] == "compare":
+			segments[i] = ":refs"
+		case i > 0 && segments[i-1] == "repos" && i+1 < len(segments):
+			segments[i], segments[i+1] = ":owner", ":repo"
+			i++
+		}
+	}
+	return method + " /" + strings.Join(segments, "/")
+}
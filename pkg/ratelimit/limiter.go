@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter limits the requests and the tokens sent within a sliding window of a minute,
+// the same way as the requests-per-minute and tokens-per-minute limits of the LLM providers
+type Limiter struct {
+	mutex             sync.Mutex
+	requestsPerWindow int64
+	tokensPerWindow   int64
+	window            time.Duration
+	// sent are the requests sent within the window, oldest first
+	sent []sentRequest
+}
+
+type sentRequest struct {
+	at     time.Time
+	tokens int64
+}
+
+// NewLimiter create the limiter of the requests and the tokens per minute, either is unlimited if it is 0
+func NewLimiter(requestsPerMinute, tokensPerMinute int64) *Limiter {
+	return newLimiter(requestsPerMinute, tokensPerMinute, time.Minute)
+}
+
+func newLimiter(requestsPerWindow, tokensPerWindow int64, window time.Duration) *Limiter {
+	return &Limiter{
+		requestsPerWindow: requestsPerWindow,
+		tokensPerWindow:   tokensPerWindow,
+		window:            window,
+	}
+}
+
+// Wait block until the request of the tokens is able to be sent, or the ctx is done.
+// A request of more tokens than the limit is sent once nothing else is sent within the window, instead of waiting forever.
+func (l *Limiter) Wait(ctx context.Context, tokens int64) error {
+	for {
+		wait := l.reserve(tokens)
+		if wait == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve record the request if it is within the limits, otherwise return how long to wait until the oldest request leaves the window
+func (l *Limiter) reserve(tokens int64) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	expired := 0
+	for expired < len(l.sent) && now.Sub(l.sent[expired].at) >= l.window {
+		expired++
+	}
+	l.sent = l.sent[expired:]
+
+	var sentTokens int64
+	for _, request := range l.sent {
+		sentTokens += request.tokens
+	}
+	overRequests := l.requestsPerWindow > 0 && int64(len(l.sent)) >= l.requestsPerWindow
+	overTokens := l.tokensPerWindow > 0 && len(l.sent) > 0 && sentTokens+tokens > l.tokensPerWindow
+	if overRequests || overTokens {
+		return l.sent[0].at.Add(l.window).Sub(now)
+	}
+
+	l.sent = append(l.sent, sentRequest{at: now, tokens: tokens})
+	return 0
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	gomega.RegisterTestingT(t)
+
+	var _ = ginkgo.Describe("Limiter", func() {
+		window := 100 * time.Millisecond
+
+		ginkgo.It("Should wait for the window when the requests exceed the limit", func() {
+			limiter := newLimiter(2, 0, window)
+			start := time.Now()
+			for range 3 {
+				gomega.Expect(limiter.Wait(context.Background(), 1)).To(gomega.Succeed())
+			}
+			gomega.Expect(time.Since(start)).To(gomega.BeNumerically(">=", window))
+		})
+
+		ginkgo.It("Should wait for the window when the tokens exceed the limit", func() {
+			limiter := newLimiter(0, 100, window)
+			start := time.Now()
+			gomega.Expect(limiter.Wait(context.Background(), 60)).To(gomega.Succeed())
+			gomega.Expect(time.Since(start)).To(gomega.BeNumerically("<", window))
+			gomega.Expect(limiter.Wait(context.Background(), 60)).To(gomega.Succeed())
+			gomega.Expect(time.Since(start)).To(gomega.BeNumerically(">=", window))
+		})
+
+		ginkgo.It("Should send the request of more tokens than the limit alone", func() {
+			limiter := newLimiter(0, 100, window)
+			start := time.Now()
+			gomega.Expect(limiter.Wait(context.Background(), 500)).To(gomega.Succeed())
+			gomega.Expect(time.Since(start)).To(gomega.BeNumerically("<", window))
+		})
+
+		ginkgo.It("Should not limit without the limits", func() {
+			limiter := NewLimiter(0, 0)
+			for range 100 {
+				gomega.Expect(limiter.Wait(context.Background(), 1_000_000)).To(gomega.Succeed())
+			}
+		})
+
+		ginkgo.It("Should stop waiting when the context is done", func() {
+			limiter := newLimiter(1, 0, time.Hour)
+			gomega.Expect(limiter.Wait(context.Background(), 1)).To(gomega.Succeed())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			gomega.Expect(limiter.Wait(ctx, 1)).To(gomega.MatchError(context.DeadlineExceeded))
+		})
+	})
+
+	ginkgo.RunSpecs(t, "Limiter test")
+}
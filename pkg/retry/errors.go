@@ -0,0 +1,49 @@
+package retry
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+var (
+	ErrorUnauthorized = errors.New("Unauthorized, check the access token.")
+	ErrorForbidden    = errors.New("Forbidden, check the permission of the access token.")
+	ErrorNotFound     = errors.New("Not found.")
+	ErrorRateLimited  = errors.New("Rate limited.")
+	ErrorServer       = errors.New("Server error.")
+)
+
+// StatusError is the unexpected status code responded by the server, it unwraps to one of the sentinel errors by the status code
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func NewStatusError(statusCode int, body string) *StatusError {
+	return &StatusError{
+		StatusCode: statusCode,
+		Body:       body,
+	}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("response status code %d, response: %s", e.StatusCode, e.Body)
+}
+
+func (e *StatusError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrorUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrorForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrorNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrorRateLimited
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrorServer
+	default:
+		return nil
+	}
+}
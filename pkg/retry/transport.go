@@ -0,0 +1,243 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"gitlab-mr-reviewer/pkg/logging"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMaxAttempts     = 4
+	defaultInitialInterval = 1 * time.Second
+	defaultMaxInterval     = 30 * time.Second
+
+	// statusOverloaded is responded by Anthropic when the API is temporarily overloaded
+	statusOverloaded = 529
+)
+
+var (
+	retryableStatusCodes = []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		statusOverloaded,
+	}
+	// nonIdempotentRetryableStatusCodes are the status codes which guarantee the request hasn't been processed
+	nonIdempotentRetryableStatusCodes = []int{
+		http.StatusTooManyRequests,
+		http.StatusServiceUnavailable,
+	}
+	idempotentMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete}
+)
+
+type maxAttemptsKey struct{}
+type attemptTimeoutKey struct{}
+
+// Policy decides how many times and how long to wait before a request is retried
+type Policy struct {
+	// MaxAttempts is the attempt budget of a call, including the first attempt
+	MaxAttempts     int
+	InitialInterval time.Duration
+	// MaxInterval caps the backoff and the wait asked by the server
+	MaxInterval time.Duration
+	// RetryNonIdempotent allows to retry the non-idempotent requests such as POST on any retryable status code and the transport errors,
+	// otherwise they are only retried when the server guarantees the request hasn't been processed
+	RetryNonIdempotent bool
+}
+
+// WithMaxAttempts override the attempt budget of the Policy for the calls with the returned context
+func WithMaxAttempts(ctx context.Context, maxAttempts int) context.Context {
+	return context.WithValue(ctx, maxAttemptsKey{}, maxAttempts)
+}
+
+// WithAttemptTimeout limit every attempt of the calls with the returned context, until its response body is closed,
+// so that a stuck attempt is retried instead of consuming the time of the whole call
+func WithAttemptTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, attemptTimeoutKey{}, timeout)
+}
+
+type transport struct {
+	next   http.RoundTripper
+	policy Policy
+	logger *logging.ZaprLogger
+}
+
+// NewTransport wrap the next http.RoundTripper, and retry the failed requests with jittered exponential backoff
+func NewTransport(next http.RoundTripper, policy Policy, logger *logging.ZaprLogger) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultMaxAttempts
+	}
+	if policy.InitialInterval <= 0 {
+		policy.InitialInterval = defaultInitialInterval
+	}
+	if policy.MaxInterval <= 0 {
+		policy.MaxInterval = defaultMaxInterval
+	}
+	return &transport{
+		next:   next,
+		policy: policy,
+		logger: logger,
+	}
+}
+
+// NewHttpClient return a http.Client which retries with the given Policy
+func NewHttpClient(policy Policy, logger *logging.ZaprLogger) *http.Client {
+	return &http.Client{
+		Transport: NewTransport(http.DefaultTransport, policy, logger),
+	}
+}
+
+func (t *transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+	maxAttempts := t.policy.MaxAttempts
+	if attempts, ok := ctx.Value(maxAttemptsKey{}).(int); ok && attempts > 0 {
+		maxAttempts = attempts
+	}
+	// the body has been consumed by the previous attempt, and can't be retried without GetBody
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request = request.Clone(ctx)
+			request.Body = body
+		}
+
+		response, err := t.roundTrip(request)
+		if attempt >= maxAttempts || !t.shouldRetry(request, response, err) {
+			return response, err
+		}
+
+		wait := t.backoff(attempt)
+		if response != nil {
+			if retryAfter, ok := parseRetryAfter(response.Header, time.Now()); ok {
+				wait = min(retryAfter, t.policy.MaxInterval)
+			}
+			// the response is returned as it is, if the call would be done before the next attempt
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+				return response, err
+			}
+			// drain the body to reuse the connection
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+		t.logger.
+			WithValues("method", request.Method).
+			WithValues("url", request.URL.Redacted()).
+			WithValues("attempt", attempt).
+			WithValues("status", statusOf(response)).
+			WithValues("wait", wait.String()).
+			Info(fmt.Sprintf("Retry the failed request: %v", err))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// roundTrip send a single attempt, within the attempt timeout of the context if it is set
+func (t *transport) roundTrip(request *http.Request) (*http.Response, error) {
+	timeout, ok := request.Context().Value(attemptTimeoutKey{}).(time.Duration)
+	if !ok || timeout <= 0 {
+		return t.next.RoundTrip(request)
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), timeout)
+	response, err := t.next.RoundTrip(request.WithContext(ctx))
+	if response == nil {
+		cancel()
+		return response, err
+	}
+	response.Body = &cancelOnClose{ReadCloser: response.Body, cancel: cancel}
+	return response, err
+}
+
+func (t *transport) shouldRetry(request *http.Request, response *http.Response, err error) bool {
+	idempotent := t.policy.RetryNonIdempotent || slices.Contains(idempotentMethods, request.Method)
+	if err != nil {
+		// the context is done, or the request is invalid,
+		// and the non-idempotent request may have been processed before the connection is lost
+		return idempotent && request.Context().Err() == nil && response == nil
+	}
+	if idempotent {
+		return slices.Contains(retryableStatusCodes, response.StatusCode)
+	}
+	return slices.Contains(nonIdempotentRetryableStatusCodes, response.StatusCode)
+}
+
+// cancelOnClose cancel the context of the attempt once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// backoff return the jittered exponential backoff of the attempt, within [interval/2, interval]
+func (t *transport) backoff(attempt int) time.Duration {
+	interval := t.policy.InitialInterval << (attempt - 1)
+	if interval <= 0 || interval > t.policy.MaxInterval {
+		interval = t.policy.MaxInterval
+	}
+	return interval/2 + rand.N(interval/2+1)
+}
+
+// parseRetryAfter parse how long the server asks to wait from the headers
+//   - Retry-After: delay-seconds or HTTP-date, see https://www.rfc-editor.org/rfc/rfc9110#field.retry-after
+//   - Retry-After-Ms: delay-milliseconds, sent by OpenAI
+//   - RateLimit-Reset: delay-seconds, or the unix timestamp sent by Gitlab
+func parseRetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if value := header.Get("Retry-After-Ms"); len(value) > 0 {
+		if milliseconds, err := strconv.ParseFloat(value, 64); err == nil && milliseconds >= 0 {
+			return time.Duration(milliseconds * float64(time.Millisecond)), true
+		}
+	}
+	if value := header.Get("Retry-After"); len(value) > 0 {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+	if value := header.Get("RateLimit-Reset"); len(value) > 0 {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+			// a delay wouldn't be longer than a day, otherwise it is a unix timestamp
+			if seconds > int64((24 * time.Hour).Seconds()) {
+				return max(time.Unix(seconds, 0).Sub(now), 0), true
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+func statusOf(response *http.Response) int {
+	if response == nil {
+		return 0
+	}
+	return response.StatusCode
+}
@@ -0,0 +1,228 @@
+package retry
+
+import (
+	"context"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"gitlab-mr-reviewer/pkg/logging"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(request *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestTransport(t *testing.T) {
+	gomega.RegisterTestingT(t)
+
+	var _ = ginkgo.Describe("Transport", func() {
+		var logger *logging.ZaprLogger
+		policy := Policy{
+			MaxAttempts:     3,
+			InitialInterval: 10 * time.Millisecond,
+			MaxInterval:     20 * time.Millisecond,
+		}
+
+		// runServer respond the status codes in order, and the last one for the rest
+		runServer := func(attempts *atomic.Int32, header http.Header, statusCodes ...int) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempt := int(attempts.Add(1))
+				statusCode := statusCodes[min(attempt, len(statusCodes))-1]
+				if statusCode != http.StatusOK {
+					for key, values := range header {
+						w.Header()[key] = values
+					}
+				}
+				w.WriteHeader(statusCode)
+			}))
+		}
+
+		ginkgo.BeforeEach(func() {
+			var err error
+			logger, err = logging.NewZaprLogger(false, "info")
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("Should retry the rate limited request after Retry-After", func() {
+			var attempts atomic.Int32
+			server := runServer(&attempts, http.Header{"Retry-After": []string{"1"}}, http.StatusTooManyRequests, http.StatusOK)
+			defer server.Close()
+
+			patientPolicy := policy
+			patientPolicy.MaxInterval = 2 * time.Second
+			start := time.Now()
+			response, err := NewHttpClient(patientPolicy, logger).Get(server.URL)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(response.StatusCode).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(2)))
+			gomega.Expect(time.Since(start)).To(gomega.BeNumerically(">=", time.Second))
+		})
+
+		ginkgo.It("Should cap Retry-After at the max interval", func() {
+			var attempts atomic.Int32
+			server := runServer(&attempts, http.Header{"Retry-After": []string{"60"}}, http.StatusTooManyRequests, http.StatusOK)
+			defer server.Close()
+
+			start := time.Now()
+			response, err := NewHttpClient(policy, logger).Get(server.URL)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(response.StatusCode).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(2)))
+			gomega.Expect(time.Since(start)).To(gomega.BeNumerically("<", time.Second))
+		})
+
+		ginkgo.It("Should return the rate limited response if Retry-After passes the deadline", func() {
+			var attempts atomic.Int32
+			server := runServer(&attempts, http.Header{"Retry-After": []string{"1"}}, http.StatusTooManyRequests, http.StatusOK)
+			defer server.Close()
+
+			patientPolicy := policy
+			patientPolicy.MaxInterval = 2 * time.Second
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			response, err := NewHttpClient(patientPolicy, logger).Do(request)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(response.StatusCode).To(gomega.Equal(http.StatusTooManyRequests))
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(1)))
+		})
+
+		ginkgo.It("Should give up after the max attempts", func() {
+			var attempts atomic.Int32
+			server := runServer(&attempts, nil, http.StatusBadGateway)
+			defer server.Close()
+
+			response, err := NewHttpClient(policy, logger).Get(server.URL)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(response.StatusCode).To(gomega.Equal(http.StatusBadGateway))
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(3)))
+		})
+
+		ginkgo.It("Should not retry the not found request", func() {
+			var attempts atomic.Int32
+			server := runServer(&attempts, nil, http.StatusNotFound)
+			defer server.Close()
+
+			response, err := NewHttpClient(policy, logger).Get(server.URL)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(response.StatusCode).To(gomega.Equal(http.StatusNotFound))
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(1)))
+		})
+
+		ginkgo.It("Should not retry the non-idempotent request on bad gateway", func() {
+			var attempts atomic.Int32
+			server := runServer(&attempts, nil, http.StatusBadGateway, http.StatusOK)
+			defer server.Close()
+
+			response, err := NewHttpClient(policy, logger).Post(server.URL, "application/json", strings.NewReader("{}"))
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(response.StatusCode).To(gomega.Equal(http.StatusBadGateway))
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(1)))
+
+			nonIdempotentPolicy := policy
+			nonIdempotentPolicy.RetryNonIdempotent = true
+			response, err = NewHttpClient(nonIdempotentPolicy, logger).Post(server.URL, "application/json", strings.NewReader("{}"))
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(response.StatusCode).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(2)))
+		})
+
+		ginkgo.It("Should not retry the non-idempotent request on transport errors", func() {
+			var attempts atomic.Int32
+			next := roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+				attempts.Add(1)
+				return nil, errors.New("connection reset by peer")
+			})
+
+			client := &http.Client{Transport: NewTransport(next, policy, logger)}
+			_, err := client.Post("http://localhost", "application/json", strings.NewReader("{}"))
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(1)))
+
+			nonIdempotentPolicy := policy
+			nonIdempotentPolicy.RetryNonIdempotent = true
+			client = &http.Client{Transport: NewTransport(next, nonIdempotentPolicy, logger)}
+			_, err = client.Post("http://localhost", "application/json", strings.NewReader("{}"))
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(4)))
+		})
+
+		ginkgo.It("Should retry the attempt which exceeds the attempt timeout", func() {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if attempts.Add(1) == 1 {
+					select {
+					case <-r.Context().Done():
+					case <-time.After(5 * time.Second):
+					}
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("ok"))
+			}))
+			defer server.Close()
+
+			request, err := http.NewRequestWithContext(WithAttemptTimeout(context.Background(), 100*time.Millisecond), http.MethodGet, server.URL, nil)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			response, err := NewHttpClient(policy, logger).Do(request)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			defer response.Body.Close()
+			body, err := io.ReadAll(response.Body)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(string(body)).To(gomega.Equal("ok"))
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(2)))
+		})
+
+		ginkgo.It("Should override the max attempts by context", func() {
+			var attempts atomic.Int32
+			server := runServer(&attempts, nil, http.StatusServiceUnavailable)
+			defer server.Close()
+
+			request, err := http.NewRequestWithContext(WithMaxAttempts(context.Background(), 2), http.MethodGet, server.URL, nil)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			response, err := NewHttpClient(policy, logger).Do(request)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(response.StatusCode).To(gomega.Equal(http.StatusServiceUnavailable))
+			gomega.Expect(attempts.Load()).To(gomega.Equal(int32(2)))
+		})
+
+		ginkgo.It("Should parse the wait from headers", func() {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			wait, ok := parseRetryAfter(http.Header{"Retry-After-Ms": []string{"1500"}}, now)
+			gomega.Expect(ok).To(gomega.BeTrue())
+			gomega.Expect(wait).To(gomega.Equal(1500 * time.Millisecond))
+
+			wait, ok = parseRetryAfter(http.Header{"Retry-After": []string{now.Add(5 * time.Second).Format(http.TimeFormat)}}, now)
+			gomega.Expect(ok).To(gomega.BeTrue())
+			gomega.Expect(wait).To(gomega.Equal(5 * time.Second))
+
+			wait, ok = parseRetryAfter(http.Header{"Ratelimit-Reset": []string{"1704067210"}}, now)
+			gomega.Expect(ok).To(gomega.BeTrue())
+			gomega.Expect(wait).To(gomega.Equal(10 * time.Second))
+
+			_, ok = parseRetryAfter(http.Header{}, now)
+			gomega.Expect(ok).To(gomega.BeFalse())
+		})
+
+		ginkgo.It("Should unwrap the status error to the sentinel error", func() {
+			err := errors.Wrap(NewStatusError(http.StatusUnauthorized, "401 Unauthorized"), "Failed to get merge request")
+			gomega.Expect(errors.Is(err, ErrorUnauthorized)).To(gomega.BeTrue())
+			gomega.Expect(errors.Is(err, ErrorNotFound)).To(gomega.BeFalse())
+			gomega.Expect(errors.Is(NewStatusError(http.StatusBadGateway, ""), ErrorServer)).To(gomega.BeTrue())
+			gomega.Expect(errors.Is(NewStatusError(http.StatusBadRequest, ""), ErrorServer)).To(gomega.BeFalse())
+		})
+	})
+
+	ginkgo.RunSpecs(t, "Transport test")
+}
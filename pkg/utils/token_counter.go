@@ -6,10 +6,14 @@ import (
 
 const (
 	ModelGPT4OMini = "gpt-4o-mini"
+
+	// fallbackEncoding estimates the tokens of the models unknown to tiktoken, e.g. Claude or the local models,
+	// whose tokenizers are either unpublished or too many to bundle
+	fallbackEncoding = tiktoken.MODEL_CL100K_BASE
 )
 
 func CountTokens(modelName, content string) (int64, error) {
-	tkm, err := tiktoken.EncodingForModel(modelName)
+	tkm, err := encodingForModel(modelName)
 	if err != nil {
 		return 0, err
 	}
@@ -22,3 +26,10 @@ func CountTokens(modelName, content string) (int64, error) {
 func CountTokensWithGPT4OMini(content string) (int64, error) {
 	return CountTokens(ModelGPT4OMini, content)
 }
+
+func encodingForModel(modelName string) (*tiktoken.Tiktoken, error) {
+	if tkm, err := tiktoken.EncodingForModel(modelName); err == nil {
+		return tkm, nil
+	}
+	return tiktoken.GetEncoding(fallbackEncoding)
+}